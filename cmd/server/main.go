@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"flag"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/dengdeng-harmonyos/server/internal/config"
@@ -15,6 +18,9 @@ import (
 )
 
 func main() {
+	reencryptPushTokens := flag.Bool("reencrypt-push-tokens", false, "re-encrypt stored push tokens with the active key and exit")
+	flag.Parse()
+
 	// 初始化日志系统
 	logger.Init()
 	logger.Info("=== Dengdeng Push Server Starting ===")
@@ -26,6 +32,17 @@ func main() {
 	logger.Info("  Server Mode: %s", cfg.Server.Mode)
 	logger.Info("  Database: %s:%s/%s", cfg.Database.Host, cfg.Database.Port, cfg.Database.DBName)
 	logger.Info("  Huawei Push API: %s", cfg.HuaweiPush.PushAPIURL)
+	for _, secret := range cfg.Secrets {
+		if secret.Source == config.SecretSourceFile {
+			logger.Info("  Secret %s: %s (%s)", secret.Name, secret.Source, secret.Path)
+		} else {
+			logger.Info("  Secret %s: %s", secret.Name, secret.Source)
+		}
+	}
+	if err := cfg.SecretError(); err != nil {
+		logger.Error("Failed to load secrets: %v", err)
+		log.Fatalf("Failed to load secrets: %v", err)
+	}
 
 	// 初始化数据库
 	logger.Info("Connecting to database...")
@@ -52,7 +69,53 @@ func main() {
 	}
 	logger.Info("✓ App update policy manifest synced")
 
-	cleanupCancel := appservice.StartExpiredMessageCleanup(context.Background(), db.DB, 6*time.Hour)
+	encryptionService, err := appservice.NewEncryptionService(cfg.Security)
+	if err != nil {
+		logger.Error("Failed to initialize push token encryption: %v", err)
+		log.Fatalf("Failed to initialize push token encryption: %v", err)
+	}
+	logger.Info("  Push token keys: %v (active: %s)", encryptionService.KeyIDs(), encryptionService.ActiveKeyID())
+
+	logger.Info("Backfilling push token blind index...")
+	backfill, err := appservice.BackfillPushTokenIndex(context.Background(), db.DB, encryptionService)
+	if err != nil {
+		logger.Error("Failed to backfill push token index: %v", err)
+		log.Fatalf("Failed to backfill push token index: %v", err)
+	}
+	logger.Info("✓ Push token index ready (indexed=%d, merged=%d, failed=%d)", backfill.Indexed, backfill.Merged, backfill.Failed)
+
+	if *reencryptPushTokens {
+		result, err := appservice.ReencryptPushTokens(context.Background(), db.DB, encryptionService)
+		if err != nil {
+			logger.Error("Failed to re-encrypt push tokens: %v", err)
+			log.Fatalf("Failed to re-encrypt push tokens: %v", err)
+		}
+		logger.Info("✓ Push token re-encryption done: total=%d, re-encrypted=%d, failed=%d", result.Total, result.Reencrypted, result.Failed)
+		return
+	}
+	appservice.StartPushTokenReencryption(context.Background(), db.DB, encryptionService)
+
+	messageSigner, err := appservice.NewMessageSigner(cfg.Security, encryptionService)
+	if err != nil {
+		logger.Error("Failed to initialize message signing key: %v", err)
+		log.Fatalf("Failed to initialize message signing key: %v", err)
+	}
+	logger.Info("  Message signing key: %s (%s)", messageSigner.KeyID(), messageSigner.Source())
+
+	backfilledKeys, err := appservice.BackfillDeviceKeys(context.Background(), db.DB)
+	if err != nil {
+		logger.Error("Failed to backfill device keys: %v", err)
+		log.Fatalf("Failed to backfill device keys: %v", err)
+	}
+	if backfilledKeys > 0 {
+		logger.Info("✓ Device keyring backfilled for %d devices", backfilledKeys)
+	}
+
+	cleanupCancel := appservice.StartExpiredMessageCleanup(context.Background(), db.DB, appservice.CleanupOptions{
+		Interval:           time.Hour,
+		AuditRetention:     time.Duration(cfg.Security.AuditRetentionDays) * 24 * time.Hour,
+		ConfirmedRetention: time.Duration(cfg.Security.ConfirmRetentionHours) * time.Hour,
+	})
 	defer cleanupCancel()
 	logger.Info("✓ Expired pending message cleanup scheduled")
 
@@ -64,79 +127,157 @@ func main() {
 	// 创建路由（不使用默认中间件）
 	router := gin.New()
 
+	// 只采信可信反向代理转发的客户端IP，IP访问控制和API Key记录依赖 c.ClientIP()
+	if err := router.SetTrustedProxies(cfg.Network.TrustedProxies); err != nil {
+		logger.Error("Invalid TRUSTED_PROXIES: %v", err)
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	senderAccess := mustIPAccessList("sender", cfg.Network.Sender)
+	deviceAccess := mustIPAccessList("device", cfg.Network.Device)
+	adminAccess := mustIPAccessList("admin", cfg.Network.Admin)
+
 	// 使用自定义中间件
 	router.Use(logger.GinRecovery())
+	router.Use(middleware.RequestID())
 	router.Use(logger.GinLogger())
-	router.Use(middleware.CORS())
 
 	// 初始化处理器
 	logger.Info("Initializing handlers...")
-	deviceHandler, err := handler.NewDeviceHandler(db, *cfg)
-	if err != nil {
-		logger.Error("Failed to create device handler: %v", err)
-		log.Fatalf("Failed to create device handler: %v", err)
-	}
+	deviceHandler := handler.NewDeviceHandler(db, encryptionService, *cfg)
 	logger.Info("✓ Device handler initialized")
 
-	pushHandler, err := handler.NewPushHandler(db, deviceHandler, cfg.HuaweiPush, cfg.Server.ServerName)
+	apiKeyService := appservice.NewAPIKeyService(db.DB)
+	if cfg.Security.RequireSenderAPIKey {
+		logger.Info("  Sender API key: required")
+	}
+
+	pushHandler, err := handler.NewPushHandler(db, deviceHandler, apiKeyService, messageSigner, cfg.HuaweiPush, cfg.Server.ServerName)
 	if err != nil {
 		logger.Error("Failed to create push handler: %v", err)
 		log.Fatalf("Failed to create push handler: %v", err)
 	}
+	deviceHandler.SetTokenProofSender(pushHandler)
 	logger.Info("✓ Push handler initialized")
 
+	// 实时消息流：通过 Postgres LISTEN/NOTIFY 接收所有实例保存的新消息
+	messageHub := appservice.NewMessageHub()
+	if err := messageHub.Listen(context.Background(), cfg.Database.DSN()); err != nil {
+		logger.Error("Failed to listen for pending messages: %v", err)
+		log.Fatalf("Failed to listen for pending messages: %v", err)
+	}
+
 	// 创建消息处理器
-	messageHandler := handler.NewMessageHandler(db.DB)
+	messageHandler := handler.NewMessageHandler(db.DB, messageSigner, messageHub, pushHandler)
 	logger.Info("✓ Message handler initialized")
 
 	appUpdateHandler := handler.NewAppUpdateHandler(db.DB, cfg.AppUpdate)
 	logger.Info("✓ App update handler initialized")
 
+	serverKeyHandler := handler.NewServerKeyHandler(messageSigner)
+
 	diagnosticsHandler := handler.NewDiagnosticsHandler(db.DB)
 	logger.Info("✓ Diagnostics handler initialized")
 
+	adminHandler := handler.NewAdminHandler(db.DB, apiKeyService, senderAccess, deviceAccess, adminAccess)
+	if cfg.Security.AdminToken == "" {
+		logger.Info("Admin API disabled (ADMIN_TOKEN not set)")
+	}
+
+	senderAuth := func(scope string) gin.HandlerFunc {
+		return middleware.SenderAuth(apiKeyService, cfg.Security.RequireSenderAPIKey, scope)
+	}
+
 	// API v1 路由
 	v1 := router.Group("/api/v1")
 	{
 		// 设备管理
-		device := v1.Group("/device")
+		device := corsGroup(v1, "/device", cfg.CORS.Device, middleware.IPFilter(deviceAccess))
 		{
-			device.POST("/register", deviceHandler.Register)       // 注册设备，返回device_id
-			device.PUT("/update-token", deviceHandler.UpdateToken) // 更新Push Token
-			device.DELETE("/delete", deviceHandler.Delete)         // 删除设备
+			device.POST("/register", deviceHandler.Register)                 // 注册设备，返回device_id
+			device.PUT("/update-token", deviceHandler.UpdateToken)           // 更新Push Token
+			device.DELETE("/delete", deviceHandler.Delete)                   // 删除设备
+			device.PUT("/privacy", deviceHandler.UpdatePrivacy)              // 设置通知隐私级别
+			device.GET("/keys", deviceHandler.ListKeys)                      // 设备公钥环
+			device.POST("/keys/challenge", deviceHandler.CreateKeyChallenge) // 申请公钥轮换挑战
+			device.POST("/keys/rotate", deviceHandler.RotateKey)             // 证明持有旧私钥后轮换公钥
+			device.POST("/pairing-code", deviceHandler.CreatePairingCode)    // 生成接收者配对码
+			device.POST("/pair", deviceHandler.PairDevice)                   // 用配对码加入接收者
+			device.GET("/recipient", deviceHandler.GetRecipient)             // 查询所属接收者及其设备
+			device.DELETE("/recipient", deviceHandler.LeaveRecipient)        // 退出接收者
+			device.GET("/topics", deviceHandler.ListTopics)                  // 查询所在分组和订阅的主题
+			device.POST("/topics", deviceHandler.SubscribeTopic)             // 订阅主题
+			device.DELETE("/topics", deviceHandler.UnsubscribeTopic)         // 取消订阅主题
+			device.POST("/grants", deviceHandler.CreateSendGrant)            // 签发发送授权
+			device.GET("/grants", deviceHandler.ListSendGrants)              // 列出发送授权
+			device.DELETE("/grants/:id", deviceHandler.RevokeSendGrant)      // 吊销发送授权
 		}
 
 		// 推送消息（GET方式，方便直接调用）
-		push := v1.Group("/push")
+		push := corsGroup(v1, "/push", cfg.CORS.Public, middleware.IPFilter(senderAccess))
 		{
-			push.GET("/notification", pushHandler.SendNotification) // 发送通知消息
+			push.GET("/notification", senderAuth(appservice.ScopeNotification), pushHandler.SendNotification) // 发送通知消息
+			push.GET("/public-key", senderAuth(appservice.ScopeNotification), pushHandler.GetDevicePublicKey) // 获取设备公钥（推送方本地加密）
+			push.POST("/encrypted", senderAuth(appservice.ScopeNotification), pushHandler.SendEncrypted)      // 发送预加密消息
+			push.POST("/recall", senderAuth(appservice.ScopeManage), pushHandler.RecallMessage)               // 撤回未确认的消息
+			push.GET("/status", senderAuth(appservice.ScopeManage), pushHandler.MessageStatus)                // 查询消息投递状态
+			push.GET("/recipient", senderAuth(appservice.ScopeNotification), pushHandler.SendToRecipient)     // 推送到接收者的所有设备
+			// 分组名可被猜到，无论 SENDER_API_KEY_REQUIRED 如何设置都必须携带 API Key
+			push.GET("/group", middleware.SenderAuth(apiKeyService, true, appservice.ScopeBatch), pushHandler.SendToGroup) // 推送到分组或主题的所有成员
 		}
 
-		messages := v1.Group("/messages")
+		messages := corsGroup(v1, "/messages", cfg.CORS.Device, middleware.IPFilter(deviceAccess))
 		{
-			messages.GET("/pending", messageHandler.GetPendingMessages) // 获取待接收消息
-			messages.POST("/confirm", messageHandler.ConfirmMessages)   // 确认消息已收到
+			messages.GET("/pending", messageHandler.GetPendingMessages)   // 获取待接收消息
+			messages.POST("/confirm", messageHandler.ConfirmMessages)     // 确认消息已收到
+			messages.GET("/stream", messageHandler.StreamPendingMessages) // 实时消息流（SSE）
+			messages.PUT("/last-seen", messageHandler.ReportLastSeen)     // 上报已处理的消息序号
 		}
 
-		app := v1.Group("/app")
+		app := corsGroup(v1, "/app", cfg.CORS.Device, middleware.IPFilter(deviceAccess))
 		{
 			app.GET("/update", appUpdateHandler.Check) // 检查App强制更新策略
 		}
 
-		diagnostics := v1.Group("/diagnostics")
+		server := corsGroup(v1, "/server", cfg.CORS.Public, middleware.IPFilter(deviceAccess))
+		{
+			server.GET("/signing-key", serverKeyHandler.SigningKey) // 服务端消息签名公钥
+		}
+
+		diagnostics := corsGroup(v1, "/diagnostics", cfg.CORS.Device, middleware.IPFilter(deviceAccess))
 		{
 			diagnostics.GET("/device", diagnosticsHandler.Device) // 非敏感设备诊断
 		}
+
+		// 管理接口（需要 ADMIN_TOKEN）
+		// 跨域预检在鉴权之前完成，预检请求不携带 Authorization
+		admin := corsGroup(v1, "/admin", cfg.CORS.Admin, middleware.IPFilter(adminAccess), middleware.AdminAuth(cfg.Security.AdminToken))
+		{
+			admin.POST("/api-keys", adminHandler.CreateAPIKey)                               // 签发推送方API Key
+			admin.GET("/api-keys", adminHandler.ListAPIKeys)                                 // 列出API Key
+			admin.DELETE("/api-keys/:id", adminHandler.RevokeAPIKey)                         // 吊销API Key
+			admin.GET("/groups", adminHandler.ListGroups)                                    // 列出分组
+			admin.GET("/groups/:name/devices", adminHandler.ListGroupMembers)                // 列出分组成员
+			admin.POST("/groups/:name/devices", adminHandler.AddGroupMembers)                // 分配设备到分组
+			admin.DELETE("/groups/:name/devices/:device_id", adminHandler.RemoveGroupMember) // 从分组移除设备
+			admin.GET("/access-stats", adminHandler.AccessStats)                             // IP访问控制拒绝计数
+			admin.GET("/audit-events", adminHandler.ListAuditEvents)                         // 查询审计日志
+		}
 	}
 
 	// 健康检查（支持GET和HEAD）
-	router.GET("/health", func(c *gin.Context) {
+	healthCORS := middleware.CORS(cfg.CORS.Public)
+	if cfg.CORS.Public.Enabled() {
+		router.OPTIONS("/health", healthCORS, middleware.Preflight)
+	}
+	router.GET("/health", healthCORS, func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"status":       "ok",
 			"version":      cfg.Server.Version,
 			"build":        cfg.Server.Build,
 			"apiVersion":   cfg.Server.APIVersion,
 			"capabilities": cfg.Server.Capabilities,
+			"signingKeyId": messageSigner.KeyID(),
 			"upgradeUrl":   cfg.Server.UpgradeURL,
 			"service":      "Dengdeng Push Server (Huawei Push Kit v3)",
 		})
@@ -146,15 +287,89 @@ func main() {
 	})
 
 	// 启动服务器
+	scheme := "http"
+	if cfg.TLS.Enabled() {
+		scheme = "https"
+	}
 	logger.Info("===========================================")
 	logger.Info("🚀 Server is ready!")
-	logger.Info("   Listening on: http://0.0.0.0:%s", cfg.Server.Port)
-	logger.Info("   Health check: http://0.0.0.0:%s/health", cfg.Server.Port)
-	logger.Info("   Push endpoint: http://0.0.0.0:%s/api/v1/push/notification", cfg.Server.Port)
+	logger.Info("   Listening on: %s://0.0.0.0:%s", scheme, cfg.Server.Port)
+	logger.Info("   Health check: %s://0.0.0.0:%s/health", scheme, cfg.Server.Port)
+	logger.Info("   Push endpoint: %s://0.0.0.0:%s/api/v1/push/notification", scheme, cfg.Server.Port)
 	logger.Info("===========================================")
 
-	if err := router.Run(":" + cfg.Server.Port); err != nil {
+	if !cfg.TLS.Enabled() {
+		if err := router.Run(":" + cfg.Server.Port); err != nil {
+			logger.Error("Failed to start server: %v", err)
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		return
+	}
+
+	if err := runTLS(router, cfg.Server.Port, cfg.TLS); err != nil {
 		logger.Error("Failed to start server: %v", err)
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// runTLS 使用证书文件提供HTTPS服务，证书文件更新后自动重新加载；
+// 配置了重定向端口时同时监听HTTP并重定向到HTTPS
+func runTLS(router *gin.Engine, port string, tlsCfg config.TLSConfig) error {
+	minVersion, err := appservice.ParseTLSMinVersion(tlsCfg.MinVersion)
+	if err != nil {
+		return err
+	}
+
+	reloader, err := appservice.NewCertificateReloader(tlsCfg.CertFile, tlsCfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	if tlsCfg.ReloadInterval > 0 {
+		go reloader.Watch(context.Background(), time.Duration(tlsCfg.ReloadInterval)*time.Second)
+	}
+	logger.Info("✓ TLS enabled: cert=%s minVersion=%s reloadInterval=%ds", tlsCfg.CertFile, tlsCfg.MinVersion, tlsCfg.ReloadInterval)
+
+	if tlsCfg.HTTPRedirectPort != "" {
+		redirectServer := &http.Server{
+			Addr:              ":" + tlsCfg.HTTPRedirectPort,
+			Handler:           handler.HTTPSRedirect(port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			logger.Info("   HTTP redirect on: http://0.0.0.0:%s", tlsCfg.HTTPRedirectPort)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("HTTP redirect listener stopped: %v", err)
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+		TLSConfig: &tls.Config{
+			MinVersion:     minVersion,
+			GetCertificate: reloader.GetCertificate,
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// corsGroup 创建使用指定跨域策略的路由组；开启跨域时注册 OPTIONS 兜底路由以处理预检
+func corsGroup(parent *gin.RouterGroup, path string, policy config.CORSPolicy, handlers ...gin.HandlerFunc) *gin.RouterGroup {
+	group := parent.Group(path, append([]gin.HandlerFunc{middleware.CORS(policy)}, handlers...)...)
+	if policy.Enabled() {
+		group.OPTIONS("/*path", middleware.Preflight)
+	}
+	return group
+}
+
+// mustIPAccessList 解析路由组的IP访问规则，配置错误时终止启动
+func mustIPAccessList(name string, rule config.IPAccessRule) *middleware.IPAccessList {
+	list, err := middleware.NewIPAccessList(name, rule)
+	if err != nil {
+		logger.Error("Invalid IP access rules: %v", err)
+		log.Fatalf("Invalid IP access rules: %v", err)
+	}
+	return list
+}
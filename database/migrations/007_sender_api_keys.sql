@@ -0,0 +1,46 @@
+-- Migration: 007_sender_api_keys
+-- Description: Sender API keys with scopes, quotas and device group bindings
+-- Date: 2026-10-19
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(128) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    allowed_device_ids TEXT[] NOT NULL DEFAULT '{}',
+    allowed_groups TEXT[] NOT NULL DEFAULT '{}',
+    rate_limit_per_minute INTEGER NOT NULL DEFAULT 0,
+    daily_quota INTEGER NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip VARCHAR(64),
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_key_daily_usage (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    usage_date DATE NOT NULL,
+    push_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, usage_date)
+);
+
+CREATE TABLE IF NOT EXISTS device_group_members (
+    group_name VARCHAR(64) NOT NULL,
+    device_id UUID NOT NULL,
+    source VARCHAR(16) NOT NULL DEFAULT 'admin',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_name, device_id),
+    CONSTRAINT fk_group_member_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_members_device_id ON device_group_members(device_id);
+
+COMMENT ON TABLE api_keys IS 'Sender API keys. Only the SHA-256 hash of the key is stored.';
+COMMENT ON COLUMN api_keys.scopes IS 'Allowed routes: notification, batch (group push), manage (recall and status).';
+COMMENT ON COLUMN api_keys.allowed_device_ids IS 'Devices the key may push to. Empty together with allowed_groups means all devices.';
+COMMENT ON COLUMN api_keys.allowed_groups IS 'Device groups the key may push to.';
+COMMENT ON COLUMN api_keys.rate_limit_per_minute IS 'Per-key request limit per minute, 0 disables the limit.';
+COMMENT ON COLUMN api_keys.daily_quota IS 'Per-key pushes per UTC day, 0 disables the quota.';
+COMMENT ON TABLE device_group_members IS 'Device group membership used to scope API keys.';
@@ -0,0 +1,15 @@
+-- Migration: 008_push_token_blind_index
+-- Description: Keyed HMAC blind index for push token lookup and uniqueness
+-- Date: 2026-10-19
+-- NOTE: Existing rows are backfilled by the server at startup, because the
+--       index is computed from the decrypted token with the server key.
+--       Devices that share a push token are merged into the most recently
+--       active one and their pending messages are moved to it, together with
+--       the keys they are encrypted to (see 023 for the device binding).
+
+ALTER TABLE devices
+ADD COLUMN IF NOT EXISTS push_token_hash CHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_devices_push_token_hash ON devices(push_token_hash);
+
+COMMENT ON COLUMN devices.push_token_hash IS 'HMAC-SHA256 blind index of the plaintext push token';
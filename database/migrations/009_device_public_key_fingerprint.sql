@@ -0,0 +1,10 @@
+-- Migration: 009_device_public_key_fingerprint
+-- Description: Store the fingerprint of the validated device public key
+-- Date: 2026-10-19
+-- NOTE: Keys registered before validation existed keep a NULL fingerprint
+--       until the device registers again.
+
+ALTER TABLE devices
+ADD COLUMN IF NOT EXISTS public_key_fingerprint VARCHAR(64);
+
+COMMENT ON COLUMN devices.public_key_fingerprint IS 'SHA256:<base64> digest of the DER encoded SubjectPublicKeyInfo';
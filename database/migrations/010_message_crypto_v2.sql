@@ -0,0 +1,14 @@
+-- Migration: 010_message_crypto_v2
+-- Description: Message crypto v2 (X25519 ECDH + HKDF-SHA256 + ChaCha20-Poly1305)
+-- Date: 2026-10-19
+-- NOTE: The scheme is chosen per device from the uploaded public key type.
+--       Existing rows are v1 (RSA-OAEP wrapped AES-256-GCM key).
+
+ALTER TABLE pending_messages
+ADD COLUMN IF NOT EXISTS crypto_version SMALLINT NOT NULL DEFAULT 1;
+
+ALTER TABLE pending_messages
+ADD COLUMN IF NOT EXISTS ephemeral_public_key TEXT;
+
+COMMENT ON COLUMN pending_messages.crypto_version IS '1: RSA-OAEP + AES-256-GCM, 2: X25519 + HKDF-SHA256 + ChaCha20-Poly1305';
+COMMENT ON COLUMN pending_messages.ephemeral_public_key IS 'Base64 ephemeral X25519 public key (v2 only)';
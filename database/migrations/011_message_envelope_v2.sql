@@ -0,0 +1,13 @@
+-- Migration: 011_message_envelope_v2
+-- Description: Bind message ciphertext to device, message ID, server name and creation time
+-- Date: 2026-10-19
+-- NOTE: Envelope v2 uses the AEAD associated data
+--           dengdeng/message-ad/v2\n<device_id>\n<message_id>\n<created_at>\n<server_name>
+--       where created_at is UTC with millisecond precision. Message IDs and
+--       creation times are now assigned by the server before encryption.
+--       Existing rows are envelope v1 (no associated data).
+
+ALTER TABLE pending_messages
+ADD COLUMN IF NOT EXISTS envelope_version SMALLINT NOT NULL DEFAULT 1;
+
+COMMENT ON COLUMN pending_messages.envelope_version IS '1: no associated data, 2: AEAD associated data binds device_id, id, created_at and server_name';
@@ -0,0 +1,42 @@
+-- Migration: 012_device_keyring
+-- Description: Device public key rotation with key IDs and validity windows
+-- Date: 2026-10-19
+-- NOTE: Each device has one current key (valid_until IS NULL). Rotated keys
+--       are retired and kept until no pending message references them, so
+--       the app can still decrypt messages encrypted before the rotation.
+--       Existing device keys are backfilled by the server at startup.
+
+CREATE TABLE IF NOT EXISTS device_keys (
+    device_id UUID NOT NULL,
+    key_id VARCHAR(32) NOT NULL,
+    public_key TEXT NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    crypto_version SMALLINT NOT NULL DEFAULT 1,
+    valid_from TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    valid_until TIMESTAMPTZ,
+    PRIMARY KEY (device_id, key_id),
+    CONSTRAINT fk_device_key_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS device_key_challenges (
+    id UUID PRIMARY KEY,
+    device_id UUID NOT NULL,
+    key_id VARCHAR(32) NOT NULL,
+    response_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    CONSTRAINT fk_key_challenge_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+);
+
+ALTER TABLE devices
+ADD COLUMN IF NOT EXISTS public_key_id VARCHAR(32);
+
+ALTER TABLE pending_messages
+ADD COLUMN IF NOT EXISTS key_id VARCHAR(32);
+
+CREATE INDEX IF NOT EXISTS idx_pending_device_key ON pending_messages(device_id, key_id);
+
+COMMENT ON TABLE device_keys IS 'Device public keyring. Retired keys are removed once their pending messages are drained.';
+COMMENT ON COLUMN device_keys.key_id IS 'First 16 hex characters of SHA-256 over the DER SubjectPublicKeyInfo';
+COMMENT ON TABLE device_key_challenges IS 'Key rotation challenges encrypted to the current key. Only the SHA-256 of the answer is stored.';
+COMMENT ON COLUMN pending_messages.key_id IS 'ID of the device key the message was encrypted to';
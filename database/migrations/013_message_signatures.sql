@@ -0,0 +1,15 @@
+-- Migration: 013_message_signatures
+-- Description: Server Ed25519 signatures over stored message envelopes
+-- Date: 2026-10-19
+-- NOTE: The signature covers the envelope ciphertext and its metadata (see
+--       MessageSignaturePayload). The public key is published at
+--       GET /api/v1/server/signing-key. Existing rows stay unsigned.
+
+ALTER TABLE pending_messages
+ADD COLUMN IF NOT EXISTS signature TEXT;
+
+ALTER TABLE pending_messages
+ADD COLUMN IF NOT EXISTS signing_key_id VARCHAR(32);
+
+COMMENT ON COLUMN pending_messages.signature IS 'Base64 Ed25519 signature by the server signing key';
+COMMENT ON COLUMN pending_messages.signing_key_id IS 'First 16 hex characters of SHA-256 over the raw Ed25519 public key';
@@ -0,0 +1,12 @@
+-- Migration: 014_device_privacy_level
+-- Description: Per-device notification privacy level
+-- Date: 2026-10-19
+-- NOTE: 'private' devices only receive a placeholder notification
+--       ("你收到一条来自 <server> 的新消息") plus the message ID; the real
+--       title and content stay inside the encrypted pending message.
+--       Senders may request private per push but cannot downgrade a device.
+
+ALTER TABLE devices
+ADD COLUMN IF NOT EXISTS privacy_level VARCHAR(16) NOT NULL DEFAULT 'standard';
+
+COMMENT ON COLUMN devices.privacy_level IS 'Notification privacy level: standard or private';
@@ -0,0 +1,38 @@
+-- Migration: 015_audit_events
+-- Description: Append-only audit log of device lifecycle and admin events
+-- Date: 2026-10-19
+-- NOTE: Rows are never updated; the cleanup job deletes rows older than
+--       AUDIT_RETENTION_DAYS (0 keeps them forever). device_id has no
+--       foreign key so history survives device deletion.
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(64) NOT NULL,
+    actor_type VARCHAR(16) NOT NULL,
+    actor_id VARCHAR(64),
+    device_id UUID,
+    client_ip VARCHAR(64),
+    user_agent VARCHAR(256),
+    request_id VARCHAR(64),
+    details JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_device ON audit_events(device_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events(event_type, id);
+
+CREATE OR REPLACE FUNCTION audit_events_reject_update() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER audit_events_append_only
+    BEFORE UPDATE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_reject_update();
+
+COMMENT ON TABLE audit_events IS 'Append-only audit log of registrations, token updates, deletions, key rotations, app policy changes and admin actions';
+COMMENT ON COLUMN audit_events.actor_type IS 'device, api_key, admin, system or anonymous';
+COMMENT ON COLUMN audit_events.actor_id IS 'API key ID or device ID, depending on actor_type';
+COMMENT ON COLUMN audit_events.request_id IS 'X-Request-ID of the originating HTTP request';
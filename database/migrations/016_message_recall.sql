@@ -0,0 +1,10 @@
+-- Migration: 016_message_recall
+-- Description: Record the sender API key of pending messages for recall
+-- Date: 2026-10-19
+-- NOTE: Messages sent with an API key can only be recalled with the same key.
+--       Messages sent without a key keep NULL and can be recalled by anyone
+--       holding the message_id returned at send time.
+
+ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS sender_key_id UUID;
+
+COMMENT ON COLUMN pending_messages.sender_key_id IS 'Sender API key that created the message, NULL for unauthenticated senders';
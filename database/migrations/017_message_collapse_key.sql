@@ -0,0 +1,17 @@
+-- Migration: 017_message_collapse_key
+-- Description: Collapse keys and stored notification IDs for pending messages
+-- Date: 2026-10-19
+-- NOTE: A message sent with a collapse_key replaces the device notification
+--       of earlier messages with the same key (same Push Kit notifyId) and
+--       deletes their unconfirmed pending rows. Keys are scoped per sender
+--       API key. notify_id is stored so recall can revoke the notification.
+
+ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS collapse_key VARCHAR(64);
+ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS notify_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_pending_collapse
+ON pending_messages(device_id, collapse_key)
+WHERE collapse_key IS NOT NULL AND delivered = false;
+
+COMMENT ON COLUMN pending_messages.collapse_key IS 'Sender-chosen key; a newer message with the same key supersedes this one';
+COMMENT ON COLUMN pending_messages.notify_id IS 'Push Kit notifyId used to replace or revoke the notification';
@@ -0,0 +1,23 @@
+-- Migration: 018_soft_message_confirmation
+-- Description: Confirm marks messages delivered instead of deleting them
+-- Date: 2026-10-19
+-- NOTE: ConfirmMessages now sets delivered/confirmed_at and clears the
+--       ciphertext columns at once. The cleanup job deletes confirmed rows
+--       after CONFIRMED_MESSAGE_RETENTION_HOURS (default 0) so senders can
+--       query the delivery status in the meantime.
+
+DROP FUNCTION IF EXISTS clean_expired_messages();
+
+CREATE OR REPLACE FUNCTION clean_expired_messages(confirmed_retention INTERVAL DEFAULT INTERVAL '0') RETURNS void AS $$
+BEGIN
+    DELETE FROM pending_messages
+    WHERE expires_at < NOW()
+       OR (delivered = true AND confirmed_at <= NOW() - confirmed_retention);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE INDEX IF NOT EXISTS idx_pending_confirmed
+ON pending_messages(confirmed_at)
+WHERE delivered = true;
+
+COMMENT ON COLUMN pending_messages.confirmed_at IS 'Set when the device confirms; ciphertext is cleared at the same time';
@@ -0,0 +1,22 @@
+-- Migration: 019_message_sequence
+-- Description: Per-device message sequence numbers for gap detection
+-- Date: 2026-10-19
+-- NOTE: Existing pending messages are left with seq = NULL here. Migration
+--       022 (and InitTables on startup) numbers them with non-positive seqs
+--       below every assigned one, oldest first. The API reports those as
+--       seq 0, and the app only checks gaps between positive seqs.
+
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS message_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_seq_at TIMESTAMPTZ;
+
+ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pending_device_seq
+ON pending_messages(device_id, seq)
+WHERE seq IS NOT NULL;
+
+COMMENT ON COLUMN devices.message_seq IS 'Last sequence number assigned to a message for this device';
+COMMENT ON COLUMN devices.last_seen_seq IS 'Highest sequence number the app reported as processed';
+COMMENT ON COLUMN devices.last_seen_seq_at IS 'When the app last reported last_seen_seq';
+COMMENT ON COLUMN pending_messages.seq IS 'Per-device sequence number, assigned in the insert transaction';
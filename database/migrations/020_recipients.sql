@@ -0,0 +1,40 @@
+-- Migration: 020_recipients
+-- Description: Recipients group one person's devices for fan-out pushes
+-- Date: 2026-10-19
+-- NOTE: A device joins a recipient with a single-use pairing code issued on
+--       a device that already belongs to it. A push to a recipient stores one
+--       copy per device, encrypted to that device's key; the copies share
+--       recipient_message_id so confirming one confirms the others.
+
+CREATE TABLE IF NOT EXISTS recipients (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS recipient_id UUID REFERENCES recipients(id) ON DELETE SET NULL;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS recipient_joined_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_devices_recipient
+ON devices(recipient_id)
+WHERE recipient_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS recipient_pairing_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    recipient_id UUID NOT NULL REFERENCES recipients(id) ON DELETE CASCADE,
+    device_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    CONSTRAINT fk_pairing_code_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+);
+
+ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS recipient_message_id UUID;
+
+CREATE INDEX IF NOT EXISTS idx_pending_recipient_message
+ON pending_messages(recipient_message_id)
+WHERE recipient_message_id IS NOT NULL;
+
+COMMENT ON TABLE recipients IS 'A person owning several devices; pushes to a recipient reach all of them';
+COMMENT ON COLUMN devices.recipient_id IS 'Recipient the device joined with a pairing code, NULL when standalone';
+COMMENT ON TABLE recipient_pairing_codes IS 'Single-use pairing codes (SHA-256 of the code), valid for 10 minutes';
+COMMENT ON COLUMN recipient_pairing_codes.device_id IS 'Device that issued the code';
+COMMENT ON COLUMN pending_messages.recipient_message_id IS 'Shared by the per-device copies of one recipient push';
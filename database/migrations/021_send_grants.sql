@@ -0,0 +1,16 @@
+-- Migration: 021_send_grants
+-- Description: Send grants let a device issue its own revocable sender keys
+-- Date: 2026-10-19
+-- NOTE: A send grant is an api_keys row owned by a device. It may only push
+--       notifications to that device, so the device_id never has to leave
+--       the phone. Grants may expire; they are deleted with their device.
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS owner_device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_owner_device
+ON api_keys(owner_device_id)
+WHERE owner_device_id IS NOT NULL;
+
+COMMENT ON COLUMN api_keys.owner_device_id IS 'Device that issued this key as a send grant, NULL for operator keys';
+COMMENT ON COLUMN api_keys.expires_at IS 'Key is rejected after this time, NULL when it never expires';
@@ -0,0 +1,19 @@
+-- Migration: 022_pending_message_seq_cursor
+-- Description: Number pending messages stored before 019 so they can be paged by seq
+-- Date: 2026-10-19
+-- NOTE: GetPendingMessages pages by seq, which is assigned under the device
+--       row lock in the insert transaction and commits in order.
+--       Messages stored before 019 have no seq. They are numbered below every
+--       assigned seq, oldest first, so they are still delivered first; the API
+--       reports them as seq 0 and gap detection ignores them.
+
+UPDATE pending_messages p
+SET seq = numbered.seq
+FROM (
+    SELECT m.id,
+           LEAST(COALESCE((SELECT MIN(n.seq) FROM pending_messages n WHERE n.device_id = m.device_id), 1), 1)
+           - ROW_NUMBER() OVER (PARTITION BY m.device_id ORDER BY m.created_at DESC, m.id::TEXT DESC) AS seq
+    FROM pending_messages m
+    WHERE m.seq IS NULL
+) numbered
+WHERE p.id = numbered.id;
@@ -0,0 +1,12 @@
+-- Migration: 023_pending_message_bound_device
+-- Description: Keep the original device binding of messages moved by a device merge
+-- Date: 2026-10-19
+-- NOTE: A merged duplicate's pending messages move to the surviving device.
+--       Their AEAD associated data and signature bind the duplicate's
+--       device_id, so it is kept here and returned to the app. NULL means
+--       the message is bound to its own device_id.
+
+ALTER TABLE pending_messages
+ADD COLUMN IF NOT EXISTS bound_device_id UUID;
+
+COMMENT ON COLUMN pending_messages.bound_device_id IS 'device_id bound by the ciphertext and signature when it differs from device_id';
@@ -80,6 +80,8 @@ services:
     environment:
       - SERVER_NAME=\${SERVER_NAME}
       - PUSH_TOKEN_ENCRYPTION_KEY=\${PUSH_TOKEN_ENCRYPTION_KEY}
+      - PUSH_TOKEN_INDEX_KEY_ID=\${PUSH_TOKEN_INDEX_KEY_ID:-legacy}
+      - SERVER_SIGNING_KEY_ID=\${SERVER_SIGNING_KEY_ID:-legacy}
     ports:
       - "$PORT:8080"
     volumes:
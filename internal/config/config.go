@@ -12,6 +12,10 @@ type Config struct {
 	HuaweiPush HuaweiPushConfig
 	Security   SecurityConfig
 	AppUpdate  AppUpdateConfig
+	CORS       CORSConfig
+	Network    NetworkConfig
+	TLS        TLSConfig
+	Secrets    []SecretSource // 各密钥的来源，仅用于启动日志
 }
 
 type ServerConfig struct {
@@ -23,6 +27,7 @@ type ServerConfig struct {
 	APIVersion   int64
 	Capabilities []string
 	UpgradeURL   string
+	PublicURL    string // 服务端对外访问地址，用于生成发送授权的分享链接
 }
 
 type DatabaseConfig struct {
@@ -34,17 +39,82 @@ type DatabaseConfig struct {
 	SSLMode  string
 }
 
+// DSN 返回 lib/pq 连接字符串，数据库连接池与 LISTEN 连接共用
+func (c DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode)
+}
+
 type HuaweiPushConfig struct {
 	ProjectID          string
-	ServiceAccountFile string // JWT私钥文件路径
+	ServiceAccountFile string // 服务账号密钥文件路径（HUAWEI_SERVICE_ACCOUNT_FILE），为空时使用环境变量或嵌入配置
+	ServiceAccountJSON string // 服务账号密钥JSON，按 文件 > 环境变量 > 嵌入 读取
 	JWTExpiry          int    // JWT过期时间（秒）
 	PushAPIURL         string
 }
 
 type SecurityConfig struct {
-	EncryptionKey         string // Push Token加密密钥（32字节）
-	DeviceIdTTL           int    // Device Id有效期（秒）
-	MaxDailyPushPerDevice int    // 每设备每日最大推送数
+	EncryptionKey         string   // Push Token加密密钥（32字节），在密钥环中的ID为legacy
+	EncryptionKeys        []string // 额外的Push Token加密密钥，格式为 "ID:密钥"
+	ActiveEncryptionKeyID string   // 用于加密的密钥ID，其余密钥仅用于解密
+	TokenIndexKey         string   // Push Token盲索引密钥（32字节）
+	TokenIndexKeyID       string   // 未配置盲索引密钥时，从该ID的加密密钥派生盲索引密钥
+	DeviceIdTTL           int      // Device Id有效期（秒）
+	MaxDailyPushPerDevice int      // 每设备每日最大推送数
+	AdminToken            string   // 管理接口Bearer Token，为空时关闭管理接口
+	RequireSenderAPIKey   bool     // 推送接口是否必须携带API Key
+	SigningKey            string   // 消息签名Ed25519密钥（base64）
+	SigningKeyID          string   // 未配置签名密钥时，从该ID的加密密钥派生签名密钥
+	AuditRetentionDays    int64    // 审计日志保留天数，0 表示永久保留
+	ConfirmRetentionHours int64    // 已确认消息状态的保留小时数，0 表示下次清理时删除；密文在确认时即清除
+}
+
+// CORSConfig 按路由组配置跨域策略
+type CORSConfig struct {
+	Public CORSPolicy // 推送、签名公钥和健康检查接口
+	Device CORSPolicy // 设备、消息、诊断等App调用的接口
+	Admin  CORSPolicy // 管理接口
+}
+
+// CORSPolicy 单个路由组的跨域策略，AllowedOrigins 为空时关闭跨域
+type CORSPolicy struct {
+	AllowedOrigins   []string // 允许的Origin，"*" 表示任意来源
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool // 仅对明确列出的Origin生效，"*" 匹配的来源不会携带凭据
+}
+
+// Enabled 是否开启跨域
+func (p CORSPolicy) Enabled() bool {
+	return len(p.AllowedOrigins) > 0
+}
+
+// NetworkConfig 反向代理和按路由组的IP访问控制
+type NetworkConfig struct {
+	TrustedProxies []string     // 可信反向代理（IP或CIDR），只有来自这些地址的 X-Forwarded-For 才会被采信
+	Sender         IPAccessRule // 推送接口
+	Device         IPAccessRule // 设备、消息、诊断和签名公钥接口（健康检查不受限，供容器探活）
+	Admin          IPAccessRule // 管理接口
+}
+
+// IPAccessRule IP或CIDR列表；Deny 优先，Allow 非空时只放行列表中的地址
+type IPAccessRule struct {
+	Allow []string
+	Deny  []string
+}
+
+// TLSConfig 内置HTTPS，CertFile 和 KeyFile 都设置时启用
+type TLSConfig struct {
+	CertFile         string
+	KeyFile          string
+	MinVersion       string // 1.2 或 1.3
+	HTTPRedirectPort string // 不为空时在该端口监听HTTP并重定向到HTTPS
+	ReloadInterval   int    // 检查证书文件变化的间隔（秒）
+}
+
+// Enabled 是否启用HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
 }
 
 type AppUpdateConfig struct {
@@ -64,38 +134,38 @@ type AgConnectServices struct {
 	} `json:"client"`
 }
 
-// loadProjectIDFromAgConnect 从嵌入的配置读取项目ID
-func loadProjectIDFromAgConnect() (string, error) {
-	// 使用嵌入的配置（编译时注入）
-	embeddedJSON := GetEmbeddedAgConnectJSON()
-	if embeddedJSON == "" {
-		return "", fmt.Errorf("embedded agconnect configuration is empty")
+// loadProjectIDFromAgConnect 从agconnect-services.json内容读取项目ID
+func loadProjectIDFromAgConnect(agConnectJSON string) (string, error) {
+	if agConnectJSON == "" {
+		return "", fmt.Errorf("agconnect configuration is empty")
 	}
 
 	var agConnect AgConnectServices
-	if err := json.Unmarshal([]byte(embeddedJSON), &agConnect); err != nil {
-		return "", fmt.Errorf("failed to parse embedded agconnect configuration: %w", err)
+	if err := json.Unmarshal([]byte(agConnectJSON), &agConnect); err != nil {
+		return "", fmt.Errorf("failed to parse agconnect configuration: %w", err)
 	}
 
 	if agConnect.Client.ProjectID == "" {
-		return "", fmt.Errorf("project_id not found in embedded configuration")
+		return "", fmt.Errorf("project_id not found in agconnect configuration")
 	}
 
 	return agConnect.Client.ProjectID, nil
 }
 
 func Load() *Config {
-	// 尝试从嵌入配置读取ProjectID
-	projectID := getEnv("HUAWEI_PROJECT_ID", "")
+	// 密钥按 <NAME>_FILE > <NAME> > 编译时嵌入 的顺序读取
+	secrets := &secretLoader{}
 
-	// 如果环境变量未设置，从嵌入配置读取
+	// 环境变量优先，其次从agconnect配置读取ProjectID
+	projectID := getEnv("HUAWEI_PROJECT_ID", "")
+	agConnectJSON := secrets.load("HUAWEI_AGCONNECT", GetEmbeddedAgConnectJSON(), "")
 	if projectID == "" {
-		if pid, err := loadProjectIDFromAgConnect(); err == nil {
+		if pid, err := loadProjectIDFromAgConnect(agConnectJSON); err == nil {
 			projectID = pid
 		}
 	}
 
-	return &Config{
+	cfg := &Config{
 		Server: ServerConfig{
 			Port:       getEnv("PORT", "8080"),
 			Mode:       getEnv("GIN_MODE", "debug"),
@@ -105,32 +175,55 @@ func Load() *Config {
 			APIVersion: getEnvInt64("SERVER_API_VERSION", 3),
 			Capabilities: getEnvStringList("SERVER_CAPABILITIES", []string{
 				"message_crypto_v1",
+				"message_crypto_v2",
 				"push_url_data",
 				"push_deep_link_scheme",
 				"background_push_wake",
 				"app_update_policy",
 				"device_diagnostics",
+				"message_signature_ed25519",
+				"message_stream_sse",
+				"message_long_poll",
+				"message_recall",
+				"message_collapse_key",
+				"message_delivery_status",
+				"message_sequence",
+				"recipient_push",
+				"group_push",
+				"send_grants",
 			}),
 			UpgradeURL: getEnv("SERVER_UPGRADE_URL", "https://github.com/dengdeng-harmonyos/server"),
+			PublicURL:  getEnv("SERVER_PUBLIC_URL", ""),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
 			Port:     getEnv("DB_PORT", "5432"),
 			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "postgres"),
+			Password: secrets.load("DB_PASSWORD", "", "postgres"),
 			DBName:   getEnv("DB_NAME", "push_server"),
 			SSLMode:  getEnv("DB_SSLMODE", "disable"),
 		},
 		HuaweiPush: HuaweiPushConfig{
 			ProjectID:          projectID,
-			ServiceAccountFile: "", // 不再使用文件，配置已嵌入
+			ServiceAccountFile: os.Getenv("HUAWEI_SERVICE_ACCOUNT_FILE"),
+			ServiceAccountJSON: secrets.load("HUAWEI_SERVICE_ACCOUNT", GetEmbeddedPrivateJSON(), ""),
 			JWTExpiry:          3600,
 			PushAPIURL:         "https://push-api.cloud.huawei.com/v3",
 		},
 		Security: SecurityConfig{
-			EncryptionKey:         getEncryptionKey(),
+			EncryptionKey:         secrets.load("PUSH_TOKEN_ENCRYPTION_KEY", GetEmbeddedEncryptionKey(), ""),
+			EncryptionKeys:        secrets.loadList("PUSH_TOKEN_ENCRYPTION_KEYS"),
+			ActiveEncryptionKeyID: getEnv("PUSH_TOKEN_ACTIVE_KEY_ID", ""),
+			TokenIndexKey:         secrets.load("PUSH_TOKEN_INDEX_KEY", "", ""),
+			TokenIndexKeyID:       getEnv("PUSH_TOKEN_INDEX_KEY_ID", ""),
 			DeviceIdTTL:           2592000, // 30天
 			MaxDailyPushPerDevice: 100,
+			AdminToken:            secrets.load("ADMIN_TOKEN", "", ""),
+			RequireSenderAPIKey:   getEnvBool("SENDER_API_KEY_REQUIRED", false),
+			SigningKey:            secrets.load("SERVER_SIGNING_KEY", GetEmbeddedSigningKey(), ""),
+			SigningKeyID:          getEnv("SERVER_SIGNING_KEY_ID", ""),
+			AuditRetentionDays:    getEnvInt64("AUDIT_RETENTION_DAYS", 365),
+			ConfirmRetentionHours: getEnvInt64("CONFIRMED_MESSAGE_RETENTION_HOURS", 0),
 		},
 		AppUpdate: AppUpdateConfig{
 			LatestVersionCode: getEnvInt64("APP_LATEST_VERSION_CODE", 0),
@@ -141,7 +234,29 @@ func Load() *Config {
 			ReleaseNotes:      getEnv("APP_RELEASE_NOTES", ""),
 			PolicyFile:        getEnv("APP_UPDATE_POLICY_FILE", "config/app_update_policy.json"),
 		},
+		CORS: CORSConfig{
+			// 推送接口允许网页直接调用；App不受同源策略限制，设备和管理接口默认关闭跨域
+			Public: getCORSPolicy("CORS_PUBLIC", []string{"*"}),
+			Device: getCORSPolicy("CORS_DEVICE", nil),
+			Admin:  getCORSPolicy("CORS_ADMIN", nil),
+		},
+		Network: NetworkConfig{
+			// 默认只信任本机，Docker 网桥或其他主机上的代理需要显式配置；设置为 none 时不信任任何代理
+			TrustedProxies: getEnvNoneList("TRUSTED_PROXIES", []string{"127.0.0.1", "::1"}),
+			Sender:         getIPAccessRule("IP_SENDER"),
+			Device:         getIPAccessRule("IP_DEVICE"),
+			Admin:          getIPAccessRule("IP_ADMIN"),
+		},
+		TLS: TLSConfig{
+			CertFile:         getEnv("TLS_CERT_FILE", ""),
+			KeyFile:          getEnv("TLS_KEY_FILE", ""),
+			MinVersion:       getEnv("TLS_MIN_VERSION", "1.2"),
+			HTTPRedirectPort: getEnv("TLS_HTTP_REDIRECT_PORT", ""),
+			ReloadInterval:   int(getEnvInt64("TLS_RELOAD_INTERVAL", 300)),
+		},
 	}
+	cfg.Secrets = secrets.sources
+	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
@@ -210,20 +325,32 @@ func getEnvStringList(key string, defaultValue []string) []string {
 	return result
 }
 
-// getEncryptionKey 获取加密密钥（优先使用环境变量）
-func getEncryptionKey() string {
-	// 优先使用环境变量（运行时配置）
-	envKey := os.Getenv("PUSH_TOKEN_ENCRYPTION_KEY")
-	if envKey != "" {
-		return envKey
+// getEnvNoneList 与 getEnvStringList 相同，但值为 none 时返回空列表
+func getEnvNoneList(key string, defaultValue []string) []string {
+	values := getEnvStringList(key, defaultValue)
+	if len(values) == 1 && values[0] == "none" {
+		return nil
 	}
+	return values
+}
 
-	// 降级到嵌入的密钥（编译时注入）
-	embedded := GetEmbeddedEncryptionKey()
-	if embedded != "" {
-		return embedded
+// getIPAccessRule 读取 <prefix>_ALLOW 和 <prefix>_DENY
+func getIPAccessRule(prefix string) IPAccessRule {
+	return IPAccessRule{
+		Allow: getEnvStringList(prefix+"_ALLOW", nil),
+		Deny:  getEnvStringList(prefix+"_DENY", nil),
 	}
+}
+
+// getCORSPolicy 读取 <prefix>_ORIGINS、_METHODS、_HEADERS、_CREDENTIALS
+// ORIGINS 设置为 none 时关闭该路由组的跨域
+func getCORSPolicy(prefix string, defaultOrigins []string) CORSPolicy {
+	origins := getEnvNoneList(prefix+"_ORIGINS", defaultOrigins)
 
-	// 最后返回空字符串
-	return ""
+	return CORSPolicy{
+		AllowedOrigins:   origins,
+		AllowedMethods:   getEnvStringList(prefix+"_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+		AllowedHeaders:   getEnvStringList(prefix+"_HEADERS", []string{"Content-Type", "Authorization", "X-API-Key"}),
+		AllowCredentials: getEnvBool(prefix+"_CREDENTIALS", false),
+	}
 }
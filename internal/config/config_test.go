@@ -16,6 +16,9 @@ func TestDefaultServerCompatibilityRequiresDeepLinkScheme(t *testing.T) {
 	if !containsCapability(cfg.Server.Capabilities, "background_push_wake") {
 		t.Fatalf("default capabilities = %v, want background_push_wake", cfg.Server.Capabilities)
 	}
+	if !containsCapability(cfg.Server.Capabilities, "message_crypto_v2") {
+		t.Fatalf("default capabilities = %v, want message_crypto_v2", cfg.Server.Capabilities)
+	}
 }
 
 func containsCapability(capabilities []string, expected string) bool {
@@ -26,3 +29,25 @@ func containsCapability(capabilities []string, expected string) bool {
 	}
 	return false
 }
+
+func TestCORSDefaultsOnlyOpenPublicRoutes(t *testing.T) {
+	t.Setenv("CORS_PUBLIC_ORIGINS", "")
+	t.Setenv("CORS_DEVICE_ORIGINS", "")
+	t.Setenv("CORS_ADMIN_ORIGINS", "https://admin.example.com, https://ops.example.com")
+
+	cfg := Load()
+	if !cfg.CORS.Public.Enabled() || cfg.CORS.Public.AllowCredentials {
+		t.Fatalf("public CORS = %+v, want enabled without credentials", cfg.CORS.Public)
+	}
+	if cfg.CORS.Device.Enabled() {
+		t.Fatalf("device CORS = %+v, want disabled", cfg.CORS.Device)
+	}
+	if len(cfg.CORS.Admin.AllowedOrigins) != 2 {
+		t.Fatalf("admin origins = %v", cfg.CORS.Admin.AllowedOrigins)
+	}
+
+	t.Setenv("CORS_PUBLIC_ORIGINS", "none")
+	if Load().CORS.Public.Enabled() {
+		t.Fatal("CORS_PUBLIC_ORIGINS=none should disable public CORS")
+	}
+}
@@ -10,6 +10,7 @@ var (
 	embeddedAgConnectJSON string // base64 encoded
 	embeddedPrivateJSON   string // base64 encoded
 	embeddedEncryptionKey string // base64 encoded
+	embeddedSigningKey    string // base64 encoded
 )
 
 // GetEmbeddedAgConnectJSON 返回嵌入的agconnect配置（解码后）
@@ -47,3 +48,15 @@ func GetEmbeddedEncryptionKey() string {
 	}
 	return string(decoded)
 }
+
+// GetEmbeddedSigningKey 返回嵌入的消息签名密钥
+func GetEmbeddedSigningKey() string {
+	if embeddedSigningKey == "" {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(embeddedSigningKey)
+	if err != nil {
+		return "" // 解码失败返回空
+	}
+	return string(decoded)
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 密钥来源，优先级：文件 > 环境变量 > 编译时嵌入
+const (
+	SecretSourceFile     = "file"
+	SecretSourceEnv      = "env"
+	SecretSourceEmbedded = "embedded"
+	SecretSourceDefault  = "default"
+	SecretSourceUnset    = "unset"
+)
+
+// SecretSource 记录一个密钥的来源，用于启动日志；不包含密钥内容
+type SecretSource struct {
+	Name   string // 环境变量名，文件变体为 <Name>_FILE
+	Source string
+	Path   string // 来源为文件时的路径
+	Err    error  // 读取文件失败
+}
+
+// secretLoader 读取密钥并记录来源
+type secretLoader struct {
+	sources []SecretSource
+}
+
+// load 读取名为 name 的密钥：<name>_FILE 指向的文件 > 环境变量 <name> > 嵌入值 > 默认值
+// Docker/Kubernetes secret 文件末尾的换行会被去掉
+func (l *secretLoader) load(name string, embedded string, defaultValue string) string {
+	source := SecretSource{Name: name}
+	value := ""
+
+	switch {
+	case os.Getenv(name+"_FILE") != "":
+		source.Source = SecretSourceFile
+		source.Path = os.Getenv(name + "_FILE")
+		content, err := os.ReadFile(source.Path)
+		if err != nil {
+			source.Err = fmt.Errorf("read %s_FILE: %w", name, err)
+		}
+		value = strings.TrimSpace(string(content))
+	case os.Getenv(name) != "":
+		source.Source = SecretSourceEnv
+		value = os.Getenv(name)
+	case embedded != "":
+		source.Source = SecretSourceEmbedded
+		value = embedded
+	case defaultValue != "":
+		source.Source = SecretSourceDefault
+		value = defaultValue
+	default:
+		source.Source = SecretSourceUnset
+	}
+
+	l.sources = append(l.sources, source)
+	return value
+}
+
+// loadList 与 load 相同，但内容为逗号或换行分隔的列表
+func (l *secretLoader) loadList(name string) []string {
+	value := l.load(name, "", "")
+	if value == "" {
+		return nil
+	}
+
+	var result []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	}) {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// SecretError 返回第一个密钥文件读取错误
+func (c *Config) SecretError() error {
+	for _, source := range c.Secrets {
+		if source.Err != nil {
+			return source.Err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretLoaderPrefersFileThenEnvThenEmbedded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin_token")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+
+	t.Setenv("TEST_SECRET_FILE", path)
+	t.Setenv("TEST_SECRET", "from-env")
+	loader := &secretLoader{}
+	if got := loader.load("TEST_SECRET", "from-embedded", ""); got != "from-file" {
+		t.Fatalf("file secret = %q, want from-file", got)
+	}
+
+	t.Setenv("TEST_SECRET_FILE", "")
+	if got := loader.load("TEST_SECRET", "from-embedded", ""); got != "from-env" {
+		t.Fatalf("env secret = %q, want from-env", got)
+	}
+
+	t.Setenv("TEST_SECRET", "")
+	if got := loader.load("TEST_SECRET", "from-embedded", ""); got != "from-embedded" {
+		t.Fatalf("embedded secret = %q, want from-embedded", got)
+	}
+
+	want := []string{SecretSourceFile, SecretSourceEnv, SecretSourceEmbedded}
+	for i, source := range loader.sources {
+		if source.Source != want[i] {
+			t.Fatalf("source %d = %s, want %s", i, source.Source, want[i])
+		}
+	}
+}
+
+func TestSecretLoaderReportsUnreadableFile(t *testing.T) {
+	t.Setenv("PUSH_TOKEN_ENCRYPTION_KEYS_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	cfg := Load()
+	if err := cfg.SecretError(); err == nil {
+		t.Fatal("expected missing secret file to be reported")
+	}
+}
+
+func TestSecretLoaderListFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("2025:a2V5MQ==\n2026:a2V5Mg==\n"), 0o600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+	t.Setenv("PUSH_TOKEN_ENCRYPTION_KEYS_FILE", path)
+
+	keys := (&secretLoader{}).loadList("PUSH_TOKEN_ENCRYPTION_KEYS")
+	if len(keys) != 2 || keys[1] != "2026:a2V5Mg==" {
+		t.Fatalf("keys = %v", keys)
+	}
+}
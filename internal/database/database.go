@@ -13,10 +13,7 @@ type Database struct {
 }
 
 func NewDatabase(cfg config.DatabaseConfig) (*Database, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
-
-	db, err := sql.Open("postgres", dsn)
+	db, err := sql.Open("postgres", cfg.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -51,6 +48,9 @@ func (db *Database) InitTables() error {
 		)`,
 		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS public_key TEXT`,
 		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_background_push_attempt_at TIMESTAMPTZ`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS push_token_hash CHAR(64)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_devices_push_token_hash ON devices(push_token_hash)`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS public_key_fingerprint VARCHAR(64)`,
 
 		// 推送统计表（仅统计数据，不记录具体内容）
 		`CREATE TABLE IF NOT EXISTS push_statistics (
@@ -66,7 +66,7 @@ func (db *Database) InitTables() error {
 
 		// 待同步加密消息表
 		`CREATE TABLE IF NOT EXISTS pending_messages (
-			id SERIAL PRIMARY KEY,
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			device_id VARCHAR(64) NOT NULL,
 			server_name VARCHAR(255) NOT NULL,
 			encrypted_aes_key TEXT NOT NULL,
@@ -80,6 +80,10 @@ func (db *Database) InitTables() error {
 			CONSTRAINT fk_device_id FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
 		)`,
 
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS crypto_version SMALLINT NOT NULL DEFAULT 1`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS ephemeral_public_key TEXT`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS envelope_version SMALLINT NOT NULL DEFAULT 1`,
+
 		// 创建索引
 		`CREATE INDEX IF NOT EXISTS idx_devices_device_id ON devices(device_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_devices_is_active ON devices(is_active)`,
@@ -87,13 +91,149 @@ func (db *Database) InitTables() error {
 		`CREATE INDEX IF NOT EXISTS idx_pending_device_id ON pending_messages(device_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_pending_delivered ON pending_messages(delivered)`,
 		`CREATE INDEX IF NOT EXISTS idx_pending_expires ON pending_messages(expires_at)`,
-		`CREATE OR REPLACE FUNCTION clean_expired_messages() RETURNS void AS $$
+		`DROP FUNCTION IF EXISTS clean_expired_messages()`,
+		`CREATE OR REPLACE FUNCTION clean_expired_messages(confirmed_retention INTERVAL DEFAULT INTERVAL '0') RETURNS void AS $$
 			BEGIN
 				DELETE FROM pending_messages
 				WHERE expires_at < NOW()
-				   OR (delivered = true AND confirmed_at < NOW() - INTERVAL '24 hours');
+				   OR (delivered = true AND confirmed_at <= NOW() - confirmed_retention);
 			END;
 			$$ LANGUAGE plpgsql`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_confirmed ON pending_messages(confirmed_at) WHERE delivered = true`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS message_seq BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_seq BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_seq_at TIMESTAMPTZ`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS seq BIGINT`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_pending_device_seq ON pending_messages(device_id, seq) WHERE seq IS NOT NULL`,
+		// 待接收消息按 seq 分页；019 之前的消息没有序号，按创建时间编在所有已分配序号之前
+		`UPDATE pending_messages p
+			SET seq = numbered.seq
+			FROM (
+				SELECT m.id,
+				       LEAST(COALESCE((SELECT MIN(n.seq) FROM pending_messages n WHERE n.device_id = m.device_id), 1), 1)
+				       - ROW_NUMBER() OVER (PARTITION BY m.device_id ORDER BY m.created_at DESC, m.id::TEXT DESC) AS seq
+				FROM pending_messages m
+				WHERE m.seq IS NULL
+			) numbered
+			WHERE p.id = numbered.id`,
+
+		// 推送方API Key（仅保存哈希）
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(128) NOT NULL,
+			key_prefix VARCHAR(16) NOT NULL,
+			key_hash CHAR(64) NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			allowed_device_ids TEXT[] NOT NULL DEFAULT '{}',
+			allowed_groups TEXT[] NOT NULL DEFAULT '{}',
+			rate_limit_per_minute INTEGER NOT NULL DEFAULT 0,
+			daily_quota INTEGER NOT NULL DEFAULT 0,
+			last_used_at TIMESTAMPTZ,
+			last_used_ip VARCHAR(64),
+			revoked_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_key_daily_usage (
+			api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+			usage_date DATE NOT NULL,
+			push_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (api_key_id, usage_date)
+		)`,
+
+		// 设备分组成员（用于限定API Key可推送的设备范围）
+		`CREATE TABLE IF NOT EXISTS device_group_members (
+			group_name VARCHAR(64) NOT NULL,
+			device_id UUID NOT NULL,
+			source VARCHAR(16) NOT NULL DEFAULT 'admin',
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_name, device_id),
+			CONSTRAINT fk_group_member_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_group_members_device_id ON device_group_members(device_id)`,
+
+		// 设备公钥环（轮换后的旧公钥保留到其待同步消息取完）
+		`CREATE TABLE IF NOT EXISTS device_keys (
+			device_id UUID NOT NULL,
+			key_id VARCHAR(32) NOT NULL,
+			public_key TEXT NOT NULL,
+			fingerprint VARCHAR(64) NOT NULL,
+			crypto_version SMALLINT NOT NULL DEFAULT 1,
+			valid_from TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			valid_until TIMESTAMPTZ,
+			PRIMARY KEY (device_id, key_id),
+			CONSTRAINT fk_device_key_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_key_challenges (
+			id UUID PRIMARY KEY,
+			device_id UUID NOT NULL,
+			key_id VARCHAR(32) NOT NULL,
+			response_hash CHAR(64) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMPTZ NOT NULL,
+			CONSTRAINT fk_key_challenge_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+		)`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS public_key_id VARCHAR(32)`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS key_id VARCHAR(32)`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_device_key ON pending_messages(device_id, key_id)`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS signature TEXT`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS signing_key_id VARCHAR(32)`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS privacy_level VARCHAR(16) NOT NULL DEFAULT 'standard'`,
+
+		// 审计日志（只追加，按保留期清理）
+		`CREATE TABLE IF NOT EXISTS audit_events (
+			id BIGSERIAL PRIMARY KEY,
+			event_type VARCHAR(64) NOT NULL,
+			actor_type VARCHAR(16) NOT NULL,
+			actor_id VARCHAR(64),
+			device_id UUID,
+			client_ip VARCHAR(64),
+			user_agent VARCHAR(256),
+			request_id VARCHAR(64),
+			details JSONB,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_device ON audit_events(device_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events(event_type, id)`,
+		`CREATE OR REPLACE FUNCTION audit_events_reject_update() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER audit_events_append_only
+			BEFORE UPDATE ON audit_events
+			FOR EACH ROW EXECUTE FUNCTION audit_events_reject_update()`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS sender_key_id UUID`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS collapse_key VARCHAR(64)`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS notify_id INTEGER`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_collapse ON pending_messages(device_id, collapse_key) WHERE collapse_key IS NOT NULL AND delivered = false`,
+
+		// 接收者：同一个人的多台设备，推送到接收者时每台设备各保存一份副本
+		`CREATE TABLE IF NOT EXISTS recipients (
+			id UUID PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS recipient_id UUID REFERENCES recipients(id) ON DELETE SET NULL`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS recipient_joined_at TIMESTAMPTZ`,
+		`CREATE INDEX IF NOT EXISTS idx_devices_recipient ON devices(recipient_id) WHERE recipient_id IS NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS recipient_pairing_codes (
+			code_hash CHAR(64) PRIMARY KEY,
+			recipient_id UUID NOT NULL REFERENCES recipients(id) ON DELETE CASCADE,
+			device_id UUID NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMPTZ NOT NULL,
+			CONSTRAINT fk_pairing_code_device FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+		)`,
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS recipient_message_id UUID`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_recipient_message ON pending_messages(recipient_message_id) WHERE recipient_message_id IS NOT NULL`,
+		// 合并重复设备时转来的消息仍绑定原设备ID，App 用它重建关联数据和签名内容
+		`ALTER TABLE pending_messages ADD COLUMN IF NOT EXISTS bound_device_id UUID`,
+
+		// 发送授权：设备自己签发的 API Key，只能推送到该设备，设备删除时一并删除
+		`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS owner_device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE`,
+		`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_owner_device ON api_keys(owner_device_id) WHERE owner_device_id IS NOT NULL`,
 
 		// App更新策略表
 		`CREATE TABLE IF NOT EXISTS app_update_policies (
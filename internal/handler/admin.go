@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/middleware"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AdminHandler 处理仅限管理员的管理接口
+type AdminHandler struct {
+	db          *sql.DB
+	apiKeys     *service.APIKeyService
+	accessLists []*middleware.IPAccessList
+}
+
+type groupMembersRequest struct {
+	DeviceIDs []string `json:"deviceIds" binding:"required"`
+}
+
+func NewAdminHandler(db *sql.DB, apiKeys *service.APIKeyService, accessLists ...*middleware.IPAccessList) *AdminHandler {
+	return &AdminHandler{db: db, apiKeys: apiKeys, accessLists: accessLists}
+}
+
+// CreateAPIKey 签发推送方API Key，原始Key只在这里返回一次
+// POST /api/v1/admin/api-keys
+func (h *AdminHandler) CreateAPIKey(c *gin.Context) {
+	var req service.APIKeySpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+
+	key, rawKey, err := h.apiKeys.Create(c.Request.Context(), req, auditActor(c, ""))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAPIKeySpec) {
+			RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+			return
+		}
+		logger.ErrorWithStack(err, "Failed to create API key: %s", req.Name)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to create API key")
+		return
+	}
+
+	logger.Info("API key created: id=%s name=%s scopes=%v", key.ID, key.Name, key.Scopes)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"apiKey": key,
+		"key":    rawKey,
+	})
+}
+
+// ListAPIKeys 列出已签发的推送方API Key，不包含原始Key
+// GET /api/v1/admin/api-keys
+func (h *AdminHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeys.List(c.Request.Context())
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list API keys")
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to list API keys")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"apiKeys": keys,
+		"count":   len(keys),
+	})
+}
+
+// RevokeAPIKey 吊销推送方API Key
+// DELETE /api/v1/admin/api-keys/:id
+func (h *AdminHandler) RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	err := h.apiKeys.Revoke(c.Request.Context(), id, auditActor(c, ""))
+	if errors.Is(err, service.ErrAPIKeyNotFound) {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to revoke API key: %s", id)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to revoke API key")
+		return
+	}
+
+	logger.Info("API key revoked: id=%s", id)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message": "API key revoked successfully",
+	})
+}
+
+// ListGroups 列出所有有成员的分组，以及其中管理员分配和App订阅的成员数
+// GET /api/v1/admin/groups
+func (h *AdminHandler) ListGroups(c *gin.Context) {
+	groups, err := service.ListGroups(c.Request.Context(), h.db)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list groups")
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to list groups")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"groups": groups,
+	})
+}
+
+// ListGroupMembers 列出分组中的设备
+// GET /api/v1/admin/groups/:name/devices
+func (h *AdminHandler) ListGroupMembers(c *gin.Context) {
+	group := c.Param("name")
+	if !service.IsValidGroupName(group) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid group name")
+		return
+	}
+
+	members, err := service.ListGroupMembers(c.Request.Context(), h.db, group)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list members of group: %s", group)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to list group members")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"group":   group,
+		"devices": members,
+	})
+}
+
+// AddGroupMembers 将设备分配到分组，API Key 可以限定只推送到该分组
+// POST /api/v1/admin/groups/:name/devices
+func (h *AdminHandler) AddGroupMembers(c *gin.Context) {
+	group := c.Param("name")
+	if !service.IsValidGroupName(group) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid group name")
+		return
+	}
+
+	var req groupMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	for _, deviceID := range req.DeviceIDs {
+		if _, err := uuid.Parse(deviceID); err != nil {
+			RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+			return
+		}
+	}
+
+	added, err := service.AddGroupMembers(c.Request.Context(), h.db, group, req.DeviceIDs, service.GroupMemberSourceAdmin, auditActor(c, ""))
+	if errors.Is(err, service.ErrGroupFull) {
+		RespondError(c, http.StatusConflict, models.BusinessError, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to add members to group: %s", group)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to add group members")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"group":      group,
+		"addedCount": added,
+	})
+}
+
+// RemoveGroupMember 从分组中移除设备
+// DELETE /api/v1/admin/groups/:name/devices/:device_id
+func (h *AdminHandler) RemoveGroupMember(c *gin.Context) {
+	group := c.Param("name")
+	deviceID := c.Param("device_id")
+	if !service.IsValidGroupName(group) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid group name")
+		return
+	}
+	if _, err := uuid.Parse(deviceID); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	removed, err := service.RemoveGroupMember(c.Request.Context(), h.db, group, deviceID, auditActor(c, deviceID))
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to remove device %s from group: %s", deviceID, group)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to remove group member")
+		return
+	}
+	if !removed {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device is not a member of this group")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message": "Group member removed successfully",
+	})
+}
+
+// AccessStats 列出各路由组的IP访问规则，以及启动以来各自拒绝的请求数
+// GET /api/v1/admin/access-stats
+func (h *AdminHandler) AccessStats(c *gin.Context) {
+	stats := make([]middleware.IPAccessStats, 0, len(h.accessLists))
+	for _, list := range h.accessLists {
+		stats = append(stats, list.Stats())
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"groups": stats,
+	})
+}
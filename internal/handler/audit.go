@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/middleware"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// recordAudit 在 tx 内为当前请求追加审计事件，事件与变更一起提交或回滚
+func recordAudit(c *gin.Context, tx *sql.Tx, eventType string, deviceID string, details map[string]interface{}) error {
+	return service.RecordAuditEvent(c.Request.Context(), tx, auditActor(c, deviceID).Event(eventType, deviceID, details))
+}
+
+// auditActor 标识当前请求的操作者：管理员、推送方API Key，或出示 deviceID 的一方
+func auditActor(c *gin.Context, deviceID string) service.AuditActor {
+	actor := service.AuditActor{
+		Type:      service.AuditActorAnonymous,
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: middleware.GetRequestID(c),
+	}
+	switch {
+	case middleware.IsAdmin(c):
+		actor.Type = service.AuditActorAdmin
+	case middleware.SenderAPIKey(c) != nil:
+		actor.Type, actor.ID = service.AuditActorAPIKey, middleware.SenderAPIKey(c).ID
+	case deviceID != "":
+		actor.Type, actor.ID = service.AuditActorDevice, deviceID
+	}
+	return actor
+}
+
+// ListAuditEvents 按时间倒序查询审计日志，用响应中的 next_before_id 作为 before_id 获取下一页
+// GET /api/v1/admin/audit-events?event_type=&actor_type=&actor_id=&device_id=&since=&until=&before_id=&limit=
+func (h *AdminHandler) ListAuditEvents(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	events, err := service.ListAuditEvents(c.Request.Context(), h.db, filter)
+	if errors.Is(err, service.ErrInvalidAuditFilter) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list audit events")
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to list audit events")
+		return
+	}
+
+	response := gin.H{
+		"events": events,
+		"count":  len(events),
+	}
+	if len(events) > 0 {
+		response["next_before_id"] = events[len(events)-1].ID
+	}
+	RespondSuccess(c, http.StatusOK, response)
+}
+
+func parseAuditFilter(c *gin.Context) (service.AuditFilter, error) {
+	filter := service.AuditFilter{
+		EventType: c.Query("event_type"),
+		ActorType: c.Query("actor_type"),
+		ActorID:   c.Query("actor_id"),
+		DeviceID:  c.Query("device_id"),
+	}
+
+	if filter.DeviceID != "" {
+		if _, err := uuid.Parse(filter.DeviceID); err != nil {
+			return filter, &pushValidationError{message: "Invalid device_id format"}
+		}
+	}
+
+	var err error
+	if filter.Since, err = parseAuditTime(c.Query("since")); err != nil {
+		return filter, &pushValidationError{message: "since must be an RFC 3339 timestamp"}
+	}
+	if filter.Until, err = parseAuditTime(c.Query("until")); err != nil {
+		return filter, &pushValidationError{message: "until must be an RFC 3339 timestamp"}
+	}
+	if value := c.Query("before_id"); value != "" {
+		if filter.BeforeID, err = strconv.ParseInt(value, 10, 64); err != nil || filter.BeforeID <= 0 {
+			return filter, &pushValidationError{message: "before_id must be a positive integer"}
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			return filter, &pushValidationError{message: "limit must be a positive integer"}
+		}
+	}
+	return filter, nil
+}
+
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
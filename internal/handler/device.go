@@ -1,35 +1,52 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/dengdeng-harmonyos/server/internal/config"
 	"github.com/dengdeng-harmonyos/server/internal/database"
+	"github.com/dengdeng-harmonyos/server/internal/logger"
 	"github.com/dengdeng-harmonyos/server/internal/models"
 	"github.com/dengdeng-harmonyos/server/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// TokenProofSender 把Push Token持有证明推送到该Token
+type TokenProofSender interface {
+	SendTokenProof(pushToken string, proof string) error
+}
+
+// errTokenProofRequired 新Push Token属于另一台设备，合并前需要持有证明
+var errTokenProofRequired = errors.New("push token belongs to another device")
+
 type DeviceHandler struct {
-	db         *database.Database
-	encryption *service.EncryptionService
-	config     config.SecurityConfig
-	serverName string // 服务器名称
+	db            *database.Database
+	encryption    *service.EncryptionService
+	cryptoService *service.CryptoService
+	config        config.SecurityConfig
+	serverName    string // 服务器名称
+	publicURL     string // 服务端对外访问地址，未配置时从请求推断
+	tokenProof    TokenProofSender
 }
 
-func NewDeviceHandler(db *database.Database, cfg config.Config) (*DeviceHandler, error) {
-	encryption, err := service.NewEncryptionService(cfg.Security.EncryptionKey)
-	if err != nil {
-		return nil, err
+func NewDeviceHandler(db *database.Database, encryption *service.EncryptionService, cfg config.Config) *DeviceHandler {
+	return &DeviceHandler{
+		db:            db,
+		encryption:    encryption,
+		cryptoService: service.NewCryptoService(),
+		config:        cfg.Security,
+		serverName:    cfg.Server.ServerName,
+		publicURL:     cfg.Server.PublicURL,
 	}
+}
 
-	return &DeviceHandler{
-		db:         db,
-		encryption: encryption,
-		config:     cfg.Security,
-		serverName: cfg.Server.ServerName,
-	}, nil
+// SetTokenProofSender 设置下发Push Token持有证明的推送通道（推送处理器创建后设置）
+func (h *DeviceHandler) SetTokenProofSender(sender TokenProofSender) {
+	h.tokenProof = sender
 }
 
 // Register 设备注册接口
@@ -40,6 +57,22 @@ func (h *DeviceHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// 校验公钥：必须是PKIX格式的RSA（至少2048位）或X25519公钥，否则后续推送无法安全加密
+	var publicKey *service.DevicePublicKey
+	if req.PublicKey != "" {
+		var err error
+		publicKey, err = service.ParseDevicePublicKey(req.PublicKey)
+		if err != nil {
+			RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid public_key: "+err.Error())
+			return
+		}
+	}
+
+	if req.PrivacyLevel != "" && !service.IsValidPrivacyLevel(req.PrivacyLevel) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid privacy_level, expected standard or private")
+		return
+	}
+
 	// 加密push_token
 	encryptedToken, err := h.encryption.Encrypt(req.PushToken)
 	if err != nil {
@@ -47,60 +80,122 @@ func (h *DeviceHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// 查询是否已存在该push_token
+	ctx := c.Request.Context()
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to register device")
+		return
+	}
+	defer tx.Rollback()
+
+	// 通过盲索引查询是否已存在该push_token（密文使用随机nonce，无法直接比较）
+	tokenHash := h.encryption.BlindIndex(req.PushToken)
 	var existingDevice models.Device
-	err = h.db.DB.QueryRow(`
-		SELECT device_id FROM devices WHERE push_token = $1
-	`, encryptedToken).Scan(&existingDevice.DeviceId)
+	err = tx.QueryRowContext(ctx, `
+		SELECT device_id FROM devices WHERE push_token_hash = $1 FOR UPDATE
+	`, tokenHash).Scan(&existingDevice.DeviceId)
 
 	if err == nil {
-		// 设备已存在，更新信息（包括公钥）
-		_, err = h.db.DB.Exec(`
+		// 设备已存在，更新信息；新公钥加入公钥环，旧公钥保留到其消息取完
+		var privacyLevel string
+		err = tx.QueryRowContext(ctx, `
 			UPDATE devices 
-			SET device_type = $1, os_version = $2, app_version = $3, public_key = $4,
-			    is_active = true, last_active_at = NOW(), updated_at = NOW()
+			SET device_type = $1, os_version = $2, app_version = $3,
+			    push_token = $4, is_active = true,
+			    privacy_level = COALESCE(NULLIF($6, ''), privacy_level),
+			    last_active_at = NOW(), updated_at = NOW()
 			WHERE device_id = $5
-		`, req.DeviceType, req.OSVersion, req.AppVersion, req.PublicKey, existingDevice.DeviceId)
-
+			RETURNING privacy_level
+		`, req.DeviceType, req.OSVersion, req.AppVersion, encryptedToken, existingDevice.DeviceId, req.PrivacyLevel).Scan(&privacyLevel)
+		if err == nil && publicKey != nil {
+			err = service.SetCurrentDeviceKey(ctx, tx, existingDevice.DeviceId.String(), publicKey)
+		}
+		if err == nil {
+			err = recordAudit(c, tx, service.AuditDeviceReregister, existingDevice.DeviceId.String(), registerAuditDetails(req, publicKey, privacyLevel))
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
 		if err != nil {
+			logger.ErrorWithStack(err, "Failed to update device: %s", existingDevice.DeviceId)
 			RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to update device")
 			return
 		}
 
-		RespondSuccess(c, http.StatusOK, gin.H{
-			"device_id":   existingDevice.DeviceId.String(),
-			"server_name": h.serverName,
-			"message":     "Device updated successfully",
-		})
+		RespondSuccess(c, http.StatusOK, registerResponse(existingDevice.DeviceId.String(), h.serverName, publicKey, privacyLevel, "Device updated successfully"))
 		return
 	}
 
 	// 生成新的device_id (UUID)
 	deviceId := uuid.New()
+	privacyLevel := req.PrivacyLevel
+	if privacyLevel == "" {
+		privacyLevel = service.PrivacyLevelStandard
+	}
 
-	// 插入新设备（包括公钥）
-	_, err = h.db.DB.Exec(`
-		INSERT INTO devices (device_id, push_token, public_key, device_type, os_version, app_version, is_active, last_active_at, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, true, NOW(), NOW(), NOW())
-	`, deviceId, encryptedToken, req.PublicKey, req.DeviceType, req.OSVersion, req.AppVersion)
-
+	// 插入新设备，公钥写入公钥环
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO devices (device_id, push_token, push_token_hash, public_key, device_type, os_version, app_version, privacy_level, is_active, last_active_at, created_at, updated_at)
+		VALUES ($1, $2, $3, '', $4, $5, $6, $7, true, NOW(), NOW(), NOW())
+	`, deviceId, encryptedToken, tokenHash, req.DeviceType, req.OSVersion, req.AppVersion, privacyLevel)
+	if err == nil && publicKey != nil {
+		err = service.SetCurrentDeviceKey(ctx, tx, deviceId.String(), publicKey)
+	}
+	if err == nil {
+		err = recordAudit(c, tx, service.AuditDeviceRegister, deviceId.String(), registerAuditDetails(req, publicKey, privacyLevel))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
+		logger.ErrorWithStack(err, "Failed to register device")
 		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to register device")
 		return
 	}
 
-	RespondSuccess(c, http.StatusOK, gin.H{
-		"device_id":   deviceId.String(),
-		"server_name": h.serverName,
-		"message":     "Device registered successfully",
-	})
+	RespondSuccess(c, http.StatusOK, registerResponse(deviceId.String(), h.serverName, publicKey, privacyLevel, "Device registered successfully"))
+}
+
+// registerAuditDetails 注册审计事件附带的非敏感信息（不含Push Token）
+func registerAuditDetails(req models.DeviceRegisterRequest, publicKey *service.DevicePublicKey, privacyLevel string) map[string]interface{} {
+	details := map[string]interface{}{
+		"deviceType":   req.DeviceType,
+		"osVersion":    req.OSVersion,
+		"appVersion":   req.AppVersion,
+		"privacyLevel": privacyLevel,
+	}
+	if publicKey != nil {
+		details["publicKeyId"] = publicKey.KeyID
+	}
+	return details
+}
+
+func registerResponse(deviceId string, serverName string, publicKey *service.DevicePublicKey, privacyLevel string, message string) gin.H {
+	response := gin.H{
+		"device_id":              deviceId,
+		"server_name":            serverName,
+		"public_key_id":          "",
+		"public_key_fingerprint": "",
+		"crypto_version":         service.MessageCryptoV1,
+		"privacy_level":          privacyLevel,
+		"message":                message,
+	}
+	if publicKey != nil {
+		response["public_key_id"] = publicKey.KeyID
+		response["public_key_fingerprint"] = publicKey.Fingerprint
+		response["crypto_version"] = publicKey.CryptoVersion()
+	}
+	return response
 }
 
 // UpdateToken 更新Push Token
+// 新Token已属于另一台设备时不会直接合并：服务端把持有证明推送到该Token，
+// App 收到 token_proof 后台消息后带上 token_proof 再次调用，证明通过才合并并删除旧设备
 func (h *DeviceHandler) UpdateToken(c *gin.Context) {
 	var req struct {
 		DeviceId     string `json:"device_id" binding:"required"`
 		NewPushToken string `json:"new_push_token" binding:"required"`
+		TokenProof   string `json:"token_proof"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -115,20 +210,32 @@ func (h *DeviceHandler) UpdateToken(c *gin.Context) {
 		return
 	}
 
-	// 更新token
-	result, err := h.db.DB.Exec(`
-		UPDATE devices 
-		SET push_token = $1, last_active_at = NOW(), updated_at = NOW()
-		WHERE device_id = $2 AND is_active = true
-	`, encryptedToken, req.DeviceId)
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	now := time.Now()
+	proven := false
+	if req.TokenProof != "" {
+		if !h.encryption.VerifyPushTokenProof(req.DeviceId, req.NewPushToken, req.TokenProof, now) {
+			RespondError(c, http.StatusForbidden, models.PermissionDenied, "Invalid or expired token_proof")
+			return
+		}
+		proven = true
+	}
 
+	updated, err := h.updatePushToken(c, req.DeviceId, encryptedToken, h.encryption.BlindIndex(req.NewPushToken), proven)
+	if errors.Is(err, errTokenProofRequired) {
+		h.requestTokenProof(c, req.DeviceId, req.NewPushToken, now)
+		return
+	}
 	if err != nil {
+		logger.ErrorWithStack(err, "Failed to update push token for device: %s", req.DeviceId)
 		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to update token")
 		return
 	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
+	if !updated {
 		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
 		return
 	}
@@ -138,6 +245,144 @@ func (h *DeviceHandler) UpdateToken(c *gin.Context) {
 	})
 }
 
+// UpdatePrivacy 设置设备的通知隐私级别
+// private 时所有推送的通知栏只显示占位文案，真实标题和内容仅在App解密待接收消息后可见
+func (h *DeviceHandler) UpdatePrivacy(c *gin.Context) {
+	var req struct {
+		DeviceId     string `json:"device_id" binding:"required"`
+		PrivacyLevel string `json:"privacy_level" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request")
+		return
+	}
+
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	if !service.IsValidPrivacyLevel(req.PrivacyLevel) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid privacy_level, expected standard or private")
+		return
+	}
+
+	updated, err := h.updatePrivacyLevel(c, req.DeviceId, req.PrivacyLevel)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to update privacy level for device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to update privacy level")
+		return
+	}
+	if !updated {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"privacy_level": req.PrivacyLevel,
+	})
+}
+
+// updatePrivacyLevel 更新隐私级别并在同一事务内写入审计事件，设备不存在时返回 false
+func (h *DeviceHandler) updatePrivacyLevel(c *gin.Context, deviceId string, privacyLevel string) (bool, error) {
+	tx, err := h.db.DB.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(c.Request.Context(), `
+		UPDATE devices
+		SET privacy_level = $1, updated_at = NOW()
+		WHERE device_id = $2
+	`, privacyLevel, deviceId)
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+	if err := recordAudit(c, tx, service.AuditDevicePrivacyUpdate, deviceId, map[string]interface{}{"privacyLevel": privacyLevel}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// requestTokenProof 把持有证明推送到新Token，App 回传证明后才合并另一台设备
+func (h *DeviceHandler) requestTokenProof(c *gin.Context, deviceId string, pushToken string, now time.Time) {
+	if h.tokenProof == nil {
+		RespondError(c, http.StatusConflict, models.BusinessError, "Push token belongs to another device")
+		return
+	}
+	if err := h.tokenProof.SendTokenProof(pushToken, h.encryption.PushTokenProof(deviceId, pushToken, now)); err != nil {
+		logger.ErrorWithStack(err, "Failed to send push token proof for device: %s", deviceId)
+		RespondError(c, http.StatusBadGateway, models.OperationFailed, "Failed to send token proof")
+		return
+	}
+
+	RespondSuccess(c, http.StatusAccepted, gin.H{
+		"proof_required": true,
+		"message":        "Push token belongs to another device, retry with the token_proof pushed to it",
+	})
+}
+
+// updatePushToken 更新设备的Push Token；如果该Token已属于另一台设备（旧注册残留），
+// 调用方证明持有该Token后将旧设备合并到当前设备，否则返回 errTokenProofRequired；
+// 审计事件与更新在同一事务内写入
+func (h *DeviceHandler) updatePushToken(c *gin.Context, deviceId string, encryptedToken string, tokenHash string, proven bool) (bool, error) {
+	ctx := c.Request.Context()
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE devices
+		SET last_active_at = NOW(), updated_at = NOW()
+		WHERE device_id = $1 AND is_active = true
+	`, deviceId)
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+
+	var duplicateId string
+	err = tx.QueryRowContext(ctx, `
+		SELECT device_id::TEXT FROM devices
+		WHERE push_token_hash = $1 AND device_id <> $2
+		FOR UPDATE
+	`, tokenHash, deviceId).Scan(&duplicateId)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if err == nil {
+		if !proven {
+			return false, errTokenProofRequired
+		}
+		if err := service.MergeDevices(ctx, tx, deviceId, duplicateId, auditActor(c, deviceId)); err != nil {
+			return false, err
+		}
+		logger.Info("Merged device %s into %s after push token update", duplicateId, deviceId)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE devices
+		SET push_token = $1, push_token_hash = $2
+		WHERE device_id = $3
+	`, encryptedToken, tokenHash, deviceId); err != nil {
+		return false, err
+	}
+	if err := recordAudit(c, tx, service.AuditDeviceTokenUpdate, deviceId, nil); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
 // Delete 删除设备及其所有相关数据
 func (h *DeviceHandler) Delete(c *gin.Context) {
 	deviceId := c.Query("device_id")
@@ -159,8 +404,15 @@ func (h *DeviceHandler) Delete(c *gin.Context) {
 		return
 	}
 
+	tx, err := h.db.DB.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to delete device")
+		return
+	}
+	defer tx.Rollback()
+
 	// 先删除pending_messages中的相关消息
-	_, err = h.db.DB.Exec(`
+	_, err = tx.Exec(`
 		DELETE FROM pending_messages WHERE device_id = $1
 	`, deviceId)
 
@@ -170,7 +422,7 @@ func (h *DeviceHandler) Delete(c *gin.Context) {
 	}
 
 	// 删除设备记录
-	result, err := h.db.DB.Exec(`
+	result, err := tx.Exec(`
 		DELETE FROM devices WHERE device_id = $1
 	`, deviceId)
 
@@ -185,6 +437,17 @@ func (h *DeviceHandler) Delete(c *gin.Context) {
 		return
 	}
 
+	// 审计事件与删除在同一事务内提交
+	err = recordAudit(c, tx, service.AuditDeviceDelete, deviceId, nil)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to delete device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to delete device")
+		return
+	}
+
 	RespondSuccess(c, http.StatusOK, gin.H{
 		"message": "Device deleted successfully",
 	})
@@ -206,13 +469,24 @@ func (h *DeviceHandler) GetPushToken(deviceId string) (string, error) {
 	return h.encryption.Decrypt(encryptedToken)
 }
 
-// GetPublicKey 内部方法：根据device_id获取public_key
-func (h *DeviceHandler) GetPublicKey(deviceId string) (string, error) {
-	var publicKey string
+// GetPublicKey 内部方法：根据device_id获取当前public_key及其密钥ID
+func (h *DeviceHandler) GetPublicKey(deviceId string) (string, string, error) {
+	var publicKey, keyID sql.NullString
+	err := h.db.DB.QueryRow(`
+		SELECT public_key, public_key_id FROM devices 
+		WHERE device_id = $1 AND is_active = true
+	`, deviceId).Scan(&publicKey, &keyID)
+
+	return publicKey.String, keyID.String, err
+}
+
+// GetPrivacyLevel 内部方法：根据device_id获取通知隐私级别
+func (h *DeviceHandler) GetPrivacyLevel(deviceId string) (string, error) {
+	var privacyLevel string
 	err := h.db.DB.QueryRow(`
-		SELECT public_key FROM devices 
+		SELECT privacy_level FROM devices
 		WHERE device_id = $1 AND is_active = true
-	`, deviceId).Scan(&publicKey)
+	`, deviceId).Scan(&privacyLevel)
 
-	return publicKey, err
+	return privacyLevel, err
 }
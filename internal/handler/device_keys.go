@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// KeyChallengeRequest 申请公钥轮换挑战
+type KeyChallengeRequest struct {
+	DeviceId string `json:"device_id" binding:"required"`
+}
+
+// RotateKeyRequest 公钥轮换请求
+// ChallengeResponse 为用当前私钥解密挑战后得到的 content
+type RotateKeyRequest struct {
+	DeviceId          string `json:"device_id" binding:"required"`
+	ChallengeID       string `json:"challenge_id" binding:"required"`
+	ChallengeResponse string `json:"challenge_response" binding:"required"`
+	NewPublicKey      string `json:"new_public_key" binding:"required"`
+}
+
+// ListKeys 列出设备公钥环
+// GET /api/v1/device/keys?device_id=xxx
+func (h *DeviceHandler) ListKeys(c *gin.Context) {
+	deviceId := c.Query("device_id")
+	if _, err := uuid.Parse(deviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	keys, err := service.ListDeviceKeys(c.Request.Context(), h.db.DB, deviceId)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list keys for device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to list device keys")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"keys": keys,
+	})
+}
+
+// CreateKeyChallenge 生成用当前公钥加密的轮换挑战
+// POST /api/v1/device/keys/challenge
+func (h *DeviceHandler) CreateKeyChallenge(c *gin.Context) {
+	var req KeyChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	challenge, err := service.CreateKeyRotationChallenge(c.Request.Context(), h.db.DB, h.cryptoService, req.DeviceId, h.serverName)
+	if err != nil {
+		respondKeyRotationError(c, req.DeviceId, err)
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, challenge)
+}
+
+// RotateKey 证明持有当前私钥后替换设备公钥
+// POST /api/v1/device/keys/rotate
+func (h *DeviceHandler) RotateKey(c *gin.Context) {
+	var req RotateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	newKey, err := service.ParseDevicePublicKey(req.NewPublicKey)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid new_public_key: "+err.Error())
+		return
+	}
+
+	if err := service.RotateDeviceKey(c.Request.Context(), h.db.DB, req.DeviceId, req.ChallengeID, req.ChallengeResponse, newKey, auditActor(c, req.DeviceId)); err != nil {
+		respondKeyRotationError(c, req.DeviceId, err)
+		return
+	}
+
+	logger.Info("Rotated public key of device %s to %s", req.DeviceId, newKey.KeyID)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"public_key_id":          newKey.KeyID,
+		"public_key_fingerprint": newKey.Fingerprint,
+		"crypto_version":         newKey.CryptoVersion(),
+	})
+}
+
+func respondKeyRotationError(c *gin.Context, deviceId string, err error) {
+	switch {
+	case errors.Is(err, service.ErrDeviceNotFound):
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+	case errors.Is(err, service.ErrDeviceHasNoKey):
+		RespondError(c, http.StatusBadRequest, models.OperationFailed, "Device has no public key, register one first")
+	case errors.Is(err, service.ErrKeyRotationSameKey):
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+	case errors.Is(err, service.ErrKeyChallengeInvalid),
+		errors.Is(err, service.ErrKeyChallengeMismatch),
+		errors.Is(err, service.ErrKeyChallengeStale):
+		RespondError(c, http.StatusForbidden, models.PermissionDenied, err.Error())
+	default:
+		logger.ErrorWithStack(err, "Key rotation failed for device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Key rotation failed")
+	}
+}
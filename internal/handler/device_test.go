@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegisterRejectsInvalidPublicKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/device/register", (&DeviceHandler{}).Register)
+
+	body := `{"push_token":"token","public_key":"-----BEGIN PUBLIC KEY-----\nbm90IGEga2V5\n-----END PUBLIC KEY-----\n"}`
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/device/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	if !strings.Contains(resp.Body.String(), "Invalid public_key") {
+		t.Fatalf("unexpected response body: %s", resp.Body.String())
+	}
+}
+
+func TestRotateKeyRejectsInvalidNewPublicKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/device/keys/rotate", (&DeviceHandler{}).RotateKey)
+
+	body := `{
+		"device_id": "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61",
+		"challenge_id": "6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88",
+		"challenge_response": "answer",
+		"new_public_key": "not a pem"
+	}`
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/device/keys/rotate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	if !strings.Contains(resp.Body.String(), "Invalid new_public_key") {
+		t.Fatalf("unexpected response body: %s", resp.Body.String())
+	}
+}
+
+func TestRegisterRejectsInvalidPrivacyLevel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/device/register", (&DeviceHandler{}).Register)
+
+	body := `{"push_token":"token","privacy_level":"secret"}`
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/device/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+	if !strings.Contains(resp.Body.String(), "Invalid privacy_level") {
+		t.Fatalf("unexpected response body: %s", resp.Body.String())
+	}
+}
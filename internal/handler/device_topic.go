@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SubscribeTopicRequest 设备订阅主题
+type SubscribeTopicRequest struct {
+	DeviceId string `json:"device_id" binding:"required"`
+	Topic    string `json:"topic" binding:"required"`
+}
+
+// ListTopics 查询设备所在的分组：App 订阅的主题和管理员分配的分组
+// GET /api/v1/device/topics?device_id=xxx
+func (h *DeviceHandler) ListTopics(c *gin.Context) {
+	deviceId := c.Query("device_id")
+	if _, err := uuid.Parse(deviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	groups, err := service.ListDeviceGroups(c.Request.Context(), h.db.DB, deviceId)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list groups of device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query topics")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"deviceId":         deviceId,
+		"groups":           groups,
+		"maxSubscriptions": service.MaxDeviceSubscriptions,
+	})
+}
+
+// SubscribeTopic 设备订阅主题，之后推送到该主题的消息也会发给本设备
+// 有管理员分配成员的分组不能订阅，避免设备自行加入并收到发给该分组的消息
+// POST /api/v1/device/topics
+func (h *DeviceHandler) SubscribeTopic(c *gin.Context) {
+	var req SubscribeTopicRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+	if !service.IsValidGroupName(req.Topic) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid topic name")
+		return
+	}
+
+	subscribed, err := service.SubscribeGroup(c.Request.Context(), h.db.DB, req.DeviceId, req.Topic, auditActor(c, req.DeviceId))
+	switch {
+	case errors.Is(err, service.ErrDeviceNotFound):
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	case errors.Is(err, service.ErrGroupManaged):
+		RespondError(c, http.StatusForbidden, models.PermissionDenied, err.Error())
+		return
+	case errors.Is(err, service.ErrTooManySubscriptions), errors.Is(err, service.ErrGroupFull):
+		RespondError(c, http.StatusConflict, models.BusinessError, err.Error())
+		return
+	case err != nil:
+		logger.ErrorWithStack(err, "Failed to subscribe device %s to topic: %s", req.DeviceId, req.Topic)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to subscribe topic")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"topic":      req.Topic,
+		"subscribed": subscribed,
+	})
+}
+
+// UnsubscribeTopic 设备取消订阅主题；管理员分配的分组只能由管理员移除
+// DELETE /api/v1/device/topics?device_id=xxx&topic=xxx
+func (h *DeviceHandler) UnsubscribeTopic(c *gin.Context) {
+	deviceId := c.Query("device_id")
+	topic := c.Query("topic")
+	if _, err := uuid.Parse(deviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+	if !service.IsValidGroupName(topic) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid topic name")
+		return
+	}
+
+	removed, err := service.UnsubscribeGroup(c.Request.Context(), h.db.DB, deviceId, topic, auditActor(c, deviceId))
+	if errors.Is(err, service.ErrGroupMembershipManaged) {
+		RespondError(c, http.StatusForbidden, models.PermissionDenied, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to unsubscribe device %s from topic: %s", deviceId, topic)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to unsubscribe topic")
+		return
+	}
+	if !removed {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device is not subscribed to this topic")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message": "Topic unsubscribed successfully",
+	})
+}
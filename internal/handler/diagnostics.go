@@ -6,6 +6,7 @@ import (
 
 	"github.com/dengdeng-harmonyos/server/internal/logger"
 	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -15,11 +16,17 @@ type DiagnosticsHandler struct {
 }
 
 type DeviceDiagnosticsResponse struct {
-	Exists              bool   `json:"exists"`
-	HasPublicKey        bool   `json:"hasPublicKey"`
-	IsActive            bool   `json:"isActive"`
-	LastActiveAt        string `json:"lastActiveAt"`
-	PendingMessageCount int64  `json:"pendingMessageCount"`
+	Exists               bool   `json:"exists"`
+	HasPublicKey         bool   `json:"hasPublicKey"`
+	PublicKeyFingerprint string `json:"publicKeyFingerprint,omitempty"`
+	PrivacyLevel         string `json:"privacyLevel,omitempty"`
+	IsActive             bool   `json:"isActive"`
+	LastActiveAt         string `json:"lastActiveAt"`
+	PendingMessageCount  int64  `json:"pendingMessageCount"`
+	// MessageSequence compares assigned sequence numbers with the last one the
+	// app reported; HasMessageGap is set when messages after it were missed.
+	MessageSequence *service.SequenceState `json:"messageSequence,omitempty"`
+	HasMessageGap   bool                   `json:"hasMessageGap"`
 }
 
 func NewDiagnosticsHandler(db *sql.DB) *DiagnosticsHandler {
@@ -43,11 +50,13 @@ func (h *DiagnosticsHandler) Device(c *gin.Context) {
 	err := h.db.QueryRow(`
 		SELECT
 			(public_key IS NOT NULL AND public_key <> '') AS has_public_key,
+			COALESCE(public_key_fingerprint, '') AS public_key_fingerprint,
+			privacy_level,
 			is_active,
 			to_char(last_active_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') AS last_active_at
 		FROM devices
 		WHERE device_id = $1
-	`, deviceID).Scan(&response.HasPublicKey, &response.IsActive, &response.LastActiveAt)
+	`, deviceID).Scan(&response.HasPublicKey, &response.PublicKeyFingerprint, &response.PrivacyLevel, &response.IsActive, &response.LastActiveAt)
 	if err == sql.ErrNoRows {
 		RespondSuccess(c, http.StatusOK, response)
 		return
@@ -71,5 +80,14 @@ func (h *DiagnosticsHandler) Device(c *gin.Context) {
 		return
 	}
 
+	sequence, err := service.GetSequenceState(c.Request.Context(), h.db, deviceID)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to query message sequence for device: %s", deviceID)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query diagnostics")
+		return
+	}
+	response.MessageSequence = &sequence
+	response.HasMessageGap = sequence.MissedCount > 0
+
 	RespondSuccess(c, http.StatusOK, response)
 }
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+)
+
+// HTTPSRedirect redirects plain HTTP requests to the HTTPS listener on
+// httpsPort, keeping the host, path and query.
+func HTTPSRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		// 308 保留请求方法和请求体，POST 不会被改成 GET
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
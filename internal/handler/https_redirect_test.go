@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSRedirectKeepsHostPathAndQuery(t *testing.T) {
+	cases := []struct {
+		port, host, want string
+	}{
+		{port: "443", host: "push.example.com:80", want: "https://push.example.com/api/v1/push/notification?device_id=x"},
+		{port: "8443", host: "push.example.com", want: "https://push.example.com:8443/api/v1/push/notification?device_id=x"},
+		{port: "8443", host: "[2001:db8::1]:8080", want: "https://[2001:db8::1]:8443/api/v1/push/notification?device_id=x"},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/push/notification?device_id=x", nil)
+		req.Host = tc.host
+		resp := httptest.NewRecorder()
+		HTTPSRedirect(tc.port).ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusPermanentRedirect {
+			t.Fatalf("%s: status = %d, want 308", tc.host, resp.Code)
+		}
+		if got := resp.Header().Get("Location"); got != tc.want {
+			t.Fatalf("%s: Location = %q, want %q", tc.host, got, tc.want)
+		}
+	}
+}
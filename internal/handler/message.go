@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/dengdeng-harmonyos/server/internal/logger"
@@ -17,28 +21,51 @@ import (
 type MessageHandler struct {
 	db            *sql.DB
 	cryptoService *service.CryptoService
+	signer        *service.MessageSigner
+	hub           *service.MessageHub // 为空时不提供实时消息流
+	confirmSync   ConfirmSyncer       // 为空时不清除其它设备上的通知
+}
+
+// ConfirmSyncer 通知同一接收者的其它设备：消息已在另一台设备上确认
+type ConfirmSyncer interface {
+	SyncConfirmed(siblings []service.ConfirmedSibling)
 }
 
 // NewMessageHandler 创建消息处理器
-func NewMessageHandler(db *sql.DB) *MessageHandler {
+func NewMessageHandler(db *sql.DB, signer *service.MessageSigner, hub *service.MessageHub, confirmSync ConfirmSyncer) *MessageHandler {
 	return &MessageHandler{
 		db:            db,
 		cryptoService: service.NewCryptoService(),
+		signer:        signer,
+		hub:           hub,
+		confirmSync:   confirmSync,
 	}
 }
 
 // PendingMessage 待接收消息响应
 type PendingMessage struct {
-	ID               string `json:"id"`
-	ServerName       string `json:"serverName"`
-	EncryptedAESKey  string `json:"encryptedAESKey"`
-	EncryptedContent string `json:"encryptedContent"`
-	IV               string `json:"iv"`
-	CreatedAt        string `json:"createdAt"` // ISO 8601格式时间（带时区UTC）
+	ID                 string `json:"id"`
+	ServerName         string `json:"serverName"`
+	CryptoVersion      int    `json:"cryptoVersion"`           // 1: RSA+AES-GCM, 2: X25519+ChaCha20-Poly1305
+	EnvelopeVersion    int    `json:"envelopeVersion"`         // 2: 密文绑定设备、消息ID、服务器名称和创建时间
+	KeyID              string `json:"keyId,omitempty"`         // 加密所用的设备公钥ID
+	BoundDeviceID      string `json:"boundDeviceId,omitempty"` // 密文和签名绑定的设备ID，仅合并重复设备转来的消息与当前设备不同
+	Signature          string `json:"signature,omitempty"`     // 服务端Ed25519签名（base64）
+	SigningKeyID       string `json:"signingKeyId,omitempty"`  // 签名公钥ID
+	EncryptedAESKey    string `json:"encryptedAESKey"`
+	EphemeralPublicKey string `json:"ephemeralPublicKey,omitempty"` // 仅v2
+	EncryptedContent   string `json:"encryptedContent"`
+	IV                 string `json:"iv"`
+	CreatedAt          string `json:"createdAt"`             // ISO 8601格式时间（带时区UTC）
+	Cursor             string `json:"cursor"`                // 作为 after 参数可从这条消息之后继续拉取
+	CollapseKey        string `json:"collapseKey,omitempty"` // 折叠键，App可据此替换本地同键的旧消息
+	Seq                int64  `json:"seq"`                   // 设备内递增的序号，不连续说明有消息过期、被撤回或被替换；旧消息为0
 }
 
-// GetPendingMessages 获取待接收的消息
-// GET /api/messages/pending?device_id=xxx
+// GetPendingMessages 获取待接收的消息，按设备内序号分页
+// GET /api/messages/pending?device_id=xxx&after=<cursor>&limit=100&wait=30
+//
+// wait 大于0时为长轮询：没有待接收消息则最多等待 wait 秒，新消息保存后立即返回
 func (h *MessageHandler) GetPendingMessages(c *gin.Context) {
 	// 从 query 获取 device_id
 	deviceId := c.Query("device_id")
@@ -54,49 +81,192 @@ func (h *MessageHandler) GetPendingMessages(c *gin.Context) {
 		return
 	}
 
-	// 查询未投递的消息
-	// 注意：TIMESTAMPTZ自动处理时区，返回ISO 8601格式（带时区）
-	rows, err := h.db.Query(`
-		SELECT id::TEXT, server_name, encrypted_aes_key, encrypted_content, iv, 
-		       to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as created_at
-		FROM pending_messages
-		WHERE device_id = $1 
-		  AND delivered = false 
-		  AND expires_at > NOW()
-		ORDER BY created_at ASC
-		LIMIT 100
-	`, deviceId)
+	after, limit, err := parsePendingPage(c)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
 
+	wait, err := parsePendingWait(c)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	var page pendingPage
+	if wait > 0 && h.hub != nil {
+		wake, cancel, ok := h.hub.SubscribeWaiter(deviceId, service.MaxWaitersPerDevice)
+		if !ok {
+			RespondError(c, http.StatusTooManyRequests, models.RateLimited,
+				fmt.Sprintf("Too many concurrent waiting requests for this device (max %d)", service.MaxWaitersPerDevice))
+			return
+		}
+		defer cancel()
+		page, err = h.waitPendingMessages(c.Request.Context(), deviceId, after, limit, wait, wake)
+	} else {
+		page, err = h.queryPendingMessages(c.Request.Context(), deviceId, after, limit)
+	}
 	if err != nil {
+		if c.Request.Context().Err() != nil {
+			return // 客户端已断开
+		}
 		logger.ErrorWithStack(err, "Failed to query pending messages for device: %s", deviceId)
 		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query messages: "+err.Error())
 		return
 	}
+
+	RespondSuccess(c, http.StatusOK, page.response())
+}
+
+// pendingPage 一页待接收消息
+type pendingPage struct {
+	Messages   []PendingMessage
+	HasMore    bool
+	NextCursor string
+}
+
+func (p pendingPage) response() gin.H {
+	return gin.H{
+		"messages":   p.Messages,
+		"count":      len(p.Messages),
+		"hasMore":    p.HasMore,
+		"nextCursor": p.NextCursor,
+	}
+}
+
+// parsePendingPage 解析分页参数：after 为上一页的 nextCursor，limit 默认100、最大500
+func parsePendingPage(c *gin.Context) (*service.MessageCursor, int, error) {
+	limit := service.DefaultPendingMessageLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > service.MaxPendingMessageLimit {
+			return nil, 0, &pushValidationError{message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPendingMessageLimit)}
+		}
+		limit = parsed
+	}
+
+	value := c.Query("after")
+	if value == "" {
+		return nil, limit, nil
+	}
+	cursor, err := service.DecodeMessageCursor(value)
+	if err != nil {
+		return nil, 0, &pushValidationError{message: "Invalid after cursor"}
+	}
+	return &cursor, limit, nil
+}
+
+// parsePendingWait 解析长轮询等待秒数，0 表示立即返回
+func parsePendingWait(c *gin.Context) (time.Duration, error) {
+	value := c.Query("wait")
+	if value == "" {
+		return 0, nil
+	}
+	maxSeconds := int(service.MaxPendingMessageWait / time.Second)
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 || seconds > maxSeconds {
+		return 0, &pushValidationError{message: fmt.Sprintf("wait must be between 0 and %d seconds", maxSeconds)}
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// waitPendingMessages 有待接收消息时立即返回，否则等待新消息通知直到超时
+// 调用前已订阅通知，因此查询与等待之间保存的消息不会被漏掉
+func (h *MessageHandler) waitPendingMessages(ctx context.Context, deviceId string, after *service.MessageCursor, limit int, wait time.Duration, wake <-chan struct{}) (pendingPage, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		page, err := h.queryPendingMessages(ctx, deviceId, after, limit)
+		if err != nil || len(page.Messages) > 0 {
+			return page, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return pendingPage{}, ctx.Err()
+		case <-timer.C:
+			return page, nil
+		case <-wake:
+		}
+	}
+}
+
+// queryPendingMessages 查询游标之后的未投递消息，并只将返回的消息标记为已发送通知
+// 消息按 seq 排序
+// 注意：TIMESTAMPTZ自动处理时区，返回ISO 8601格式（带时区）
+func (h *MessageHandler) queryPendingMessages(ctx context.Context, deviceId string, after *service.MessageCursor, limit int) (pendingPage, error) {
+	var afterSeq interface{}
+	if after != nil {
+		afterSeq = after.Seq
+	}
+
+	// 多取一条用于判断是否还有下一页；019 之前的消息序号为负，对外显示为0
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id::TEXT, server_name, crypto_version, envelope_version, COALESCE(key_id, ''), encrypted_aes_key,
+		       COALESCE(ephemeral_public_key, ''), encrypted_content, iv,
+		       COALESCE(signature, ''), COALESCE(signing_key_id, ''),
+		       to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as created_at_text,
+		       COALESCE(collapse_key, ''), seq, COALESCE(bound_device_id::TEXT, '')
+		FROM pending_messages
+		WHERE device_id = $1 
+		  AND delivered = false 
+		  AND expires_at > NOW()
+		  AND seq IS NOT NULL
+		  AND ($2::BIGINT IS NULL OR seq > $2::BIGINT)
+		ORDER BY seq ASC
+		LIMIT $3
+	`, deviceId, afterSeq, limit+1)
+	if err != nil {
+		return pendingPage{}, err
+	}
 	defer rows.Close()
 
-	messages := []PendingMessage{}
+	page := pendingPage{Messages: []PendingMessage{}}
 	for rows.Next() {
-		var msg PendingMessage
-		if err := rows.Scan(&msg.ID, &msg.ServerName, &msg.EncryptedAESKey,
-			&msg.EncryptedContent, &msg.IV, &msg.CreatedAt); err != nil {
+		var (
+			msg PendingMessage
+			seq int64
+		)
+		if err := rows.Scan(&msg.ID, &msg.ServerName, &msg.CryptoVersion, &msg.EnvelopeVersion, &msg.KeyID, &msg.EncryptedAESKey,
+			&msg.EphemeralPublicKey, &msg.EncryptedContent, &msg.IV,
+			&msg.Signature, &msg.SigningKeyID, &msg.CreatedAt, &msg.CollapseKey, &seq, &msg.BoundDeviceID); err != nil {
 			continue
 		}
-		messages = append(messages, msg)
+		if len(page.Messages) == limit {
+			page.HasMore = true
+			break
+		}
+		if seq > 0 {
+			msg.Seq = seq
+		}
+		msg.Cursor = service.MessageCursor{Seq: seq}.Encode()
+		page.Messages = append(page.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return pendingPage{}, err
 	}
 
-	// 标记为已发送
-	if len(messages) > 0 {
-		_, _ = h.db.Exec(`
-			UPDATE pending_messages 
-			SET notification_sent = true 
-			WHERE device_id = $1 AND delivered = false
-		`, deviceId)
+	if len(page.Messages) == 0 {
+		if after != nil {
+			page.NextCursor = after.Encode()
+		}
+		return page, nil
 	}
+	page.NextCursor = page.Messages[len(page.Messages)-1].Cursor
 
-	RespondSuccess(c, http.StatusOK, gin.H{
-		"messages": messages,
-		"count":    len(messages),
-	})
+	// 只标记本次返回的消息
+	ids := make([]string, 0, len(page.Messages))
+	for _, msg := range page.Messages {
+		ids = append(ids, msg.ID)
+	}
+	_, _ = h.db.ExecContext(ctx, `
+		UPDATE pending_messages 
+		SET notification_sent = true 
+		WHERE device_id = $1 AND id::TEXT = ANY($2)
+	`, deviceId, pq.Array(ids))
+
+	return page, nil
 }
 
 // ConfirmMessagesRequest 确认消息请求
@@ -135,13 +305,37 @@ func (h *MessageHandler) ConfirmMessages(c *gin.Context) {
 		return
 	}
 
-	// 构建 SQL IN 子句 - 使用 pq.Array 将字符串数组转换为 PostgreSQL 数组
+	// 标记为已确认并立即清除密文，只保留状态供推送方查询，由清理任务按保留期删除；
+	// 发给接收者的消息同时确认其它设备上的副本
+	// 使用 pq.Array 将字符串数组转换为 PostgreSQL 数组
+	ctx := c.Request.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to begin confirm transaction for device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to confirm messages: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
 	query := `
-		DELETE FROM pending_messages
-		WHERE device_id = $1 AND id::TEXT = ANY($2)
+		UPDATE pending_messages
+		SET delivered = true,
+		    confirmed_at = NOW(),
+		    encrypted_aes_key = '',
+		    ephemeral_public_key = NULL,
+		    encrypted_content = '',
+		    iv = ''
+		WHERE device_id = $1 AND id::TEXT = ANY($2) AND delivered = false
 	`
 
-	result, err := h.db.Exec(query, req.DeviceId, pq.Array(req.MessageIDs))
+	result, err := tx.ExecContext(ctx, query, req.DeviceId, pq.Array(req.MessageIDs))
+	var siblings []service.ConfirmedSibling
+	if err == nil {
+		siblings, err = service.ConfirmRecipientSiblings(ctx, tx, req.DeviceId, req.MessageIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 
 		logger.ErrorWithStack(err, "Failed to confirm messages for device: %s, messageIDs: %v", req.DeviceId, req.MessageIDs)
@@ -152,26 +346,162 @@ func (h *MessageHandler) ConfirmMessages(c *gin.Context) {
 
 	rowsAffected, _ := result.RowsAffected()
 
-	logger.Info("Confirmed %d messages for device: %s", rowsAffected, req.DeviceId)
+	// 其它设备的通知清除在后台进行，不阻塞确认
+	if len(siblings) > 0 && h.confirmSync != nil {
+		go h.confirmSync.SyncConfirmed(siblings)
+	}
+
+	logger.Info("Confirmed %d messages for device: %s (%d copies on other devices)", rowsAffected, req.DeviceId, len(siblings))
 	RespondSuccess(c, http.StatusOK, gin.H{
 		"confirmedCount": rowsAffected,
+		"syncedCount":    len(siblings),
 	})
 }
 
-// SaveEncryptedMessage 保存加密消息到数据库
+// ReportLastSeenRequest 上报已处理的最大消息序号
+type ReportLastSeenRequest struct {
+	DeviceId string `json:"device_id" binding:"required"`
+	Seq      int64  `json:"seq"`
+}
+
+// ReportLastSeen 记录App已处理到的消息序号，用于诊断中标记丢失的消息
+// PUT /api/v1/messages/last-seen
+func (h *MessageHandler) ReportLastSeen(c *gin.Context) {
+	var req ReportLastSeenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+	if req.Seq < 0 {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "seq must not be negative")
+		return
+	}
+
+	state, err := service.ReportLastSeenSeq(c.Request.Context(), h.db, req.DeviceId, req.Seq)
+	switch {
+	case errors.Is(err, service.ErrSequenceDeviceNotFound):
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	case errors.Is(err, service.ErrSequenceAhead):
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	case err != nil:
+		logger.ErrorWithStack(err, "Failed to record last seen sequence for device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to record last seen sequence")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"lastSeenSeq":     state.LastSeenSeq,
+		"lastAssignedSeq": state.LastAssignedSeq,
+	})
+}
+
+// StoredMessageOptions 保存消息时的附加属性
+type StoredMessageOptions struct {
+	SenderKeyID string // 推送方API Key ID（未使用API Key时为空），撤回时校验
+	CollapseKey string // 折叠键：替换同一推送方相同折叠键的未确认消息
+	NotifyID    int32  // 通知栏通知ID，撤回和替换通知时使用
+	// RecipientMessageID 发给接收者时各设备副本共用的ID，任一设备确认后其余副本一并确认
+	RecipientMessageID string
+}
+
+// SaveEncryptedMessage 保存加密消息到数据库并签名
+// 消息ID和创建时间取自 binding，与密文的关联数据保持一致；
+// 设置折叠键时在同一事务中删除被替换的未确认消息，返回被替换的消息ID
 func (h *MessageHandler) SaveEncryptedMessage(
-	deviceId string,
-	serverName string,
+	binding service.MessageBinding,
 	encryptedMsg *service.EncryptedMessage,
-) error {
-	expiresAt := time.Now().Add(30 * 24 * time.Hour) // 30天后过期
+	opts StoredMessageOptions,
+) ([]string, error) {
+	expiresAt := binding.CreatedAt.Add(30 * 24 * time.Hour) // 30天后过期
+
+	cryptoVersion := encryptedMsg.CryptoVersion
+	if cryptoVersion == 0 {
+		cryptoVersion = service.MessageCryptoV1
+	}
+	envelopeVersion := encryptedMsg.EnvelopeVersion
+	if envelopeVersion == 0 {
+		envelopeVersion = service.MessageEnvelopeV1
+	}
+	// 签名覆盖实际保存的版本号，因此先补全默认值
+	signed := *encryptedMsg
+	signed.CryptoVersion = cryptoVersion
+	signed.EnvelopeVersion = envelopeVersion
+	var signature, signingKeyID string
+	if h.signer != nil {
+		signature = h.signer.Sign(binding, &signed)
+		signingKeyID = h.signer.KeyID()
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	_, err := h.db.Exec(`
+	seq, err := service.NextMessageSeq(context.Background(), tx, binding.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	superseded := []string{}
+	if opts.CollapseKey != "" {
+		rows, err := tx.Query(`
+			DELETE FROM pending_messages
+			WHERE device_id = $1
+			  AND collapse_key = $2
+			  AND sender_key_id IS NOT DISTINCT FROM $3::UUID
+			  AND delivered = false
+			RETURNING id::TEXT
+		`, binding.DeviceID, opts.CollapseKey, nullString(opts.SenderKeyID))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			superseded = append(superseded, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`
 		INSERT INTO pending_messages 
-		(device_id, server_name, encrypted_aes_key, encrypted_content, iv, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, deviceId, serverName, encryptedMsg.EncryptedAESKey,
-		encryptedMsg.EncryptedContent, encryptedMsg.IV, expiresAt)
+		(id, device_id, server_name, crypto_version, envelope_version, key_id, encrypted_aes_key, ephemeral_public_key,
+		 encrypted_content, iv, signature, signing_key_id, created_at, expires_at, sender_key_id, collapse_key, notify_id, seq,
+		 recipient_message_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15::UUID, $16, $17, $18, $19::UUID)
+	`, binding.MessageID, binding.DeviceID, binding.ServerName, cryptoVersion, envelopeVersion,
+		nullString(signed.KeyID), signed.EncryptedAESKey, nullString(signed.EphemeralPublicKey),
+		signed.EncryptedContent, signed.IV, nullString(signature), nullString(signingKeyID),
+		binding.CreatedAt, expiresAt, nullString(opts.SenderKeyID), nullString(opts.CollapseKey), opts.NotifyID, seq,
+		nullString(opts.RecipientMessageID))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// 通知所有实例上该设备的实时消息流；失败不影响保存，客户端仍可轮询获取
+	if err := service.NotifyPendingMessage(context.Background(), h.db, binding.DeviceID); err != nil {
+		logger.Error("Failed to notify pending message %s for device %s: %v", binding.MessageID, binding.DeviceID, err)
+	}
+	return superseded, nil
+}
 
-	return err
+// nullString 将空字符串转换为SQL NULL
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
 }
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// streamHeartbeatInterval 心跳间隔，需短于常见反向代理的空闲超时（nginx 默认60秒）
+	streamHeartbeatInterval = 25 * time.Second
+	// streamRetryMillis 建议客户端断线后的重连间隔
+	streamRetryMillis = 3000
+)
+
+// StreamPendingMessages 以 Server-Sent Events 实时推送待接收消息
+// GET /api/v1/messages/stream?device_id=xxx&after=<cursor>
+//
+// 每条消息是一个 message 事件，事件ID即消息游标；断线重连时浏览器 EventSource
+// 会自动携带 Last-Event-ID，其它客户端可用 after 参数，从该消息之后继续推送
+func (h *MessageHandler) StreamPendingMessages(c *gin.Context) {
+	deviceId := c.Query("device_id")
+	if deviceId == "" {
+		RespondError(c, http.StatusUnauthorized, models.Unauthorized, "Missing device key")
+		return
+	}
+	if _, err := uuid.Parse(deviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	after, err := parseStreamCursor(c)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	if h.hub == nil {
+		RespondError(c, http.StatusServiceUnavailable, models.SystemError, "Message stream is not available")
+		return
+	}
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Streaming is not supported")
+		return
+	}
+
+	// 先订阅再查询，避免查询与订阅之间到达的消息被漏掉；每台设备的并发连接数有上限
+	wake, unsubscribe, ok := h.hub.SubscribeStream(deviceId, service.MaxStreamsPerDevice)
+	if !ok {
+		RespondError(c, http.StatusTooManyRequests, models.RateLimited,
+			fmt.Sprintf("Too many concurrent streams for this device (max %d)", service.MaxStreamsPerDevice))
+		return
+	}
+	defer unsubscribe()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // 关闭 nginx 响应缓冲
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetryMillis)
+	flusher.Flush()
+
+	ctx := c.Request.Context()
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	logger.Info("Message stream opened for device: %s", deviceId)
+	defer logger.Info("Message stream closed for device: %s", deviceId)
+
+	for {
+		// 发送游标之后的所有消息，超过一页时继续取下一页
+		for {
+			page, err := h.queryPendingMessages(ctx, deviceId, after, service.DefaultPendingMessageLimit)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.ErrorWithStack(err, "Failed to query pending messages for stream, device: %s", deviceId)
+				}
+				return
+			}
+			for _, msg := range page.Messages {
+				if err := writeSSEEvent(c.Writer, "message", msg.Cursor, msg); err != nil {
+					return
+				}
+			}
+			if len(page.Messages) > 0 {
+				cursor, err := service.DecodeMessageCursor(page.NextCursor)
+				if err != nil {
+					return
+				}
+				after = &cursor
+				flusher.Flush()
+			}
+			if !page.HasMore {
+				break
+			}
+		}
+
+		// 等待新消息通知；心跳只保持连接，不重新查询
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+				waiting = false
+			case <-heartbeat.C:
+				if _, err := fmt.Fprintf(c.Writer, ": heartbeat %d\n\n", time.Now().Unix()); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// parseStreamCursor 读取续传游标，Last-Event-ID 优先于 after 参数
+func parseStreamCursor(c *gin.Context) (*service.MessageCursor, error) {
+	value := strings.TrimSpace(c.GetHeader("Last-Event-ID"))
+	if value == "" {
+		value = c.Query("after")
+	}
+	if value == "" {
+		return nil, nil
+	}
+	cursor, err := service.DecodeMessageCursor(value)
+	if err != nil {
+		return nil, &pushValidationError{message: "Invalid resume cursor"}
+	}
+	return &cursor, nil
+}
+
+// writeSSEEvent 写出一个 SSE 事件，data 编码为单行JSON
+func writeSSEEvent(w io.Writer, event string, id string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, payload)
+	return err
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamPendingMessagesValidatesBeforeStreaming(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/stream", NewMessageHandler(nil, nil, nil, nil).StreamPendingMessages)
+
+	cases := []struct {
+		name        string
+		query       string
+		lastEventID string
+		status      int
+		want        string
+	}{
+		{"missing device", "", "", http.StatusUnauthorized, "Missing device key"},
+		{"bad device", "device_id=nope", "", http.StatusBadRequest, "Invalid device_id format"},
+		{"bad after", "device_id=d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61&after=nope", "", http.StatusBadRequest, "Invalid resume cursor"},
+		{"bad last event id", "device_id=d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61", "nope", http.StatusBadRequest, "Invalid resume cursor"},
+		{"no hub", "device_id=d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61", "", http.StatusServiceUnavailable, "not available"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/stream?"+tc.query, nil)
+		if tc.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", tc.lastEventID)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != tc.status {
+			t.Fatalf("%s: status = %d, body = %s", tc.name, resp.Code, resp.Body.String())
+		}
+		if !strings.Contains(resp.Body.String(), tc.want) {
+			t.Fatalf("%s: unexpected body %s", tc.name, resp.Body.String())
+		}
+	}
+}
+
+func TestWriteSSEEvent(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSSEEvent(&buf, "message", "cursor-1", PendingMessage{ID: "m1", Cursor: "cursor-1"}); err != nil {
+		t.Fatalf("writeSSEEvent: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "id: cursor-1\nevent: message\ndata: {") || !strings.HasSuffix(out, "}\n\n") {
+		t.Fatalf("unexpected event framing: %q", out)
+	}
+	if strings.Count(out, "\n") != 4 {
+		t.Fatalf("data must be a single line: %q", out)
+	}
+}
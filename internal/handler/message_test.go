@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/dengdeng-harmonyos/server/internal/service"
 	"github.com/gin-gonic/gin"
 )
 
@@ -13,7 +15,7 @@ func TestConfirmMessagesEmptyListDoesNotTouchDatabase(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	router := gin.New()
-	router.POST("/confirm", NewMessageHandler(nil).ConfirmMessages)
+	router.POST("/confirm", NewMessageHandler(nil, nil, nil, nil).ConfirmMessages)
 
 	req := httptest.NewRequest(http.MethodPost, "/confirm", strings.NewReader(`{
 		"device_id": "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61",
@@ -31,3 +33,78 @@ func TestConfirmMessagesEmptyListDoesNotTouchDatabase(t *testing.T) {
 		t.Fatalf("response does not contain confirmedCount=0: %s", resp.Body.String())
 	}
 }
+
+func TestGetPendingMessagesRejectsInvalidPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/pending", NewMessageHandler(nil, nil, nil, nil).GetPendingMessages)
+
+	cases := map[string]string{
+		"limit=0":          "limit must be between",
+		"limit=501":        "limit must be between",
+		"after=not-cursor": "Invalid after cursor",
+		"wait=abc":         "wait must be between",
+		"wait=51":          "wait must be between",
+	}
+	for query, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/pending?device_id=d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61&"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, body = %s", query, resp.Code, resp.Body.String())
+		}
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Fatalf("%s: unexpected body %s", query, resp.Body.String())
+		}
+	}
+}
+
+func TestGetPendingMessagesCapsWaitersPerDevice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const deviceID = "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61"
+	hub := service.NewMessageHub()
+	for i := 0; i < service.MaxWaitersPerDevice; i++ {
+		_, cancel, ok := hub.SubscribeWaiter(deviceID, service.MaxWaitersPerDevice)
+		if !ok {
+			t.Fatalf("waiter %d rejected", i)
+		}
+		defer cancel()
+	}
+
+	router := gin.New()
+	router.GET("/pending", NewMessageHandler(nil, nil, hub, nil).GetPendingMessages)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/pending?device_id=%s&wait=30", deviceID), nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestReportLastSeenValidatesRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.PUT("/last-seen", NewMessageHandler(nil, nil, nil, nil).ReportLastSeen)
+
+	cases := map[string]string{
+		`{"seq": 1}`:                      "Invalid request",
+		`{"device_id": "nope", "seq": 1}`: "Invalid device_id format",
+		`{"device_id": "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61", "seq": -1}`: "seq must not be negative",
+	}
+	for body, want := range cases {
+		req := httptest.NewRequest(http.MethodPut, "/last-seen", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), want) {
+			t.Fatalf("%s: status = %d, body = %s", body, resp.Code, resp.Body.String())
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
 	"strings"
@@ -11,10 +13,12 @@ import (
 	"github.com/dengdeng-harmonyos/server/internal/config"
 	"github.com/dengdeng-harmonyos/server/internal/database"
 	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/middleware"
 	"github.com/dengdeng-harmonyos/server/internal/models"
 	"github.com/dengdeng-harmonyos/server/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const (
@@ -46,15 +50,18 @@ type PushHandler struct {
 	serverName     string
 	cryptoService  *service.CryptoService
 	messageHandler *MessageHandler
+	apiKeys        *service.APIKeyService
 }
 
 type backgroundSyncSignal struct {
 	Type       string `json:"type"`
 	ServerName string `json:"server_name"`
+	MessageID  string `json:"message_id,omitempty"` // 仅 recall 和 confirmed
+	Proof      string `json:"proof,omitempty"`      // 仅 token_proof
 	CreatedAt  string `json:"created_at"`
 }
 
-func NewPushHandler(db *database.Database, deviceHandler *DeviceHandler, cfg config.HuaweiPushConfig, serverName string) (*PushHandler, error) {
+func NewPushHandler(db *database.Database, deviceHandler *DeviceHandler, apiKeys *service.APIKeyService, signer *service.MessageSigner, cfg config.HuaweiPushConfig, serverName string) (*PushHandler, error) {
 	pushService, err := service.NewHuaweiPushService(cfg)
 	if err != nil {
 		return nil, err
@@ -66,7 +73,8 @@ func NewPushHandler(db *database.Database, deviceHandler *DeviceHandler, cfg con
 		deviceHandler:  deviceHandler,
 		serverName:     serverName,
 		cryptoService:  service.NewCryptoService(),
-		messageHandler: NewMessageHandler(db.DB),
+		messageHandler: NewMessageHandler(db.DB, signer, nil, nil),
+		apiKeys:        apiKeys,
 	}, nil
 }
 
@@ -79,12 +87,24 @@ func (h *PushHandler) SendNotification(c *gin.Context) {
 		return
 	}
 
+	req.DeviceId = grantTargetDevice(middleware.SenderAPIKey(c), req.DeviceId)
+
 	// 验证 device_id 格式是否为有效的 UUID
-	if _, err := uuid.Parse(req.DeviceId); err != nil {
+	deviceUUID, err := uuid.Parse(req.DeviceId)
+	if err != nil {
 		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
 		return
 	}
 
+	if !h.authorizeSender(c, req.DeviceId) {
+		return
+	}
+
+	privacyLevel, ok := h.resolvePrivacyLevel(c, req.DeviceId, req.Privacy)
+	if !ok {
+		return
+	}
+
 	// 根据device_id获取push_token
 	pushToken, err := h.deviceHandler.GetPushToken(req.DeviceId)
 	if err != nil {
@@ -107,50 +127,60 @@ func (h *PushHandler) SendNotification(c *gin.Context) {
 		return
 	}
 
+	if req.CollapseKey != "" {
+		if err := service.ValidateCollapseKey(req.CollapseKey); err != nil {
+			RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+			return
+		}
+	}
+
 	// 获取设备公钥
-	publicKey, err := h.deviceHandler.GetPublicKey(req.DeviceId)
+	publicKey, keyID, err := h.deviceHandler.GetPublicKey(req.DeviceId)
 	if err != nil || publicKey == "" {
 		// 必须有公钥才能处理推送
 		RespondError(c, http.StatusBadRequest, models.OperationFailed, "Device public key not found, please register device first")
 		return
 	}
 
-	// 1. 加密消息内容
+	if !h.consumeSenderQuota(c, 1) {
+		return
+	}
+
+	// 1. 加密消息内容，密文绑定设备、消息ID、服务器名称和创建时间
 	messageContent := service.MessageContent{
 		Title:      req.Title,
 		Content:    req.Content,
 		Data:       dataArray,
 		ServerName: h.serverName,
 	}
-	encryptedMsg, err := h.cryptoService.EncryptMessage(publicKey, messageContent)
+	binding := service.NewMessageBinding(deviceUUID.String(), uuid.NewString(), h.serverName, time.Now())
+	encryptedMsg, err := h.cryptoService.EncryptMessage(publicKey, messageContent, binding)
 	if err != nil {
 		logger.ErrorWithStack(err, "Failed to encrypt message for device: %s", req.DeviceId)
 		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to send notification: "+err.Error())
 		return
 	}
+	encryptedMsg.KeyID = keyID
 
 	// 2. 先保存加密消息，确保后台唤醒或普通通知到达时 App 已有 pending 可拉取。
-	err = h.messageHandler.SaveEncryptedMessage(req.DeviceId, h.serverName, encryptedMsg)
+	opts := h.storedMessageOptions(c, binding.MessageID, req.CollapseKey)
+	superseded, err := h.messageHandler.SaveEncryptedMessage(binding, encryptedMsg, opts)
 	if err != nil {
 		logger.ErrorWithStack(err, "Failed to save encrypted message for device: %s", req.DeviceId)
 		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to save message: "+err.Error())
 		return
 	}
 
-	// 3. 有 pending 消息时发送一次低频后台唤醒信号，失败不影响普通通知。
-	h.maybeSendBackgroundSyncSignal(req.DeviceId, pushToken)
-
-	// 4. 发送华为推送通知（明文内容，用于显示通知）
-	notificationData := map[string]interface{}{
-		"type":          "new_message",
-		"server_name":   h.serverName,
-		"__server_name": h.serverName,
-	}
-	if messageURL != "" {
-		notificationData["__url"] = messageURL
+	// 3. 发送后台唤醒信号和华为推送通知（明文内容，用于显示通知；隐私模式下为占位文案）
+	notification := storedNotification{
+		MessageID:    binding.MessageID,
+		Title:        req.Title,
+		Content:      req.Content,
+		URL:          messageURL,
+		PrivacyLevel: privacyLevel,
+		NotifyID:     opts.NotifyID,
 	}
-	err = h.pushService.SendNotification(pushToken, req.Title, req.Content, notificationData)
-	if err != nil {
+	if err := h.notifyStoredMessage(req.DeviceId, pushToken, notification); err != nil {
 		logger.ErrorWithStack(err, "Failed to send push notification for device: %s", req.DeviceId)
 		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to send notification: "+err.Error())
 		return
@@ -159,10 +189,148 @@ func (h *PushHandler) SendNotification(c *gin.Context) {
 	logger.Info("Successfully sent notification to device: %s, title: %s", req.DeviceId, req.Title)
 
 	RespondSuccess(c, http.StatusOK, gin.H{
-		"message": "Notification sent successfully",
+		"message":       "Notification sent successfully",
+		"message_id":    binding.MessageID,
+		"privacy_level": privacyLevel,
+		"notify_id":     opts.NotifyID,
+		"superseded":    superseded,
 	})
 }
 
+// storedNotification 已保存消息对应的通知栏内容
+type storedNotification struct {
+	MessageID    string
+	Title        string
+	Content      string
+	URL          string
+	PrivacyLevel string
+	NotifyID     int32
+}
+
+// notifyStoredMessage 在消息保存后通知设备：有 pending 消息时发送一次低频后台唤醒信号
+// （失败不影响普通通知），然后发送显示用的通知
+func (h *PushHandler) notifyStoredMessage(deviceID string, pushToken string, notification storedNotification) error {
+	h.maybeSendBackgroundSyncSignal(deviceID, pushToken)
+
+	title, content, notificationData := notificationPayload(h.serverName, notification)
+	return h.pushService.SendNotification(pushToken, notification.NotifyID, title, content, notificationData)
+}
+
+// notificationPayload 生成通知栏标题、内容和附加数据
+// 隐私模式下只显示占位文案，URL等敏感数据也不放入通知，App凭 message_id 解密待接收消息后展示真实内容
+func notificationPayload(serverName string, notification storedNotification) (string, string, map[string]interface{}) {
+	title, content := notification.Title, notification.Content
+	notificationData := map[string]interface{}{
+		"type":          "new_message",
+		"server_name":   serverName,
+		"__server_name": serverName,
+		"message_id":    notification.MessageID,
+	}
+
+	if notification.PrivacyLevel == service.PrivacyLevelPrivate {
+		title, content = service.PrivateNotificationText(serverName)
+		notificationData["privacy_level"] = service.PrivacyLevelPrivate
+		return title, content, notificationData
+	}
+
+	if notification.URL != "" {
+		notificationData["__url"] = notification.URL
+	}
+	return title, content, notificationData
+}
+
+// resolvePrivacyLevel 校验请求的隐私级别并与设备设置合并
+// 设备不存在时按请求级别处理，由后续的设备查询返回404
+func (h *PushHandler) resolvePrivacyLevel(c *gin.Context, deviceID string, requested string) (string, bool) {
+	if requested != "" && !service.IsValidPrivacyLevel(requested) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid privacy, expected standard or private")
+		return "", false
+	}
+
+	deviceLevel, err := h.deviceHandler.GetPrivacyLevel(deviceID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.ErrorWithStack(err, "Failed to query privacy level for device: %s", deviceID)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query device")
+		return "", false
+	}
+
+	return service.EffectivePrivacyLevel(deviceLevel, requested), true
+}
+
+// authorizeSender checks that the sender API key, if any, may reach every
+// target device.
+func (h *PushHandler) authorizeSender(c *gin.Context, deviceIDs ...string) bool {
+	key := middleware.SenderAPIKey(c)
+	if key == nil {
+		return true
+	}
+
+	for _, deviceID := range deviceIDs {
+		allowed, err := h.apiKeys.AllowsDevice(c.Request.Context(), key, deviceID)
+		if err != nil {
+			logger.ErrorWithStack(err, "Failed to check API key %s for device: %s", key.ID, deviceID)
+			RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to authorize API key")
+			return false
+		}
+		if !allowed {
+			RespondError(c, http.StatusForbidden, models.PermissionDenied, "API key is not allowed to push to this device")
+			return false
+		}
+	}
+	return true
+}
+
+// storedMessageOptions 生成保存消息的附加属性
+// 设置折叠键时同键消息共用一个通知ID，新通知替换旧通知；否则通知ID由消息ID派生
+func (h *PushHandler) storedMessageOptions(c *gin.Context, messageID string, collapseKey string) StoredMessageOptions {
+	opts := StoredMessageOptions{
+		SenderKeyID: senderKeyID(c),
+		CollapseKey: collapseKey,
+		NotifyID:    service.NotificationID(messageID),
+	}
+	if collapseKey != "" {
+		opts.NotifyID = service.CollapseNotificationID(h.serverName, opts.SenderKeyID, collapseKey)
+	}
+	return opts
+}
+
+// senderKeyID returns the ID of the sender API key, or "" without one.
+func senderKeyID(c *gin.Context) string {
+	if key := middleware.SenderAPIKey(c); key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+// consumeSenderQuota charges count pushes to the sender API key's daily quota.
+// grantTargetDevice 发送授权只能推送到签发它的设备，省略 device_id 时使用签发设备
+// 持有授权的推送方不知道 device_id，推送、获取公钥和预加密推送都依赖这一回退
+func grantTargetDevice(key *service.APIKey, deviceID string) string {
+	if deviceID == "" && key != nil {
+		return key.OwnerDeviceID
+	}
+	return deviceID
+}
+
+func (h *PushHandler) consumeSenderQuota(c *gin.Context, count int) bool {
+	key := middleware.SenderAPIKey(c)
+	if key == nil {
+		return true
+	}
+
+	err := h.apiKeys.ConsumeQuota(c.Request.Context(), key, count)
+	if errors.Is(err, service.ErrAPIKeyQuotaExceeded) {
+		RespondError(c, http.StatusTooManyRequests, models.QuotaExceeded, err.Error())
+		return false
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to consume quota for API key: %s", key.ID)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to check API key quota")
+		return false
+	}
+	return true
+}
+
 func (h *PushHandler) maybeSendBackgroundSyncSignal(deviceID string, pushToken string) {
 	now := time.Now().UTC()
 	shouldSend, err := h.reserveBackgroundPushWake(deviceID, now)
@@ -175,35 +343,61 @@ func (h *PushHandler) maybeSendBackgroundSyncSignal(deviceID string, pushToken s
 		return
 	}
 
-	payload, err := json.Marshal(backgroundSyncSignal{
-		Type:       "sync_pending",
-		ServerName: h.serverName,
-		CreatedAt:  now.Format(time.RFC3339),
-	})
+	payload, err := h.syncPendingSignal(now)
 	if err != nil {
 		logger.ErrorWithStack(err, "Failed to build background sync signal for device: %s", deviceID)
 		return
 	}
 
-	if err := h.pushService.SendBackgroundMessage(pushToken, string(payload)); err != nil {
+	if err := h.pushService.SendBackgroundMessage(pushToken, payload); err != nil {
 		logger.ErrorWithStack(err, "Failed to send background sync signal for device: %s", deviceID)
 		return
 	}
 	logger.Info("Background sync signal sent for device: %s", deviceID)
 }
 
+// syncPendingSignal 后台唤醒信号：提示 App 拉取待接收消息
+func (h *PushHandler) syncPendingSignal(now time.Time) (string, error) {
+	payload, err := json.Marshal(backgroundSyncSignal{
+		Type:       "sync_pending",
+		ServerName: h.serverName,
+		CreatedAt:  now.Format(time.RFC3339),
+	})
+	return string(payload), err
+}
+
+// SendTokenProof 通过后台消息把Push Token持有证明下发到该Token
+func (h *PushHandler) SendTokenProof(pushToken string, proof string) error {
+	payload, err := json.Marshal(backgroundSyncSignal{
+		Type:       "token_proof",
+		ServerName: h.serverName,
+		Proof:      proof,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	return h.pushService.SendBackgroundMessage(pushToken, string(payload))
+}
+
 func (h *PushHandler) reserveBackgroundPushWake(deviceID string, now time.Time) (bool, error) {
+	reserved, err := h.reserveBackgroundPushWakes([]string{deviceID}, now)
+	return len(reserved) > 0, err
+}
+
+// reserveBackgroundPushWakes 为有待接收消息且不在冷却期内的设备预留一次后台唤醒，返回预留成功的设备
+func (h *PushHandler) reserveBackgroundPushWakes(deviceIDs []string, now time.Time) ([]string, error) {
 	cutoff := backgroundPushWakeCutoff(now)
-	result, err := h.db.DB.Exec(`
+	rows, err := h.db.DB.Query(`
 		UPDATE devices
 		SET last_background_push_attempt_at = $3,
 			updated_at = NOW()
-		WHERE device_id = $1
+		WHERE device_id = ANY($1)
 			AND is_active = TRUE
 			AND EXISTS (
 				SELECT 1
 				FROM pending_messages
-				WHERE device_id = $1
+				WHERE pending_messages.device_id = devices.device_id
 					AND delivered = false
 					AND expires_at > NOW()
 			)
@@ -211,16 +405,22 @@ func (h *PushHandler) reserveBackgroundPushWake(deviceID string, now time.Time)
 				last_background_push_attempt_at IS NULL
 				OR last_background_push_attempt_at <= $2
 			)
-	`, deviceID, cutoff, now)
+		RETURNING device_id
+	`, pq.Array(deviceIDs), cutoff, now)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return false, err
+	var reserved []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, err
+		}
+		reserved = append(reserved, deviceID)
 	}
-	return rowsAffected > 0, nil
+	return reserved, rows.Err()
 }
 
 func backgroundPushWakeCutoff(now time.Time) time.Time {
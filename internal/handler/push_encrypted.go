@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/middleware"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// 预加密消息未提供可见文本时使用的通用通知文案
+const (
+	defaultEncryptedTitle   = "新消息"
+	defaultEncryptedContent = "你收到一条加密消息"
+)
+
+// EncryptedPushRequest 推送方预加密消息
+// Envelope 由推送方用设备公钥加密，服务端原样保存；Title/Content 为通知栏可见文本，可省略。
+// 信封v2的关联数据需要 message_id 和 created_at（UTC毫秒），由推送方生成并随请求提交。
+type EncryptedPushRequest struct {
+	DeviceId    string                   `json:"device_id"` // 使用发送授权时可省略
+	MessageId   string                   `json:"message_id"`
+	CreatedAt   string                   `json:"created_at"`
+	Title       string                   `json:"title"`
+	Content     string                   `json:"content"`
+	Privacy     string                   `json:"privacy"`      // private 时忽略 Title/Content，通知栏只显示占位文案
+	CollapseKey string                   `json:"collapse_key"` // 折叠键：替换设备上同键的通知和未确认的旧消息
+	Envelope    service.EncryptedMessage `json:"envelope"`
+}
+
+// GetDevicePublicKey 向推送方公开设备当前公钥，用于在推送方本地加密
+// GET /api/v1/push/public-key?device_id=xxx（使用发送授权时可省略 device_id）
+func (h *PushHandler) GetDevicePublicKey(c *gin.Context) {
+	deviceId := grantTargetDevice(middleware.SenderAPIKey(c), c.Query("device_id"))
+	if _, err := uuid.Parse(deviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	if !h.authorizeSender(c, deviceId) {
+		return
+	}
+
+	key, err := service.GetCurrentDeviceKey(c.Request.Context(), h.db.DB, deviceId)
+	if errors.Is(err, service.ErrDeviceHasNoKey) {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device public key not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to query public key for device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query public key")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"deviceId":        key.DeviceID,
+		"keyId":           key.KeyID,
+		"publicKey":       key.PublicKey,
+		"fingerprint":     key.Fingerprint,
+		"cryptoVersion":   key.CryptoVersion,
+		"envelopeVersion": service.MessageEnvelopeV2,
+		"serverName":      h.serverName,
+	})
+}
+
+// SendEncrypted 发送推送方预加密的消息（零知识模式），服务端不接触消息明文
+// POST /api/v1/push/encrypted
+func (h *PushHandler) SendEncrypted(c *gin.Context) {
+	var req EncryptedPushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+
+	req.DeviceId = grantTargetDevice(middleware.SenderAPIKey(c), req.DeviceId)
+	deviceUUID, err := uuid.Parse(req.DeviceId)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	if req.CollapseKey != "" {
+		if err := service.ValidateCollapseKey(req.CollapseKey); err != nil {
+			RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+			return
+		}
+	}
+
+	if !h.authorizeSender(c, req.DeviceId) {
+		return
+	}
+
+	privacyLevel, ok := h.resolvePrivacyLevel(c, req.DeviceId, req.Privacy)
+	if !ok {
+		return
+	}
+
+	pushToken, err := h.deviceHandler.GetPushToken(req.DeviceId)
+	if err != nil {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	}
+
+	key, err := service.GetCurrentDeviceKey(c.Request.Context(), h.db.DB, req.DeviceId)
+	if errors.Is(err, service.ErrDeviceHasNoKey) {
+		RespondError(c, http.StatusBadRequest, models.OperationFailed, "Device public key not found, please register device first")
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to query public key for device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query public key")
+		return
+	}
+
+	if err := service.ValidateSenderEnvelope(&req.Envelope, key.KeyID, key.CryptoVersion); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	binding, err := senderMessageBinding(req, deviceUUID, h.serverName, time.Now())
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	if !h.consumeSenderQuota(c, 1) {
+		return
+	}
+
+	// 原样保存推送方的密文
+	opts := h.storedMessageOptions(c, binding.MessageID, req.CollapseKey)
+	superseded, err := h.messageHandler.SaveEncryptedMessage(binding, &req.Envelope, opts)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			RespondError(c, http.StatusConflict, models.DataAlreadyExists, "message_id already exists")
+			return
+		}
+		logger.ErrorWithStack(err, "Failed to save pre-encrypted message for device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to save message: "+err.Error())
+		return
+	}
+
+	title, content := req.Title, req.Content
+	if title == "" && content == "" {
+		title, content = defaultEncryptedTitle, defaultEncryptedContent
+	}
+	notification := storedNotification{
+		MessageID:    binding.MessageID,
+		Title:        title,
+		Content:      content,
+		PrivacyLevel: privacyLevel,
+		NotifyID:     opts.NotifyID,
+	}
+	if err := h.notifyStoredMessage(req.DeviceId, pushToken, notification); err != nil {
+		logger.ErrorWithStack(err, "Failed to send push notification for device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to send notification: "+err.Error())
+		return
+	}
+
+	logger.Info("Successfully sent pre-encrypted message to device: %s", req.DeviceId)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message":       "Notification sent successfully",
+		"message_id":    binding.MessageID,
+		"privacy_level": privacyLevel,
+		"notify_id":     opts.NotifyID,
+		"superseded":    superseded,
+	})
+}
+
+// senderMessageBinding 还原推送方加密时使用的绑定上下文
+// 信封v2必须提供 message_id 和 created_at；v1 没有关联数据，可由服务端生成
+func senderMessageBinding(req EncryptedPushRequest, deviceUUID uuid.UUID, serverName string, now time.Time) (service.MessageBinding, error) {
+	if req.Envelope.EnvelopeVersion == service.MessageEnvelopeV1 && req.MessageId == "" && req.CreatedAt == "" {
+		return service.NewMessageBinding(deviceUUID.String(), uuid.NewString(), serverName, now), nil
+	}
+
+	messageUUID, err := uuid.Parse(req.MessageId)
+	if err != nil {
+		return service.MessageBinding{}, &pushValidationError{message: "Invalid message_id format, expected UUID"}
+	}
+	createdAt, err := service.ParseSenderCreatedAt(req.CreatedAt, now)
+	if err != nil {
+		return service.MessageBinding{}, err
+	}
+	return service.NewMessageBinding(deviceUUID.String(), messageUUID.String(), serverName, createdAt), nil
+}
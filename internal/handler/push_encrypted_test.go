@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestSenderMessageBindingRequiresIDAndTimeForEnvelopeV2(t *testing.T) {
+	deviceUUID := uuid.MustParse("D5E2A0A0-36A8-4D8B-BCB7-469C7F09FC61")
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	req := EncryptedPushRequest{
+		MessageId: "6F1C2B9E-0A4D-4B57-9A8E-3C2D1E0F9B88",
+		CreatedAt: "2026-10-19T00:00:01.500Z",
+		Envelope:  service.EncryptedMessage{EnvelopeVersion: service.MessageEnvelopeV2},
+	}
+	binding, err := senderMessageBinding(req, deviceUUID, "server", now)
+	if err != nil {
+		t.Fatalf("senderMessageBinding returned error: %v", err)
+	}
+	if binding.DeviceID != "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61" ||
+		binding.MessageID != "6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88" ||
+		!binding.CreatedAt.Equal(now.Add(1500*time.Millisecond)) {
+		t.Fatalf("binding = %+v", binding)
+	}
+
+	req.MessageId = ""
+	if _, err := senderMessageBinding(req, deviceUUID, "server", now); err == nil {
+		t.Fatal("envelope v2 without message_id was accepted")
+	}
+}
+
+func TestSenderMessageBindingGeneratesIDForEnvelopeV1(t *testing.T) {
+	req := EncryptedPushRequest{Envelope: service.EncryptedMessage{EnvelopeVersion: service.MessageEnvelopeV1}}
+
+	binding, err := senderMessageBinding(req, uuid.New(), "server", time.Now())
+	if err != nil {
+		t.Fatalf("senderMessageBinding returned error: %v", err)
+	}
+	if _, err := uuid.Parse(binding.MessageID); err != nil {
+		t.Fatalf("generated message id %q is not a UUID", binding.MessageID)
+	}
+}
+
+func TestSendEncryptedRejectsInvalidCollapseKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/encrypted", (&PushHandler{}).SendEncrypted)
+
+	req := httptest.NewRequest(http.MethodPost, "/encrypted", strings.NewReader(`{
+		"device_id": "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61",
+		"collapse_key": "disk usage"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "collapse_key") {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestStoredMessageOptionsSharesNotifyIDForCollapseKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	h := &PushHandler{serverName: "server"}
+
+	first := h.storedMessageOptions(c, uuid.NewString(), "disk")
+	second := h.storedMessageOptions(c, uuid.NewString(), "disk")
+	if first.NotifyID != second.NotifyID || first.CollapseKey != "disk" {
+		t.Fatalf("collapsed messages got %+v and %+v", first, second)
+	}
+
+	plain := h.storedMessageOptions(c, "6f1c2b9e-0a4d-4b57-9a8e-3c2d1b0a9f8e", "")
+	if plain.NotifyID != service.NotificationID("6f1c2b9e-0a4d-4b57-9a8e-3c2d1b0a9f8e") || plain.CollapseKey != "" {
+		t.Fatalf("uncollapsed message got %+v", plain)
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/google/uuid"
+)
+
+// 多设备推送（接收者、分组）中单台设备的投递结果
+const (
+	deliverySent    = "sent"    // 已保存并发送通知
+	deliveryStored  = "stored"  // 已保存，通知发送失败，App 下次拉取时仍可收到
+	deliverySkipped = "skipped" // 设备未上传公钥，无法加密
+	deliveryFailed  = "failed"
+)
+
+// deviceDelivery 多设备推送中单台设备的投递结果
+type deviceDelivery struct {
+	DeviceID     string `json:"device_id"`
+	MessageID    string `json:"message_id,omitempty"`
+	PrivacyLevel string `json:"privacy_level,omitempty"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// fanOutTarget 多设备推送中的一台目标设备
+type fanOutTarget struct {
+	DeviceID     string
+	PublicKey    string
+	KeyID        string
+	PrivacyLevel string // 已与请求的隐私级别合并
+}
+
+// storeFanOutCopy 用目标设备自己的公钥加密并保存一份消息副本
+// 成功时状态为 stored，由调用方发送通知后改为 sent
+func (h *PushHandler) storeFanOutCopy(target fanOutTarget, messageContent service.MessageContent, opts StoredMessageOptions, createdAt time.Time) deviceDelivery {
+	delivery := deviceDelivery{DeviceID: target.DeviceID, PrivacyLevel: target.PrivacyLevel, Status: deliveryFailed}
+	if target.PublicKey == "" {
+		delivery.Status = deliverySkipped
+		delivery.Error = "Device public key not found"
+		return delivery
+	}
+
+	binding := service.NewMessageBinding(target.DeviceID, uuid.NewString(), h.serverName, createdAt)
+	encryptedMsg, err := h.cryptoService.EncryptMessage(target.PublicKey, messageContent, binding)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to encrypt message for device: %s", target.DeviceID)
+		delivery.Error = "Failed to encrypt message"
+		return delivery
+	}
+	encryptedMsg.KeyID = target.KeyID
+
+	if _, err := h.messageHandler.SaveEncryptedMessage(binding, encryptedMsg, opts); err != nil {
+		logger.ErrorWithStack(err, "Failed to save message for device: %s", target.DeviceID)
+		delivery.Error = "Failed to save message"
+		return delivery
+	}
+
+	delivery.MessageID = binding.MessageID
+	delivery.Status = deliveryStored
+	return delivery
+}
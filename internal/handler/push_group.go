@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/middleware"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// groupNotificationBatch 分组推送中通知栏内容相同的一批设备
+type groupNotificationBatch struct {
+	privacyLevel string
+	pushTokens   []string
+	deliveries   []int // 对应 deliveries 中的下标
+}
+
+// SendToGroup 向分组（管理员分配的设备或 App 订阅的主题）的所有成员推送通知
+// GET /api/v1/push/group?group=deploys&title=xxx&content=xxx
+//
+// 每台设备用各自的公钥单独加密保存；通知栏内容不含单条消息ID，按隐私级别分批，
+// 每批最多 1000 个 Push Token 一次发送，后台唤醒信号同样批量发送。
+// 分组名可被猜到，匿名推送方一律拒绝（路由上同样强制要求 API Key）
+func (h *PushHandler) SendToGroup(c *gin.Context) {
+	if middleware.SenderAPIKey(c) == nil {
+		RespondError(c, http.StatusUnauthorized, models.Unauthorized, "API key is required")
+		return
+	}
+
+	var req models.GroupPushRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+
+	if !service.IsValidGroupName(req.Group) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid group name")
+		return
+	}
+
+	if req.Privacy != "" && !service.IsValidPrivacyLevel(req.Privacy) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid privacy, expected standard or private")
+		return
+	}
+
+	dataArray, err := parseNotificationData(req.Data)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	messageURL := extractMessageURL(dataArray)
+	if err := validateMessageURL(messageURL); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	if req.CollapseKey != "" {
+		if err := service.ValidateCollapseKey(req.CollapseKey); err != nil {
+			RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+			return
+		}
+	}
+
+	if !h.apiKeys.AllowsGroup(middleware.SenderAPIKey(c), req.Group) {
+		RespondError(c, http.StatusForbidden, models.PermissionDenied, "API key is not allowed to push to this group")
+		return
+	}
+
+	targets, err := service.ListGroupPushTargets(c.Request.Context(), h.db.DB, req.Group)
+	if errors.Is(err, service.ErrGroupFull) {
+		RespondError(c, http.StatusConflict, models.BusinessError, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list members of group: %s", req.Group)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query group")
+		return
+	}
+	if len(targets) == 0 {
+		RespondError(c, http.StatusNotFound, models.ResourceNotFound, "Group not found or has no active members")
+		return
+	}
+
+	// 额度在保存之前按成员数扣除，之后被跳过或保存失败的成员同样计入
+	if !h.consumeSenderQuota(c, len(targets)) {
+		return
+	}
+
+	messageContent := service.MessageContent{
+		Title:      req.Title,
+		Content:    req.Content,
+		Data:       dataArray,
+		ServerName: h.serverName,
+	}
+	groupMessageID := uuid.NewString()
+	opts := h.storedMessageOptions(c, groupMessageID, req.CollapseKey)
+	now := time.Now()
+
+	// 1. 逐台加密保存，按隐私级别分批
+	deliveries := make([]deviceDelivery, 0, len(targets))
+	batches := map[string]*groupNotificationBatch{}
+	stored := []string{}
+	for _, target := range targets {
+		privacyLevel := service.EffectivePrivacyLevel(target.PrivacyLevel, req.Privacy)
+		delivery := h.storeFanOutCopy(fanOutTarget{
+			DeviceID:     target.DeviceID,
+			PublicKey:    target.PublicKey,
+			KeyID:        target.PublicKeyID,
+			PrivacyLevel: privacyLevel,
+		}, messageContent, opts, now)
+		deliveries = append(deliveries, delivery)
+		if delivery.Status != deliveryStored {
+			continue
+		}
+		stored = append(stored, target.DeviceID)
+
+		pushToken, err := h.deviceHandler.encryption.Decrypt(target.PushToken)
+		if err != nil {
+			logger.ErrorWithStack(err, "Failed to decrypt push token for device: %s", target.DeviceID)
+			deliveries[len(deliveries)-1].Error = "Failed to get push token"
+			continue
+		}
+		batch := batches[privacyLevel]
+		if batch == nil {
+			batch = &groupNotificationBatch{privacyLevel: privacyLevel}
+			batches[privacyLevel] = batch
+		}
+		batch.pushTokens = append(batch.pushTokens, pushToken)
+		batch.deliveries = append(batch.deliveries, len(deliveries)-1)
+	}
+
+	if len(stored) == 0 {
+		logger.Error("Failed to store group message %s for any member of group: %s", groupMessageID, req.Group)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to deliver to any member of the group")
+		return
+	}
+
+	// 2. 批量发送后台唤醒信号（每台设备仍受唤醒冷却限制），失败不影响通知
+	h.sendGroupBackgroundSyncSignal(stored, batches, deliveries, now)
+
+	// 3. 按隐私级别批量发送通知
+	notified := 0
+	for _, batch := range batches {
+		title, content, notificationData := notificationPayload(h.serverName, storedNotification{
+			Title:        req.Title,
+			Content:      req.Content,
+			URL:          messageURL,
+			PrivacyLevel: batch.privacyLevel,
+		})
+		// 同一批设备的消息ID各不相同，App 收到通知后拉取待接收消息
+		delete(notificationData, "message_id")
+		notificationData["group"] = req.Group
+
+		notified += sendGroupNotifications(req.Group, batch, deliveries, func(pushTokens []string) error {
+			return h.pushService.SendBatchNotification(pushTokens, opts.NotifyID, title, content, notificationData)
+		})
+	}
+
+	logger.Info("Sent group message %s to group %s: members=%d, stored=%d, notified=%d", groupMessageID, req.Group, len(targets), len(stored), notified)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message":          "Notification sent successfully",
+		"group":            req.Group,
+		"group_message_id": groupMessageID,
+		"notify_id":        opts.NotifyID,
+		"member_count":     len(targets),
+		"stored_count":     len(stored),
+		"notified_count":   notified,
+		"deliveries":       deliveries,
+	})
+}
+
+// sendGroupNotifications 按每次最多 MaxBatchPushTokens 个Token发送一批设备的通知，
+// 更新各设备的投递结果并返回已发送通知的设备数。部分成功时只有被拒绝的Token对应的设备标记为失败
+func sendGroupNotifications(group string, batch *groupNotificationBatch, deliveries []deviceDelivery, send func(pushTokens []string) error) int {
+	notified := 0
+	for start := 0; start < len(batch.pushTokens); start += service.MaxBatchPushTokens {
+		end := start + service.MaxBatchPushTokens
+		if end > len(batch.pushTokens) {
+			end = len(batch.pushTokens)
+		}
+
+		rejected := map[string]bool{}
+		if err := send(batch.pushTokens[start:end]); err != nil {
+			var partial *service.PartialPushError
+			if !errors.As(err, &partial) {
+				logger.ErrorWithStack(err, "Failed to send batch notification for group: %s", group)
+				for _, index := range batch.deliveries[start:end] {
+					deliveries[index].Error = "Failed to send notification"
+				}
+				continue
+			}
+			for _, token := range partial.IllegalTokens {
+				rejected[token] = true
+			}
+		}
+
+		for i, index := range batch.deliveries[start:end] {
+			if rejected[batch.pushTokens[start+i]] {
+				deliveries[index].Error = "Failed to send notification"
+				continue
+			}
+			deliveries[index].Status = deliverySent
+			notified++
+		}
+	}
+	return notified
+}
+
+// sendGroupBackgroundSyncSignal 为不在唤醒冷却期内的成员批量发送后台唤醒信号
+func (h *PushHandler) sendGroupBackgroundSyncSignal(deviceIDs []string, batches map[string]*groupNotificationBatch, deliveries []deviceDelivery, now time.Time) {
+	reserved, err := h.reserveBackgroundPushWakes(deviceIDs, now.UTC())
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to reserve background push wake for %d devices", len(deviceIDs))
+		return
+	}
+	if len(reserved) == 0 {
+		return
+	}
+
+	wake := make(map[string]bool, len(reserved))
+	for _, deviceID := range reserved {
+		wake[deviceID] = true
+	}
+	var pushTokens []string
+	for _, batch := range batches {
+		for i, index := range batch.deliveries {
+			if wake[deliveries[index].DeviceID] {
+				pushTokens = append(pushTokens, batch.pushTokens[i])
+			}
+		}
+	}
+
+	payload, err := h.syncPendingSignal(now.UTC())
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to build background sync signal for group push")
+		return
+	}
+	for start := 0; start < len(pushTokens); start += service.MaxBatchPushTokens {
+		end := start + service.MaxBatchPushTokens
+		if end > len(pushTokens) {
+			end = len(pushTokens)
+		}
+		var partial *service.PartialPushError
+		if err := h.pushService.SendBatchBackgroundMessage(pushTokens[start:end], payload); errors.As(err, &partial) {
+			logger.Error("Background sync signal rejected for %d of %d devices", len(partial.IllegalTokens), end-start)
+		} else if err != nil {
+			logger.ErrorWithStack(err, "Failed to send batch background sync signal to %d devices", end-start)
+		}
+	}
+}
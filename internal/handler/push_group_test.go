@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dengdeng-harmonyos/server/internal/middleware"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+func testGroupBatch(size int) (*groupNotificationBatch, []deviceDelivery) {
+	batch := &groupNotificationBatch{privacyLevel: service.PrivacyLevelStandard}
+	deliveries := make([]deviceDelivery, size)
+	for i := range deliveries {
+		deliveries[i] = deviceDelivery{DeviceID: fmt.Sprintf("device-%d", i), Status: deliveryStored}
+		batch.pushTokens = append(batch.pushTokens, fmt.Sprintf("token-%d", i))
+		batch.deliveries = append(batch.deliveries, i)
+	}
+	return batch, deliveries
+}
+
+func TestSendGroupNotificationsSplitsIntoPushKitBatches(t *testing.T) {
+	batch, deliveries := testGroupBatch(2*service.MaxBatchPushTokens + 500)
+
+	var sizes []int
+	notified := sendGroupNotifications("deploys", batch, deliveries, func(pushTokens []string) error {
+		sizes = append(sizes, len(pushTokens))
+		return nil
+	})
+
+	if fmt.Sprint(sizes) != fmt.Sprint([]int{service.MaxBatchPushTokens, service.MaxBatchPushTokens, 500}) {
+		t.Fatalf("batch sizes = %v", sizes)
+	}
+	if notified != len(deliveries) {
+		t.Fatalf("notified = %d, want %d", notified, len(deliveries))
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != deliverySent || delivery.Error != "" {
+			t.Fatalf("delivery = %+v, want sent", delivery)
+		}
+	}
+}
+
+func TestSendGroupNotificationsMarksOnlyRejectedTokens(t *testing.T) {
+	batch, deliveries := testGroupBatch(service.MaxBatchPushTokens + 2)
+
+	calls := 0
+	notified := sendGroupNotifications("deploys", batch, deliveries, func(pushTokens []string) error {
+		calls++
+		if calls == 1 {
+			return &service.PartialPushError{Success: len(pushTokens) - 1, Failure: 1, IllegalTokens: []string{"token-3"}}
+		}
+		return errors.New("push failed: code=80200003")
+	})
+
+	if notified != service.MaxBatchPushTokens-1 {
+		t.Fatalf("notified = %d, want %d", notified, service.MaxBatchPushTokens-1)
+	}
+	for i, delivery := range deliveries {
+		failed := i == 3 || i >= service.MaxBatchPushTokens
+		if failed && (delivery.Status != deliveryStored || delivery.Error == "") {
+			t.Fatalf("delivery %d = %+v, want stored with an error", i, delivery)
+		}
+		if !failed && delivery.Status != deliverySent {
+			t.Fatalf("delivery %d = %+v, want sent", i, delivery)
+		}
+	}
+}
+
+func TestSendToGroupRejectsAnonymousSender(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 未开启 SENDER_API_KEY_REQUIRED 时，路由上的鉴权和处理器本身都应拒绝匿名推送
+	for name, handlers := range map[string][]gin.HandlerFunc{
+		"route":   {middleware.SenderAuth(nil, true, service.ScopeBatch), (&PushHandler{}).SendToGroup},
+		"handler": {middleware.SenderAuth(nil, false, service.ScopeBatch), (&PushHandler{}).SendToGroup},
+	} {
+		router := gin.New()
+		router.GET("/group", handlers...)
+
+		req := httptest.NewRequest(http.MethodGet, "/group?group=oncall&title=hi&content=hi", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "API key is required") {
+			t.Fatalf("%s: status = %d, body = %s", name, resp.Code, resp.Body.String())
+		}
+	}
+}
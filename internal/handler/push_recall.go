@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RecallMessage 撤回尚未被设备确认的消息
+// POST /api/v1/push/recall  {"message_id": "xxx"}
+//
+// 删除待接收消息后，发送后台信号通知 App 删除本地副本，并撤回通知栏中的通知；
+// 后两步失败只记录日志，消息已无法再被拉取
+func (h *PushHandler) RecallMessage(c *gin.Context) {
+	var req models.RecallMessageRequest
+	if err := c.ShouldBind(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	messageUUID, err := uuid.Parse(req.MessageID)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid message_id format")
+		return
+	}
+
+	recalled, err := service.RecallPendingMessage(c.Request.Context(), h.db.DB, messageUUID.String(), senderKeyID(c))
+	switch {
+	case errors.Is(err, service.ErrMessageNotRecallable):
+		RespondError(c, http.StatusNotFound, models.ResourceNotFound, err.Error())
+		return
+	case errors.Is(err, service.ErrMessageAlreadyConfirmed):
+		RespondError(c, http.StatusConflict, models.BusinessError, err.Error())
+		return
+	case errors.Is(err, service.ErrMessageRecallForbidden):
+		RespondError(c, http.StatusForbidden, models.PermissionDenied, err.Error())
+		return
+	case err != nil:
+		logger.ErrorWithStack(err, "Failed to recall message: %s", req.MessageID)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to recall message")
+		return
+	}
+
+	signalSent, notificationRevoked := false, false
+	pushToken, err := h.deviceHandler.GetPushToken(recalled.DeviceID)
+	if err != nil {
+		logger.Error("Recalled message %s but device %s has no push token: %v", recalled.MessageID, recalled.DeviceID, err)
+	} else {
+		signalSent = h.sendMessageSignal("recall", recalled.MessageID, pushToken)
+		if err := h.pushService.RevokeNotification(pushToken, recalled.NotifyID); err != nil {
+			logger.Error("Failed to revoke notification for message %s: %v", recalled.MessageID, err)
+		} else {
+			notificationRevoked = true
+		}
+	}
+
+	logger.Info("Recalled message %s for device: %s", recalled.MessageID, recalled.DeviceID)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message_id":           recalled.MessageID,
+		"recalled":             true,
+		"signal_sent":          signalSent,
+		"notification_revoked": notificationRevoked,
+	})
+}
+
+// sendMessageSignal 发送后台消息让 App 删除已拉取的本地副本，不受唤醒冷却限制
+// signalType 为 recall（已撤回）或 confirmed（已在同一接收者的其它设备上确认）
+func (h *PushHandler) sendMessageSignal(signalType string, messageID string, pushToken string) bool {
+	payload, err := json.Marshal(backgroundSyncSignal{
+		Type:       signalType,
+		ServerName: h.serverName,
+		MessageID:  messageID,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to build %s signal for message: %s", signalType, messageID)
+		return false
+	}
+	if err := h.pushService.SendBackgroundMessage(pushToken, string(payload)); err != nil {
+		logger.Error("Failed to send %s signal for message %s: %v", signalType, messageID, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecallMessageValidatesMessageID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/recall", (&PushHandler{}).RecallMessage)
+
+	cases := map[string]string{
+		`{}`:                        "Invalid request",
+		`{"message_id":"not-uuid"}`: "Invalid message_id format",
+	}
+	for body, want := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/recall", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, body = %s", body, resp.Code, resp.Body.String())
+		}
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Fatalf("%s: unexpected body %s", body, resp.Body.String())
+		}
+	}
+}
+
+func TestMessageStatusValidatesMessageID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/status", (&PushHandler{}).MessageStatus)
+
+	req := httptest.NewRequest(http.MethodGet, "/status?message_id=nope", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "Invalid message_id format") {
+		t.Fatalf("status = %d, body = %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SendToRecipient 向接收者的所有设备推送同一条通知
+// GET /api/v1/push/recipient?recipient_id=xxx&title=xxx&content=xxx
+//
+// 每台设备用各自的公钥单独加密保存，各副本共用 recipient_message_id 和通知ID；
+// 任一设备确认后，其它设备上的副本被一并确认，通知也会被清除
+func (h *PushHandler) SendToRecipient(c *gin.Context) {
+	var req models.RecipientPushRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+
+	recipientUUID, err := uuid.Parse(req.RecipientId)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid recipient_id format")
+		return
+	}
+
+	if req.Privacy != "" && !service.IsValidPrivacyLevel(req.Privacy) {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid privacy, expected standard or private")
+		return
+	}
+
+	dataArray, err := parseNotificationData(req.Data)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	messageURL := extractMessageURL(dataArray)
+	if err := validateMessageURL(messageURL); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	}
+
+	if req.CollapseKey != "" {
+		if err := service.ValidateCollapseKey(req.CollapseKey); err != nil {
+			RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+			return
+		}
+	}
+
+	devices, err := service.ListRecipientDevices(c.Request.Context(), h.db.DB, recipientUUID.String())
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list devices of recipient: %s", req.RecipientId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query recipient")
+		return
+	}
+	if len(devices) == 0 {
+		RespondError(c, http.StatusNotFound, models.ResourceNotFound, "Recipient not found or has no active devices")
+		return
+	}
+
+	deviceIDs := make([]string, 0, len(devices))
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.DeviceID)
+	}
+	if !h.authorizeSender(c, deviceIDs...) {
+		return
+	}
+	// 额度在保存之前按接收者的设备数扣除，之后被跳过或保存失败的设备同样计入
+	if !h.consumeSenderQuota(c, len(deviceIDs)) {
+		return
+	}
+
+	messageContent := service.MessageContent{
+		Title:      req.Title,
+		Content:    req.Content,
+		Data:       dataArray,
+		ServerName: h.serverName,
+	}
+	recipientMessageID := uuid.NewString()
+	opts := h.storedMessageOptions(c, recipientMessageID, req.CollapseKey)
+	opts.RecipientMessageID = recipientMessageID
+	createdAt := time.Now()
+
+	deliveries := make([]deviceDelivery, 0, len(deviceIDs))
+	delivered := 0
+	for _, deviceID := range deviceIDs {
+		delivery := h.deliverToRecipientDevice(deviceID, req, messageContent, messageURL, opts, createdAt)
+		if delivery.Status == deliverySent || delivery.Status == deliveryStored {
+			delivered++
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if delivered == 0 {
+		logger.Error("Failed to deliver recipient message %s to any device of recipient: %s", recipientMessageID, req.RecipientId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to deliver to any device of the recipient")
+		return
+	}
+
+	logger.Info("Sent recipient message %s to %d/%d devices of recipient: %s", recipientMessageID, delivered, len(deviceIDs), req.RecipientId)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message":              "Notification sent successfully",
+		"recipient_message_id": recipientMessageID,
+		"notify_id":            opts.NotifyID,
+		"delivered_count":      delivered,
+		"deliveries":           deliveries,
+	})
+}
+
+// deliverToRecipientDevice 用设备自己的公钥加密、保存并通知一台设备
+// 失败只影响这台设备，不中断其它设备的投递
+func (h *PushHandler) deliverToRecipientDevice(
+	deviceID string,
+	req models.RecipientPushRequest,
+	messageContent service.MessageContent,
+	messageURL string,
+	opts StoredMessageOptions,
+	createdAt time.Time,
+) deviceDelivery {
+	delivery := deviceDelivery{DeviceID: deviceID, Status: deliveryFailed}
+
+	deviceLevel, err := h.deviceHandler.GetPrivacyLevel(deviceID)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to query privacy level for device: %s", deviceID)
+		delivery.Error = "Failed to query device"
+		return delivery
+	}
+	delivery.PrivacyLevel = service.EffectivePrivacyLevel(deviceLevel, req.Privacy)
+
+	pushToken, err := h.deviceHandler.GetPushToken(deviceID)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to get push token for device: %s", deviceID)
+		delivery.Error = "Failed to get push token"
+		return delivery
+	}
+
+	publicKey, keyID, _ := h.deviceHandler.GetPublicKey(deviceID)
+
+	delivery = h.storeFanOutCopy(fanOutTarget{
+		DeviceID:     deviceID,
+		PublicKey:    publicKey,
+		KeyID:        keyID,
+		PrivacyLevel: delivery.PrivacyLevel,
+	}, messageContent, opts, createdAt)
+	if delivery.Status != deliveryStored {
+		return delivery
+	}
+
+	notification := storedNotification{
+		MessageID:    delivery.MessageID,
+		Title:        req.Title,
+		Content:      req.Content,
+		URL:          messageURL,
+		PrivacyLevel: delivery.PrivacyLevel,
+		NotifyID:     opts.NotifyID,
+	}
+	if err := h.notifyStoredMessage(deviceID, pushToken, notification); err != nil {
+		logger.ErrorWithStack(err, "Failed to send push notification for device: %s", deviceID)
+		delivery.Error = "Failed to send notification"
+		return delivery
+	}
+
+	delivery.Status = deliverySent
+	return delivery
+}
+
+// SyncConfirmed 让同一接收者的其它设备删除已在另一台设备上确认的消息并撤回其通知
+// 失败只记录日志：副本已被确认，不会再被拉取
+func (h *PushHandler) SyncConfirmed(siblings []service.ConfirmedSibling) {
+	for _, sibling := range siblings {
+		pushToken, err := h.deviceHandler.GetPushToken(sibling.DeviceID)
+		if err != nil {
+			logger.Error("Confirmed copy %s but device %s has no push token: %v", sibling.MessageID, sibling.DeviceID, err)
+			continue
+		}
+		h.sendMessageSignal("confirmed", sibling.MessageID, pushToken)
+		if err := h.pushService.RevokeNotification(pushToken, sibling.NotifyID); err != nil {
+			logger.Error("Failed to revoke notification for confirmed copy %s: %v", sibling.MessageID, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// MessageStatus 查询消息投递状态：pending、fetched、confirmed 或 expired
+// GET /api/v1/push/status?message_id=xxx
+//
+// 已确认的消息在保留期（CONFIRMED_MESSAGE_RETENTION_HOURS）内可查询，之后返回404
+func (h *PushHandler) MessageStatus(c *gin.Context) {
+	messageUUID, err := uuid.Parse(c.Query("message_id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid message_id format")
+		return
+	}
+
+	status, err := service.GetMessageStatus(c.Request.Context(), h.db.DB, messageUUID.String(), senderKeyID(c))
+	if errors.Is(err, service.ErrMessageStatusNotFound) {
+		RespondError(c, http.StatusNotFound, models.ResourceNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to query status of message: %s", messageUUID)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query message status")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, status)
+}
@@ -94,3 +94,33 @@ func TestBackgroundPushWakeCutoffUsesThirtyMinuteCooldown(t *testing.T) {
 		t.Fatalf("backgroundPushWakeCutoff = %s, want %s", got, want)
 	}
 }
+
+func TestNotificationPayloadPrivateHidesContentAndURL(t *testing.T) {
+	notification := storedNotification{
+		MessageID:    "2f0c5c1e-7a52-4d3c-9a54-0d7e5b7a9c11",
+		Title:        "验证码",
+		Content:      "你的验证码是 123456",
+		URL:          "https://example.com/secret",
+		PrivacyLevel: "private",
+	}
+
+	title, content, data := notificationPayload("测试服务器", notification)
+	if title == notification.Title || strings.Contains(content, "123456") {
+		t.Fatalf("private notification leaked content: %q, %q", title, content)
+	}
+	if !strings.Contains(content, "测试服务器") {
+		t.Fatalf("private notification should name the server: %q", content)
+	}
+	if _, ok := data["__url"]; ok {
+		t.Fatalf("private notification should not carry __url: %v", data)
+	}
+	if data["message_id"] != notification.MessageID {
+		t.Fatalf("message_id = %v, want %s", data["message_id"], notification.MessageID)
+	}
+
+	notification.PrivacyLevel = "standard"
+	title, content, data = notificationPayload("测试服务器", notification)
+	if title != notification.Title || content != notification.Content || data["__url"] != notification.URL {
+		t.Fatalf("standard notification = %q, %q, %v", title, content, data)
+	}
+}
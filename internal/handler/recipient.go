@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PairingCodeRequest 已有设备申请配对码
+type PairingCodeRequest struct {
+	DeviceId string `json:"device_id" binding:"required"`
+}
+
+// PairDeviceRequest 新设备用配对码加入接收者
+type PairDeviceRequest struct {
+	DeviceId string `json:"device_id" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// CreatePairingCode 为设备所属的接收者生成一次性配对码，设备尚未属于接收者时自动创建
+// POST /api/v1/device/pairing-code
+func (h *DeviceHandler) CreatePairingCode(c *gin.Context) {
+	var req PairingCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	code, err := service.CreatePairingCode(c.Request.Context(), h.db.DB, req.DeviceId)
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to create pairing code for device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to create pairing code")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, code)
+}
+
+// PairDevice 用另一台设备生成的配对码加入其接收者，之后发给该接收者的消息也会推送到本设备
+// POST /api/v1/device/pair
+func (h *DeviceHandler) PairDevice(c *gin.Context) {
+	var req PairDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	if _, err := uuid.Parse(req.DeviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	recipientID, err := service.JoinRecipient(c.Request.Context(), h.db.DB, req.DeviceId, req.Code, auditActor(c, req.DeviceId))
+	switch {
+	case errors.Is(err, service.ErrDeviceNotFound):
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	case errors.Is(err, service.ErrPairingCodeInvalid):
+		RespondError(c, http.StatusForbidden, models.PermissionDenied, err.Error())
+		return
+	case errors.Is(err, service.ErrRecipientFull):
+		RespondError(c, http.StatusConflict, models.BusinessError, err.Error())
+		return
+	case err != nil:
+		logger.ErrorWithStack(err, "Failed to pair device: %s", req.DeviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to pair device")
+		return
+	}
+
+	h.respondRecipient(c, req.DeviceId, recipientID)
+}
+
+// GetRecipient 查询设备所属的接收者及其所有设备
+// GET /api/v1/device/recipient?device_id=xxx
+func (h *DeviceHandler) GetRecipient(c *gin.Context) {
+	deviceId := c.Query("device_id")
+	if _, err := uuid.Parse(deviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	recipientID, err := service.GetDeviceRecipient(c.Request.Context(), h.db.DB, deviceId)
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to query recipient of device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query recipient")
+		return
+	}
+
+	h.respondRecipient(c, deviceId, recipientID)
+}
+
+// LeaveRecipient 设备退出所属的接收者，最后一台设备退出时接收者被删除
+// DELETE /api/v1/device/recipient?device_id=xxx
+func (h *DeviceHandler) LeaveRecipient(c *gin.Context) {
+	deviceId := c.Query("device_id")
+	if _, err := uuid.Parse(deviceId); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+
+	recipientID, err := service.LeaveRecipient(c.Request.Context(), h.db.DB, deviceId, auditActor(c, deviceId))
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to leave recipient for device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to leave recipient")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"left":        recipientID != "",
+		"recipientId": recipientID,
+	})
+}
+
+func (h *DeviceHandler) respondRecipient(c *gin.Context, deviceId string, recipientID string) {
+	devices := []service.RecipientDevice{}
+	if recipientID != "" {
+		var err error
+		devices, err = service.ListRecipientDevices(c.Request.Context(), h.db.DB, recipientID)
+		if err != nil {
+			logger.ErrorWithStack(err, "Failed to list devices of recipient: %s", recipientID)
+			RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to query recipient")
+			return
+		}
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"deviceId":    deviceId,
+		"recipientId": recipientID,
+		"devices":     devices,
+		"maxDevices":  service.MaxRecipientDevices,
+	})
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CreateSendGrantRequest 设备签发发送授权
+type CreateSendGrantRequest struct {
+	DeviceId string `json:"device_id" binding:"required"`
+	service.SendGrantSpec
+}
+
+// CreateSendGrant 设备为某个推送方（如 Grafana、同事）签发只能推送到本设备的 API Key
+// POST /api/v1/device/grants
+//
+// 原始 Key 和分享链接只在此时返回一次，App 可将分享链接展示为二维码
+func (h *DeviceHandler) CreateSendGrant(c *gin.Context) {
+	var req CreateSendGrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid request: "+err.Error())
+		return
+	}
+	deviceUUID, err := uuid.Parse(req.DeviceId)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+	deviceId := deviceUUID.String()
+
+	grant, rawKey, err := service.CreateSendGrant(c.Request.Context(), h.db.DB, deviceId, req.SendGrantSpec, auditActor(c, deviceId))
+	switch {
+	case errors.Is(err, service.ErrInvalidAPIKeySpec):
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, err.Error())
+		return
+	case errors.Is(err, service.ErrDeviceNotFound):
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Device not found")
+		return
+	case errors.Is(err, service.ErrTooManySendGrants):
+		RespondError(c, http.StatusConflict, models.BusinessError, err.Error())
+		return
+	case err != nil:
+		logger.ErrorWithStack(err, "Failed to create send grant for device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to create send grant")
+		return
+	}
+
+	logger.Info("Send grant created: id=%s, device=%s", grant.ID, deviceId)
+	response := gin.H{
+		"grant": grant,
+		"key":   rawKey,
+	}
+	if shareURL := h.sendGrantShareURL(rawKey); shareURL != "" {
+		response["shareUrl"] = shareURL
+	}
+	RespondSuccess(c, http.StatusCreated, response)
+}
+
+// ListSendGrants 列出设备签发的发送授权，包括已吊销和已过期的
+// GET /api/v1/device/grants?device_id=xxx
+func (h *DeviceHandler) ListSendGrants(c *gin.Context) {
+	deviceUUID, err := uuid.Parse(c.Query("device_id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+	deviceId := deviceUUID.String()
+
+	grants, err := service.ListSendGrants(c.Request.Context(), h.db.DB, deviceId)
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to list send grants of device: %s", deviceId)
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to list send grants")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"deviceId":  deviceId,
+		"grants":    grants,
+		"maxGrants": service.MaxDeviceSendGrants,
+	})
+}
+
+// RevokeSendGrant 吊销设备签发的发送授权，之后该 Key 不能再推送
+// DELETE /api/v1/device/grants/:id?device_id=xxx
+func (h *DeviceHandler) RevokeSendGrant(c *gin.Context) {
+	deviceUUID, err := uuid.Parse(c.Query("device_id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid device_id format")
+		return
+	}
+	deviceId := deviceUUID.String()
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		RespondError(c, http.StatusBadRequest, models.InvalidParams, "Invalid grant id format")
+		return
+	}
+
+	err = service.RevokeSendGrant(c.Request.Context(), h.db.DB, deviceId, id, auditActor(c, deviceId))
+	if errors.Is(err, service.ErrAPIKeyNotFound) {
+		RespondError(c, http.StatusNotFound, models.DataNotFound, "Send grant not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err, "Failed to revoke send grant %s of device: %s", id, deviceId)
+		RespondError(c, http.StatusInternalServerError, models.OperationFailed, "Failed to revoke send grant")
+		return
+	}
+
+	logger.Info("Send grant revoked: id=%s, device=%s", id, deviceId)
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"message": "Send grant revoked successfully",
+	})
+}
+
+// sendGrantShareURL 生成携带授权 Key 的推送链接，推送方只需追加 title 和 content
+// 只使用 SERVER_PUBLIC_URL：请求的 Host 和协议可被客户端或代理改写，据此生成的链接会把 Key 发往错误的地址；
+// 未配置时返回空字符串，不生成分享链接
+func (h *DeviceHandler) sendGrantShareURL(rawKey string) string {
+	base := strings.TrimRight(h.publicURL, "/")
+	if base == "" {
+		return ""
+	}
+	return base + "/api/v1/push/notification?key=" + url.QueryEscape(rawKey)
+}
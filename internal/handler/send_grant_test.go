@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/dengdeng-harmonyos/server/internal/service"
+)
+
+func TestSendGrantShareURLRequiresPublicURL(t *testing.T) {
+	cases := []struct {
+		publicURL, want string
+	}{
+		{"", ""},
+		{"https://push.example.com/", "https://push.example.com/api/v1/push/notification?key=ddk_a%2Bb"},
+	}
+	for _, tc := range cases {
+		got := (&DeviceHandler{publicURL: tc.publicURL}).sendGrantShareURL("ddk_a+b")
+		if got != tc.want {
+			t.Fatalf("share url with public url %q = %q, want %q", tc.publicURL, got, tc.want)
+		}
+	}
+}
+
+func TestGrantTargetDeviceFallsBackToOwner(t *testing.T) {
+	const owner = "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61"
+	grant := &service.APIKey{OwnerDeviceID: owner, AllowedDeviceIDs: []string{owner}}
+
+	cases := []struct {
+		name     string
+		key      *service.APIKey
+		deviceID string
+		want     string
+	}{
+		{"grant without device_id", grant, "", owner},
+		// 显式指定的 device_id 保持不变，由 authorizeSender 拒绝其它设备
+		{"grant with device_id", grant, "6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88", "6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88"},
+		{"operator key", &service.APIKey{}, "", ""},
+		{"anonymous", nil, "", ""},
+	}
+	for _, tc := range cases {
+		if got := grantTargetDevice(tc.key, tc.deviceID); got != tc.want {
+			t.Fatalf("%s: grantTargetDevice = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ServerKeyHandler 公开服务端消息签名公钥
+type ServerKeyHandler struct {
+	signer *service.MessageSigner
+}
+
+func NewServerKeyHandler(signer *service.MessageSigner) *ServerKeyHandler {
+	return &ServerKeyHandler{signer: signer}
+}
+
+// SigningKey 返回服务端Ed25519签名公钥，App 首次连接时保存并用于验证消息签名
+// GET /api/v1/server/signing-key
+func (h *ServerKeyHandler) SigningKey(c *gin.Context) {
+	publicKeyPEM, err := h.signer.PublicKeyPEM()
+	if err != nil {
+		RespondError(c, http.StatusInternalServerError, models.SystemError, "Failed to encode signing key")
+		return
+	}
+
+	RespondSuccess(c, http.StatusOK, gin.H{
+		"algorithm":    "Ed25519",
+		"keyId":        h.signer.KeyID(),
+		"publicKey":    base64.StdEncoding.EncodeToString(h.signer.PublicKey()),
+		"publicKeyPem": publicKeyPEM,
+	})
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/dengdeng-harmonyos/server/internal/models"
+	"github.com/dengdeng-harmonyos/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	senderAPIKeyContextKey = "sender_api_key"
+	adminContextKey        = "admin_authenticated"
+)
+
+// SenderAuth 校验推送接口的推送方API Key
+// required 为 false 时，不携带Key的请求保持原有只凭 device_id 推送的行为，但携带的Key仍必须有效
+func SenderAuth(keys *service.APIKeyService, required bool, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawKey := extractAPIKey(c)
+		if rawKey == "" {
+			if required {
+				abortWithError(c, http.StatusUnauthorized, models.Unauthorized, "API key is required")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		key, err := keys.Authenticate(c.Request.Context(), rawKey, scope, c.ClientIP())
+		if err != nil {
+			status, code := apiKeyErrorStatus(err)
+			if status == http.StatusInternalServerError {
+				logger.ErrorWithStack(err, "Failed to authenticate sender API key")
+				abortWithError(c, status, code, "Failed to authenticate API key")
+				return
+			}
+			abortWithError(c, status, code, err.Error())
+			return
+		}
+
+		c.Set(senderAPIKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// SenderAPIKey 返回已校验的推送方Key，匿名请求返回 nil
+func SenderAPIKey(c *gin.Context) *service.APIKey {
+	value, ok := c.Get(senderAPIKeyContextKey)
+	if !ok {
+		return nil
+	}
+	key, _ := value.(*service.APIKey)
+	return key
+}
+
+// AdminAuth 用固定的 Bearer Token 保护管理接口，未配置 Token 时管理接口整体关闭
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			abortWithError(c, http.StatusForbidden, models.PermissionDenied, "Admin API is disabled")
+			return
+		}
+
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			abortWithError(c, http.StatusUnauthorized, models.Unauthorized, "Invalid admin token")
+			return
+		}
+
+		c.Set(adminContextKey, true)
+		c.Next()
+	}
+}
+
+// IsAdmin 请求是否通过了 AdminAuth
+func IsAdmin(c *gin.Context) bool {
+	return c.GetBool(adminContextKey)
+}
+
+func extractAPIKey(c *gin.Context) string {
+	if key := strings.TrimSpace(c.GetHeader("X-API-Key")); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	// 兼容直接拼接在 GET 链接中的调用方式
+	return strings.TrimSpace(c.Query("key"))
+}
+
+func apiKeyErrorStatus(err error) (int, int) {
+	switch {
+	case errors.Is(err, service.ErrAPIKeyInvalid), errors.Is(err, service.ErrAPIKeyRevoked), errors.Is(err, service.ErrAPIKeyExpired):
+		return http.StatusUnauthorized, models.Unauthorized
+	case errors.Is(err, service.ErrAPIKeyScope):
+		return http.StatusForbidden, models.PermissionDenied
+	case errors.Is(err, service.ErrAPIKeyRateLimited):
+		return http.StatusTooManyRequests, models.RateLimited
+	case errors.Is(err, service.ErrAPIKeyQuotaExceeded):
+		return http.StatusTooManyRequests, models.QuotaExceeded
+	default:
+		return http.StatusInternalServerError, models.SystemError
+	}
+}
+
+func abortWithError(c *gin.Context, httpStatus int, errorCode int, message string) {
+	c.AbortWithStatusJSON(httpStatus, models.UnifiedApiResponse{
+		Code: errorCode,
+		Msg:  message,
+	})
+}
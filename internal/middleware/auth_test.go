@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuthRejectsWhenDisabledOrWrongToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		token  string
+		header string
+		want   int
+	}{
+		{token: "", header: "Bearer anything", want: http.StatusForbidden},
+		{token: "secret", header: "Bearer wrong", want: http.StatusUnauthorized},
+		{token: "secret", header: "secret", want: http.StatusUnauthorized},
+		{token: "secret", header: "Basic secret", want: http.StatusUnauthorized},
+		{token: "secret", header: "Bearer secret", want: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		router := gin.New()
+		router.GET("/admin", AdminAuth(tc.token), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.Header.Set("Authorization", tc.header)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != tc.want {
+			t.Fatalf("token=%q header=%q status = %d, want %d", tc.token, tc.header, resp.Code, tc.want)
+		}
+	}
+}
+
+func TestSenderAuthRequiresKeyOnlyWhenConfigured(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, required := range []bool{false, true} {
+		router := gin.New()
+		router.GET("/push", SenderAuth(nil, required, "notification"), func(c *gin.Context) {
+			if SenderAPIKey(c) != nil {
+				t.Fatal("anonymous request must not carry an API key")
+			}
+			c.Status(http.StatusOK)
+		})
+
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/push", nil))
+
+		want := http.StatusOK
+		if required {
+			want = http.StatusUnauthorized
+		}
+		if resp.Code != want {
+			t.Fatalf("required=%v status = %d, want %d", required, resp.Code, want)
+		}
+	}
+}
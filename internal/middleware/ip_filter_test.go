@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dengdeng-harmonyos/server/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestIPAccessListDenyWinsOverAllow(t *testing.T) {
+	list, err := NewIPAccessList("sender", config.IPAccessRule{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+		Deny:  []string{"10.0.0.13"},
+	})
+	if err != nil {
+		t.Fatalf("NewIPAccessList: %v", err)
+	}
+
+	cases := map[string]bool{
+		"10.1.2.3":    true,
+		"10.0.0.13":   false,
+		"192.168.1.1": false,
+		"2001:db8::1": true,
+	}
+	for ip, want := range cases {
+		if got := list.Allows(net.ParseIP(ip)); got != want {
+			t.Fatalf("Allows(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestNewIPAccessListRejectsInvalidEntries(t *testing.T) {
+	if _, err := NewIPAccessList("admin", config.IPAccessRule{Allow: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("expected invalid CIDR to be rejected")
+	}
+	if _, err := NewIPAccessList("admin", config.IPAccessRule{Deny: []string{"example.com"}}); err == nil {
+		t.Fatal("expected invalid IP to be rejected")
+	}
+}
+
+func TestIPFilterUsesForwardedIPOnlyFromTrustedProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	list, err := NewIPAccessList("admin", config.IPAccessRule{Allow: []string{"203.0.113.0/24"}})
+	if err != nil {
+		t.Fatalf("NewIPAccessList: %v", err)
+	}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"172.16.0.0/12"}); err != nil {
+		t.Fatalf("SetTrustedProxies: %v", err)
+	}
+	router.GET("/admin", IPFilter(list), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{remoteAddr: "172.17.0.1:40000", want: http.StatusOK},
+		{remoteAddr: "198.51.100.7:40000", want: http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = tc.remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if resp.Code != tc.want {
+			t.Fatalf("remote %s: status = %d, want %d", tc.remoteAddr, resp.Code, tc.want)
+		}
+	}
+
+	if got := list.Stats().Denied; got != 1 {
+		t.Fatalf("denied = %d, want 1", got)
+	}
+}
@@ -1,21 +1,79 @@
 package middleware
 
 import (
+	"net/http"
+	"strings"
 	"time"
 
+	"github.com/dengdeng-harmonyos/server/internal/config"
 	"github.com/gin-gonic/gin"
 )
 
-// CORS 中间件
-func CORS() gin.HandlerFunc {
+// corsPreflightMaxAge 浏览器缓存预检结果的时间（秒）
+const corsPreflightMaxAge = "600"
+
+// CORS 按路由组的跨域策略处理请求
+// 策略关闭时不输出任何跨域头；开启时只回显允许的Origin并设置 Vary: Origin。
+// 预检请求需要路由组注册 OPTIONS 路由（见 Preflight），否则不会进入中间件。
+func CORS(policy config.CORSPolicy) gin.HandlerFunc {
+	if !policy.Enabled() {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	allowAny := false
+	origins := make(map[string]bool, len(policy.AllowedOrigins))
+	for _, origin := range policy.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
+			continue
+		}
+		origins[normalizeOrigin(origin)] = true
+	}
+	methods := strings.Join(policy.AllowedMethods, ", ")
+	headers := strings.Join(policy.AllowedHeaders, ", ")
+
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		listed := origins[normalizeOrigin(origin)]
+		if !listed && !allowAny {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if listed {
+			header.Set("Access-Control-Allow-Origin", origin)
+			if policy.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if preflight {
+			header.Set("Access-Control-Allow-Methods", methods)
+			header.Set("Access-Control-Allow-Headers", headers)
+			header.Set("Access-Control-Max-Age", corsPreflightMaxAge)
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
@@ -23,6 +81,16 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+// Preflight 路由组的 OPTIONS 兜底处理，实际响应由 CORS 中间件完成
+func Preflight(c *gin.Context) {
+	c.Status(http.StatusNoContent)
+}
+
+// normalizeOrigin Origin 比较不区分大小写，忽略末尾斜杠
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimRight(origin, "/"))
+}
+
 // Logger 中间件
 func Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
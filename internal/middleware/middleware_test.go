@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dengdeng-harmonyos/server/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func newCORSRouter(policy config.CORSPolicy) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	group := router.Group("/api", CORS(policy))
+	group.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	if policy.Enabled() {
+		group.OPTIONS("/*path", Preflight)
+	}
+	return router
+}
+
+func TestCORSAllowlistEchoesListedOriginOnly(t *testing.T) {
+	router := newCORSRouter(config.CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+	})
+
+	cases := []struct {
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{origin: "https://app.example.com", wantOrigin: "https://app.example.com", wantCredentials: "true"},
+		{origin: "https://evil.example.com", wantOrigin: "", wantCredentials: ""},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/ping", nil)
+		req.Header.Set("Origin", tc.origin)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if got := resp.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+			t.Fatalf("origin %s: Allow-Origin = %q, want %q", tc.origin, got, tc.wantOrigin)
+		}
+		if got := resp.Header().Get("Access-Control-Allow-Credentials"); got != tc.wantCredentials {
+			t.Fatalf("origin %s: Allow-Credentials = %q, want %q", tc.origin, got, tc.wantCredentials)
+		}
+		if got := resp.Header().Get("Vary"); got != "Origin" {
+			t.Fatalf("origin %s: Vary = %q, want Origin", tc.origin, got)
+		}
+	}
+}
+
+func TestCORSWildcardNeverSendsCredentials(t *testing.T) {
+	router := newCORSRouter(config.CORSPolicy{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "X-API-Key"},
+		AllowCredentials: true,
+	})
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/ping", nil)
+	req.Header.Set("Origin", "https://any.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", resp.Code)
+	}
+	if got := resp.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("Allow-Origin = %q, want *", got)
+	}
+	if got := resp.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Fatalf("Allow-Credentials = %q, want empty", got)
+	}
+	if got := resp.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, X-API-Key" {
+		t.Fatalf("Allow-Headers = %q", got)
+	}
+}
+
+func TestCORSDisabledSendsNoHeaders(t *testing.T) {
+	router := newCORSRouter(config.CORSPolicy{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/ping", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.Code)
+	}
+	for _, name := range []string{"Access-Control-Allow-Origin", "Vary"} {
+		if got := resp.Header().Get(name); got != "" {
+			t.Fatalf("%s = %q, want empty", name, got)
+		}
+	}
+}
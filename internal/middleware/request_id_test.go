@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIDKeepsValidHeaderAndReplacesInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, GetRequestID(c)) })
+
+	cases := []struct {
+		header string
+		keep   bool
+	}{
+		{header: "nginx-7f3a2c.1", keep: true},
+		{header: "bad id\nInjected: 1", keep: false},
+		{header: "", keep: false},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			req.Header.Set("X-Request-ID", tc.header)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		got := resp.Header().Get("X-Request-ID")
+		if got == "" || got != resp.Body.String() {
+			t.Fatalf("header %q: response ID %q, body %q", tc.header, got, resp.Body.String())
+		}
+		if (got == tc.header) != tc.keep {
+			t.Fatalf("header %q: got %q, keep = %v", tc.header, got, tc.keep)
+		}
+	}
+}
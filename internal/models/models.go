@@ -57,10 +57,12 @@ type PushStatistics struct {
 // DeviceRegisterRequest 设备注册请求
 type DeviceRegisterRequest struct {
 	PushToken  string `json:"push_token" binding:"required"`
-	PublicKey  string `json:"public_key"` // RSA公钥(PEM格式)
+	PublicKey  string `json:"public_key"` // 公钥(PEM格式，PKIX)：RSA至少2048位，或X25519
 	DeviceType string `json:"device_type"`
 	OSVersion  string `json:"os_version"`
 	AppVersion string `json:"app_version"`
+	// PrivacyLevel 通知隐私级别：standard 或 private，为空时保持原设置（新设备为 standard）
+	PrivacyLevel string `json:"privacy_level"`
 }
 
 // DeviceRegisterResponse 设备注册响应
@@ -68,15 +70,46 @@ type DeviceRegisterResponse struct {
 	Success    bool   `json:"success"`
 	DeviceId   string `json:"device_id"`
 	ServerName string `json:"server_name"` // 服务器名称
-	Message    string `json:"message"`
+	// PublicKeyID 当前公钥ID，PublicKeyFingerprint 公钥指纹（SHA256:base64），未上传公钥时为空
+	PublicKeyID          string `json:"public_key_id"`
+	PublicKeyFingerprint string `json:"public_key_fingerprint"`
+	CryptoVersion        int    `json:"crypto_version"` // 该设备使用的消息加密方案版本
+	Message              string `json:"message"`
 }
 
 // PushNotificationRequest 通知消息推送请求（GET参数）
 type PushNotificationRequest struct {
-	DeviceId string `form:"device_id" binding:"required"`
-	Title    string `form:"title" binding:"required"`
-	Content  string `form:"content" binding:"required"`
-	Data     string `form:"data"` // JSON字符串
+	DeviceId    string `form:"device_id"` // 使用设备签发的发送授权时可省略
+	Title       string `form:"title" binding:"required"`
+	Content     string `form:"content" binding:"required"`
+	Data        string `form:"data"`         // JSON字符串
+	Privacy     string `form:"privacy"`      // 通知隐私级别：private 时通知栏只显示占位文案
+	CollapseKey string `form:"collapse_key"` // 折叠键：替换设备上同键的通知和未确认的旧消息
+}
+
+// RecipientPushRequest 向接收者的所有设备推送通知（GET参数）
+type RecipientPushRequest struct {
+	RecipientId string `form:"recipient_id" binding:"required"`
+	Title       string `form:"title" binding:"required"`
+	Content     string `form:"content" binding:"required"`
+	Data        string `form:"data"`         // JSON字符串
+	Privacy     string `form:"privacy"`      // 通知隐私级别：private 时通知栏只显示占位文案
+	CollapseKey string `form:"collapse_key"` // 折叠键：在每台设备上替换同键的通知和未确认的旧消息
+}
+
+// GroupPushRequest 向分组（管理员分配或设备订阅的主题）的所有成员推送通知（GET参数）
+type GroupPushRequest struct {
+	Group       string `form:"group" binding:"required"`
+	Title       string `form:"title" binding:"required"`
+	Content     string `form:"content" binding:"required"`
+	Data        string `form:"data"`         // JSON字符串
+	Privacy     string `form:"privacy"`      // 通知隐私级别：private 时通知栏只显示占位文案
+	CollapseKey string `form:"collapse_key"` // 折叠键：在每台设备上替换同键的通知和未确认的旧消息
+}
+
+// RecallMessageRequest 撤回消息请求（JSON或表单/查询参数）
+type RecallMessageRequest struct {
+	MessageID string `form:"message_id" json:"message_id" binding:"required"` // 发送时返回的 message_id
 }
 
 // FormUpdateRequest 卡片刷新请求（GET参数）
@@ -125,6 +158,9 @@ const (
 	SignatureExpired = 2003 // 签名过期
 	InvalidAppID     = 2004 // 无效的AppID
 	VersionTooOld    = 2005 // 版本过旧
+	PermissionDenied = 2006 // 无权限
+	RateLimited      = 2007 // 请求过于频繁
+	QuotaExceeded    = 2008 // 超出每日额度
 )
 
 // 业务错误 3xxx
@@ -0,0 +1,539 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// 推送方API Key的权限范围，注释中为检查该权限的路由
+const (
+	ScopeNotification = "notification" // /push/notification, /push/public-key, /push/encrypted, /push/recipient
+	ScopeBatch        = "batch"        // /push/group
+	ScopeManage       = "manage"       // /push/recall, /push/status
+)
+
+const (
+	apiKeyPrefix       = "ddk_"
+	apiKeyRandomBytes  = 24
+	apiKeyDisplayChars = 8
+)
+
+var validAPIKeyScopes = map[string]struct{}{
+	ScopeNotification: {},
+	ScopeBatch:        {},
+	ScopeManage:       {},
+}
+
+var (
+	ErrAPIKeyInvalid       = errors.New("invalid API key")
+	ErrAPIKeyRevoked       = errors.New("API key has been revoked")
+	ErrAPIKeyExpired       = errors.New("API key has expired")
+	ErrAPIKeyScope         = errors.New("API key does not allow this push type")
+	ErrAPIKeyRateLimited   = errors.New("API key rate limit exceeded")
+	ErrAPIKeyQuotaExceeded = errors.New("API key daily quota exceeded")
+	ErrAPIKeyNotFound      = errors.New("API key not found")
+	ErrInvalidAPIKeySpec   = errors.New("invalid API key specification")
+)
+
+// APIKey 管理员签发的推送方凭据，或设备签发的发送授权
+// 原始Key只在创建时返回一次，数据库只保存其SHA-256哈希
+type APIKey struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	KeyPrefix          string   `json:"keyPrefix"`
+	Scopes             []string `json:"scopes"`
+	AllowedDeviceIDs   []string `json:"allowedDeviceIds"`
+	AllowedGroups      []string `json:"allowedGroups"`
+	RateLimitPerMinute int      `json:"rateLimitPerMinute"`
+	DailyQuota         int      `json:"dailyQuota"`
+	OwnerDeviceID      string   `json:"ownerDeviceId,omitempty"` // 设备签发的发送授权所属设备
+	ExpiresAt          string   `json:"expiresAt,omitempty"`
+	LastUsedAt         string   `json:"lastUsedAt,omitempty"`
+	LastUsedIP         string   `json:"lastUsedIp,omitempty"`
+	RevokedAt          string   `json:"revokedAt,omitempty"`
+	CreatedAt          string   `json:"createdAt"`
+}
+
+// APIKeySpec 待签发Key的属性
+type APIKeySpec struct {
+	Name               string   `json:"name" binding:"required"`
+	Scopes             []string `json:"scopes"`
+	AllowedDeviceIDs   []string `json:"allowedDeviceIds"`
+	AllowedGroups      []string `json:"allowedGroups"`
+	RateLimitPerMinute int      `json:"rateLimitPerMinute"`
+	DailyQuota         int      `json:"dailyQuota"`
+}
+
+// HasScope Key是否拥有指定的权限范围
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired Key是否设置了过期时间且已过期
+func (k *APIKey) Expired(now time.Time) bool {
+	if k.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+	return err == nil && !now.Before(expiresAt)
+}
+
+// APIKeyService 签发、校验推送方API Key并统计用量
+type APIKeyService struct {
+	db      *sql.DB
+	limiter *minuteRateLimiter
+}
+
+// NewAPIKeyService 创建基于 api_keys 表的API Key服务
+func NewAPIKeyService(db *sql.DB) *APIKeyService {
+	return &APIKeyService{
+		db:      db,
+		limiter: newMinuteRateLimiter(),
+	}
+}
+
+// Create 签发新Key，返回Key信息和原始Key
+func (s *APIKeyService) Create(ctx context.Context, spec APIKeySpec, actor AuditActor) (*APIKey, string, error) {
+	normalized, err := normalizeAPIKeySpec(spec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate api key: %w", err)
+	}
+
+	key := &APIKey{
+		Name:               normalized.Name,
+		KeyPrefix:          apiKeyDisplayPrefix(rawKey),
+		Scopes:             normalized.Scopes,
+		AllowedDeviceIDs:   normalized.AllowedDeviceIDs,
+		AllowedGroups:      normalized.AllowedGroups,
+		RateLimitPerMinute: normalized.RateLimitPerMinute,
+		DailyQuota:         normalized.DailyQuota,
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin api key transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO api_keys (
+			name, key_prefix, key_hash, scopes, allowed_device_ids, allowed_groups,
+			rate_limit_per_minute, daily_quota
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id::TEXT, to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')
+	`, key.Name, key.KeyPrefix, hashAPIKey(rawKey), pq.Array(key.Scopes),
+		pq.Array(key.AllowedDeviceIDs), pq.Array(key.AllowedGroups),
+		key.RateLimitPerMinute, key.DailyQuota).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("insert api key: %w", err)
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditAPIKeyCreate, "", map[string]interface{}{
+		"apiKeyId": key.ID,
+		"name":     key.Name,
+		"scopes":   key.Scopes,
+	})); err != nil {
+		return nil, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("commit api key transaction: %w", err)
+	}
+	return key, rawKey, nil
+}
+
+// List 按创建时间倒序返回所有Key，不包含原始Key
+func (s *APIKeyService) List(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke 吊销Key，吊销后的记录保留用于审计
+func (s *APIKeyService) Revoke(ctx context.Context, id string, actor AuditActor) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrAPIKeyNotFound
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin api key transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, NOW()), updated_at = NOW()
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditAPIKeyRevoke, "", map[string]interface{}{"apiKeyId": id})); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit api key transaction: %w", err)
+	}
+	return nil
+}
+
+// Authenticate 校验原始Key的状态、权限范围和每分钟请求数，并记录最近使用时间和IP
+func (s *APIKeyService) Authenticate(ctx context.Context, rawKey string, scope string, clientIP string) (*APIKey, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE key_hash = $1
+	`, hashAPIKey(rawKey))
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query api key: %w", err)
+	}
+
+	if key.RevokedAt != "" {
+		return nil, ErrAPIKeyRevoked
+	}
+	if key.Expired(time.Now()) {
+		return nil, ErrAPIKeyExpired
+	}
+	if !key.HasScope(scope) {
+		return nil, ErrAPIKeyScope
+	}
+	if !s.limiter.Allow(key.ID, key.RateLimitPerMinute, time.Now()) {
+		return nil, ErrAPIKeyRateLimited
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE api_keys
+		SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1
+	`, key.ID, clientIP); err != nil {
+		return nil, fmt.Errorf("update api key last used: %w", err)
+	}
+
+	return key, nil
+}
+
+// ConsumeQuota 从Key的每日额度中扣除 count 次推送
+func (s *APIKeyService) ConsumeQuota(ctx context.Context, key *APIKey, count int) error {
+	if key == nil || key.DailyQuota <= 0 || count <= 0 {
+		return nil
+	}
+	if count > key.DailyQuota {
+		return ErrAPIKeyQuotaExceeded
+	}
+
+	// 仅在额度足够时累加，避免被拒绝的请求占用额度
+	var used int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO api_key_daily_usage (api_key_id, usage_date, push_count)
+		VALUES ($1, (NOW() AT TIME ZONE 'UTC')::DATE, $2)
+		ON CONFLICT (api_key_id, usage_date) DO UPDATE
+			SET push_count = api_key_daily_usage.push_count + EXCLUDED.push_count
+			WHERE api_key_daily_usage.push_count + EXCLUDED.push_count <= $3
+		RETURNING push_count
+	`, key.ID, count, key.DailyQuota).Scan(&used)
+	if err == sql.ErrNoRows {
+		return ErrAPIKeyQuotaExceeded
+	}
+	if err != nil {
+		return fmt.Errorf("consume api key quota: %w", err)
+	}
+	return nil
+}
+
+// AllowsDevice Key是否可以推送到该设备：设备直接列在 allowedDeviceIds 中，或属于 allowedGroups 中的分组
+// 未限定设备和分组的Key可以推送到所有设备
+func (s *APIKeyService) AllowsDevice(ctx context.Context, key *APIKey, deviceID string) (bool, error) {
+	if key == nil {
+		return true, nil
+	}
+	if len(key.AllowedDeviceIDs) == 0 && len(key.AllowedGroups) == 0 {
+		return true, nil
+	}
+	for _, allowed := range key.AllowedDeviceIDs {
+		if strings.EqualFold(allowed, deviceID) {
+			return true, nil
+		}
+	}
+	if len(key.AllowedGroups) == 0 {
+		return false, nil
+	}
+
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM device_group_members
+			WHERE device_id::TEXT = $1 AND group_name = ANY($2)
+		)
+	`, deviceID, pq.Array(key.AllowedGroups)).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("query api key group membership: %w", err)
+	}
+	return allowed, nil
+}
+
+// AllowsGroup Key是否可以推送到整个分组
+// 匿名推送方一律不可以；未限定设备和分组的Key可以，否则分组必须明确列在 allowedGroups 中
+func (s *APIKeyService) AllowsGroup(key *APIKey, group string) bool {
+	if key == nil {
+		return false
+	}
+	if len(key.AllowedDeviceIDs) == 0 && len(key.AllowedGroups) == 0 {
+		return true
+	}
+	for _, allowed := range key.AllowedGroups {
+		if allowed == group {
+			return true
+		}
+	}
+	return false
+}
+
+const apiKeyColumns = `
+	id::TEXT, name, key_prefix, scopes, allowed_device_ids, allowed_groups,
+	rate_limit_per_minute, daily_quota, COALESCE(owner_device_id::TEXT, ''),
+	COALESCE(to_char(expires_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), ''),
+	COALESCE(to_char(last_used_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), ''),
+	COALESCE(last_used_ip, ''),
+	COALESCE(to_char(revoked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), ''),
+	to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	if err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.KeyPrefix,
+		pq.Array(&key.Scopes),
+		pq.Array(&key.AllowedDeviceIDs),
+		pq.Array(&key.AllowedGroups),
+		&key.RateLimitPerMinute,
+		&key.DailyQuota,
+		&key.OwnerDeviceID,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+		&key.RevokedAt,
+		&key.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	if key.AllowedDeviceIDs == nil {
+		key.AllowedDeviceIDs = []string{}
+	}
+	if key.AllowedGroups == nil {
+		key.AllowedGroups = []string{}
+	}
+	return &key, nil
+}
+
+func normalizeAPIKeySpec(spec APIKeySpec) (APIKeySpec, error) {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if spec.Name == "" || len(spec.Name) > 128 {
+		return APIKeySpec{}, fmt.Errorf("%w: name is required and must be at most 128 characters", ErrInvalidAPIKeySpec)
+	}
+	if spec.RateLimitPerMinute < 0 || spec.DailyQuota < 0 {
+		return APIKeySpec{}, fmt.Errorf("%w: rateLimitPerMinute and dailyQuota cannot be negative", ErrInvalidAPIKeySpec)
+	}
+
+	scopes := spec.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{ScopeNotification}
+	}
+	normalizedScopes, err := normalizeList(scopes, func(scope string) (string, error) {
+		scope = strings.ToLower(scope)
+		if _, ok := validAPIKeyScopes[scope]; !ok {
+			return "", fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKeySpec, scope)
+		}
+		return scope, nil
+	})
+	if err != nil {
+		return APIKeySpec{}, err
+	}
+
+	deviceIDs, err := normalizeList(spec.AllowedDeviceIDs, func(deviceID string) (string, error) {
+		parsed, err := uuid.Parse(deviceID)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid device_id %q", ErrInvalidAPIKeySpec, deviceID)
+		}
+		return parsed.String(), nil
+	})
+	if err != nil {
+		return APIKeySpec{}, err
+	}
+
+	groups, err := normalizeList(spec.AllowedGroups, func(group string) (string, error) {
+		if !IsValidGroupName(group) {
+			return "", fmt.Errorf("%w: invalid group name %q", ErrInvalidAPIKeySpec, group)
+		}
+		return group, nil
+	})
+	if err != nil {
+		return APIKeySpec{}, err
+	}
+
+	spec.Scopes = normalizedScopes
+	spec.AllowedDeviceIDs = deviceIDs
+	spec.AllowedGroups = groups
+	return spec, nil
+}
+
+func normalizeList(values []string, normalize func(string) (string, error)) ([]string, error) {
+	seen := make(map[string]struct{}, len(values))
+	result := []string{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		normalized, err := normalize(value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[normalized]; ok {
+			continue
+		}
+		seen[normalized] = struct{}{}
+		result = append(result, normalized)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func generateAPIKey() (string, error) {
+	buf := make([]byte, apiKeyRandomBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func apiKeyDisplayPrefix(rawKey string) string {
+	end := len(apiKeyPrefix) + apiKeyDisplayChars
+	if len(rawKey) < end {
+		return rawKey
+	}
+	return rawKey[:end]
+}
+
+// minuteRateLimiter 按API Key ID计数的进程内固定窗口限流器
+// 整整一分钟没有请求的Key的窗口每分钟清理一次
+type minuteRateLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastPrune time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newMinuteRateLimiter() *minuteRateLimiter {
+	return &minuteRateLimiter{windows: make(map[string]*rateWindow)}
+}
+
+// Allow 为 id 计一次请求，返回是否仍在每分钟 limit 次以内；limit 小于等于0时不限流
+func (l *minuteRateLimiter) Allow(id string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	windowStart := now.Truncate(time.Minute)
+	if windowStart.After(l.lastPrune) {
+		l.prune(windowStart)
+	}
+
+	window, ok := l.windows[id]
+	if !ok || !window.start.Equal(windowStart) {
+		window = &rateWindow{start: windowStart}
+		l.windows[id] = window
+	}
+	if window.count >= limit {
+		return false
+	}
+	window.count++
+	return true
+}
+
+// prune 删除早于 windowStart 的窗口，调用方需持有 l.mu
+func (l *minuteRateLimiter) prune(windowStart time.Time) {
+	for id, window := range l.windows {
+		if window.start.Before(windowStart) {
+			delete(l.windows, id)
+		}
+	}
+	l.lastPrune = windowStart
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeAPIKeySpecDefaultsAndDeduplicates(t *testing.T) {
+	spec, err := normalizeAPIKeySpec(APIKeySpec{
+		Name:             "  monitoring ",
+		AllowedDeviceIDs: []string{"D5E2A0A0-36A8-4D8B-BCB7-469C7F09FC61", "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61"},
+		AllowedGroups:    []string{"oncall", "oncall"},
+	})
+	if err != nil {
+		t.Fatalf("normalizeAPIKeySpec returned error: %v", err)
+	}
+
+	if spec.Name != "monitoring" {
+		t.Fatalf("name = %q, want monitoring", spec.Name)
+	}
+	if len(spec.Scopes) != 1 || spec.Scopes[0] != ScopeNotification {
+		t.Fatalf("scopes = %v, want [notification]", spec.Scopes)
+	}
+	if len(spec.AllowedDeviceIDs) != 1 || spec.AllowedDeviceIDs[0] != "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61" {
+		t.Fatalf("allowed device ids = %v", spec.AllowedDeviceIDs)
+	}
+	if len(spec.AllowedGroups) != 1 {
+		t.Fatalf("allowed groups = %v", spec.AllowedGroups)
+	}
+}
+
+func TestNormalizeAPIKeySpecRejectsInvalidInput(t *testing.T) {
+	specs := []APIKeySpec{
+		{Name: ""},
+		{Name: "x", Scopes: []string{"admin"}},
+		{Name: "x", Scopes: []string{"background"}},
+		{Name: "x", AllowedDeviceIDs: []string{"not-a-uuid"}},
+		{Name: "x", AllowedGroups: []string{"On Call"}},
+		{Name: "x", DailyQuota: -1},
+	}
+
+	for _, spec := range specs {
+		if _, err := normalizeAPIKeySpec(spec); !errors.Is(err, ErrInvalidAPIKeySpec) {
+			t.Fatalf("normalizeAPIKeySpec(%+v) error = %v, want ErrInvalidAPIKeySpec", spec, err)
+		}
+	}
+}
+
+func TestAllowsGroupRequiresExplicitGroupBinding(t *testing.T) {
+	s := &APIKeyService{}
+	cases := []struct {
+		key  *APIKey
+		want bool
+	}{
+		{nil, false},
+		{&APIKey{}, true},
+		{&APIKey{AllowedGroups: []string{"deploys"}}, true},
+		{&APIKey{AllowedGroups: []string{"oncall"}}, false},
+		{&APIKey{AllowedDeviceIDs: []string{"d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61"}}, false},
+	}
+	for _, tc := range cases {
+		if got := s.AllowsGroup(tc.key, "deploys"); got != tc.want {
+			t.Fatalf("AllowsGroup(%+v) = %v, want %v", tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestAPIKeyExpired(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	cases := map[string]bool{
+		"":                         false,
+		"2026-10-19T12:00:01.000Z": false,
+		"2026-10-19T12:00:00.000Z": true,
+		"2026-10-18T08:30:00.000Z": true,
+	}
+	for expiresAt, want := range cases {
+		key := &APIKey{ExpiresAt: expiresAt}
+		if got := key.Expired(now); got != want {
+			t.Fatalf("Expired(%q) = %v, want %v", expiresAt, got, want)
+		}
+	}
+}
+
+func TestGenerateAPIKeyHasPrefixAndStableHash(t *testing.T) {
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("generateAPIKey returned error: %v", err)
+	}
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		t.Fatalf("raw key %q does not start with %q", rawKey, apiKeyPrefix)
+	}
+	if hashAPIKey(rawKey) != hashAPIKey(rawKey) || len(hashAPIKey(rawKey)) != 64 {
+		t.Fatal("hashAPIKey must be a deterministic SHA-256 hex digest")
+	}
+	if got := apiKeyDisplayPrefix(rawKey); len(got) != len(apiKeyPrefix)+apiKeyDisplayChars {
+		t.Fatalf("display prefix = %q", got)
+	}
+}
+
+func TestMinuteRateLimiterResetsEachMinute(t *testing.T) {
+	limiter := newMinuteRateLimiter()
+	now := time.Date(2026, 10, 19, 12, 0, 10, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if !limiter.Allow("key", 2, now) {
+			t.Fatalf("request %d rejected within limit", i+1)
+		}
+	}
+	if limiter.Allow("key", 2, now) {
+		t.Fatal("third request within the same minute was allowed")
+	}
+	if !limiter.Allow("key", 2, now.Add(time.Minute)) {
+		t.Fatal("request in the next minute was rejected")
+	}
+	if !limiter.Allow("other", 0, now) {
+		t.Fatal("zero limit must disable rate limiting")
+	}
+}
+
+func TestMinuteRateLimiterEvictsIdleKeys(t *testing.T) {
+	limiter := newMinuteRateLimiter()
+	now := time.Date(2026, 10, 19, 12, 0, 10, 0, time.UTC)
+
+	limiter.Allow("idle", 5, now)
+	limiter.Allow("busy", 5, now)
+	limiter.Allow("busy", 5, now.Add(time.Minute))
+
+	if _, ok := limiter.windows["idle"]; ok {
+		t.Fatal("window of a key idle for a minute was kept")
+	}
+	if window := limiter.windows["busy"]; window == nil || window.count != 1 {
+		t.Fatalf("busy window = %+v, want a fresh window with one request", window)
+	}
+}
@@ -77,6 +77,19 @@ func SyncAppUpdatePolicyFromManifest(ctx context.Context, db *sql.DB, policyFile
 		}
 		if activated {
 			logger.Info("App update policy activated from manifest: platform=%s versionCode=%d", release.Platform, release.VersionCode)
+			if err := RecordAuditEvent(ctx, tx, AuditEvent{
+				EventType: AuditAppPolicyUpdate,
+				ActorType: AuditActorSystem,
+				Details: map[string]interface{}{
+					"platform":    release.Platform,
+					"versionCode": release.VersionCode,
+					"minVersion":  release.MinVersionCode,
+					"forceUpdate": release.ForceUpdate,
+					"source":      policyFile,
+				},
+			}); err != nil {
+				return err
+			}
 		} else {
 			logger.Info("App update policy manifest is not newer than current policy, current policy kept: platform=%s versionCode=%d", release.Platform, release.VersionCode)
 		}
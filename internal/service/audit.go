@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 审计操作者类型
+const (
+	AuditActorDevice    = "device"  // 只凭 device_id 鉴权的请求
+	AuditActorAPIKey    = "api_key" // 推送方API Key，操作者ID为Key ID
+	AuditActorAdmin     = "admin"   // 持有 ADMIN_TOKEN 的管理员
+	AuditActorSystem    = "system"  // 启动同步或后台任务
+	AuditActorAnonymous = "anonymous"
+)
+
+// 审计事件类型
+const (
+	AuditDeviceRegister      = "device.register"
+	AuditDeviceReregister    = "device.reregister"
+	AuditDeviceTokenUpdate   = "device.token_update"
+	AuditDeviceMerge         = "device.merge"
+	AuditDeviceDelete        = "device.delete"
+	AuditDevicePrivacyUpdate = "device.privacy_update"
+	AuditDeviceKeyRotate     = "device.key_rotate"
+	AuditAppPolicyUpdate     = "app_policy.update"
+	AuditAPIKeyCreate        = "api_key.create"
+	AuditAPIKeyRevoke        = "api_key.revoke"
+	AuditGroupMemberAdd      = "group.member_add"
+	AuditGroupMemberRemove   = "group.member_remove"
+	AuditRecipientJoin       = "recipient.join"
+	AuditRecipientLeave      = "recipient.leave"
+	AuditGroupSubscribe      = "group.subscribe"
+	AuditGroupUnsubscribe    = "group.unsubscribe"
+	AuditSendGrantCreate     = "send_grant.create"
+	AuditSendGrantRevoke     = "send_grant.revoke"
+)
+
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 500
+)
+
+// ErrInvalidAuditFilter 审计查询条件格式错误
+var ErrInvalidAuditFilter = errors.New("invalid audit filter")
+
+// AuditEvent 只追加的审计日志中的一条记录
+type AuditEvent struct {
+	ID        int64                  `json:"id"`
+	EventType string                 `json:"eventType"`
+	ActorType string                 `json:"actorType"`
+	ActorID   string                 `json:"actorId,omitempty"`
+	DeviceID  string                 `json:"deviceId,omitempty"`
+	ClientIP  string                 `json:"clientIp,omitempty"`
+	UserAgent string                 `json:"userAgent,omitempty"`
+	RequestID string                 `json:"requestId,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt string                 `json:"createdAt"`
+}
+
+// AuditActor 标识执行被审计变更的操作者
+// 服务在变更所在的事务内写入审计事件，变更不会在没有审计记录的情况下提交
+type AuditActor struct {
+	Type      string
+	ID        string
+	ClientIP  string
+	UserAgent string
+	RequestID string
+}
+
+// SystemAuditActor 启动同步和后台任务的操作者
+var SystemAuditActor = AuditActor{Type: AuditActorSystem}
+
+// Event 创建由该操作者执行的审计事件
+func (a AuditActor) Event(eventType string, deviceID string, details map[string]interface{}) AuditEvent {
+	return AuditEvent{
+		EventType: eventType,
+		ActorType: a.Type,
+		ActorID:   a.ID,
+		DeviceID:  deviceID,
+		ClientIP:  a.ClientIP,
+		UserAgent: a.UserAgent,
+		RequestID: a.RequestID,
+		Details:   details,
+	}
+}
+
+// AuditFilter 审计事件查询条件，空字段不参与过滤；结果按时间倒序，BeforeID 向前翻页
+type AuditFilter struct {
+	EventType string
+	ActorType string
+	ActorID   string
+	DeviceID  string
+	Since     time.Time
+	Until     time.Time
+	BeforeID  int64
+	Limit     int
+}
+
+// RecordAuditEvent 向审计日志追加一条事件
+func RecordAuditEvent(ctx context.Context, db sqlExecutor, event AuditEvent) error {
+	if event.EventType == "" || event.ActorType == "" {
+		return fmt.Errorf("audit event requires event and actor type")
+	}
+
+	var details []byte
+	if len(event.Details) > 0 {
+		var err error
+		details, err = json.Marshal(event.Details)
+		if err != nil {
+			return fmt.Errorf("marshal audit details: %w", err)
+		}
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO audit_events (event_type, actor_type, actor_id, device_id, client_ip, user_agent, request_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb)
+	`, event.EventType, event.ActorType, nullString(event.ActorID), nullString(event.DeviceID),
+		nullString(event.ClientIP), nullString(truncateString(event.UserAgent, 256)), nullString(event.RequestID), nullString(string(details)))
+	if err != nil {
+		return fmt.Errorf("insert audit event: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEvents 按时间倒序返回符合条件的审计事件
+func ListAuditEvents(ctx context.Context, db *sql.DB, filter AuditFilter) ([]AuditEvent, error) {
+	query, args, err := buildAuditQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var (
+			event   AuditEvent
+			details []byte
+		)
+		if err := rows.Scan(&event.ID, &event.EventType, &event.ActorType, &event.ActorID, &event.DeviceID,
+			&event.ClientIP, &event.UserAgent, &event.RequestID, &details, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit event: %w", err)
+		}
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &event.Details); err != nil {
+				return nil, fmt.Errorf("decode audit details: %w", err)
+			}
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate audit events: %w", err)
+	}
+	return events, nil
+}
+
+// buildAuditQuery 将查询条件转换为参数化查询
+func buildAuditQuery(filter AuditFilter) (string, []interface{}, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditQueryLimit
+	}
+	if limit > maxAuditQueryLimit {
+		return "", nil, fmt.Errorf("%w: limit must be at most %d", ErrInvalidAuditFilter, maxAuditQueryLimit)
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Until.Before(filter.Since) {
+		return "", nil, fmt.Errorf("%w: until is before since", ErrInvalidAuditFilter)
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.EventType != "" {
+		add("event_type = $%d", filter.EventType)
+	}
+	if filter.ActorType != "" {
+		add("actor_type = $%d", filter.ActorType)
+	}
+	if filter.ActorID != "" {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.DeviceID != "" {
+		add("device_id = $%d", filter.DeviceID)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		add("id < $%d", filter.BeforeID)
+	}
+
+	query := `
+		SELECT id, event_type, actor_type, COALESCE(actor_id, ''), COALESCE(device_id::TEXT, ''),
+		       COALESCE(client_ip, ''), COALESCE(user_agent, ''), COALESCE(request_id, ''), details,
+		       to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')
+		FROM audit_events`
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf("\n\t\tORDER BY id DESC\n\t\tLIMIT $%d", len(args))
+	return query, args, nil
+}
+
+// CleanAuditEvents 删除早于保留期的审计事件，保留期为0时永久保留
+func CleanAuditEvents(ctx context.Context, db *sql.DB, retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM audit_events WHERE created_at < $1
+	`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// truncateString 将 value 截断到最多 max 字节，不拆开多字节字符
+func truncateString(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildAuditQueryNumbersFilterArguments(t *testing.T) {
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	query, args, err := buildAuditQuery(AuditFilter{
+		EventType: AuditDeviceDelete,
+		DeviceID:  "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61",
+		Since:     since,
+		BeforeID:  42,
+	})
+	if err != nil {
+		t.Fatalf("buildAuditQuery: %v", err)
+	}
+
+	for _, want := range []string{"event_type = $1", "device_id = $2", "created_at >= $3", "id < $4", "LIMIT $5", "ORDER BY id DESC"} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("query missing %q:\n%s", want, query)
+		}
+	}
+	if len(args) != 5 || args[4] != defaultAuditQueryLimit {
+		t.Fatalf("args = %v", args)
+	}
+}
+
+func TestBuildAuditQueryRejectsInvalidFilters(t *testing.T) {
+	now := time.Now()
+	cases := []AuditFilter{
+		{Limit: maxAuditQueryLimit + 1},
+		{Since: now, Until: now.Add(-time.Hour)},
+	}
+	for _, filter := range cases {
+		if _, _, err := buildAuditQuery(filter); !errors.Is(err, ErrInvalidAuditFilter) {
+			t.Fatalf("buildAuditQuery(%+v) error = %v, want ErrInvalidAuditFilter", filter, err)
+		}
+	}
+}
+
+func TestTruncateStringKeepsRunesIntact(t *testing.T) {
+	if got := truncateString("噔噔推送", 4); got != "噔" {
+		t.Fatalf("truncateString = %q, want 噔", got)
+	}
+}
+
+func TestMergeDevicesAuditsBothDeviceIDs(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	survivor := insertTestDevice(t, db)
+	duplicate := insertTestDevice(t, db)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := MergeDevices(ctx, tx, survivor, duplicate, SystemAuditActor); err != nil {
+		t.Fatalf("MergeDevices returned error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	for deviceID, key := range map[string]string{survivor: "mergedDeviceId", duplicate: "survivorDeviceId"} {
+		events, err := ListAuditEvents(ctx, db, AuditFilter{DeviceID: deviceID, EventType: AuditDeviceMerge})
+		if err != nil {
+			t.Fatalf("ListAuditEvents returned error: %v", err)
+		}
+		if len(events) != 1 || events[0].ActorType != AuditActorSystem || events[0].Details[key] == nil {
+			t.Fatalf("merge events for %s = %+v", deviceID, events)
+		}
+	}
+}
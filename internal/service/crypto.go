@@ -3,6 +3,7 @@ package service
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -11,14 +12,25 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
 )
 
+// 消息加密方案版本
+const (
+	MessageCryptoV1 = 1 // RSA-OAEP 包装 AES-256-GCM 密钥
+	MessageCryptoV2 = 2 // X25519 ECDH + HKDF-SHA256 + ChaCha20-Poly1305
+)
+
 // EncryptedMessage 加密后的消息结构
 type EncryptedMessage struct {
-	EncryptedAESKey  string `json:"encrypted_aes_key"` // RSA加密的AES密钥
-	EncryptedContent string `json:"encrypted_content"` // AES加密的消息内容
-	IV               string `json:"iv"`                // AES IV向量
+	CryptoVersion      int    `json:"crypto_version"`                 // 加密方案版本
+	EnvelopeVersion    int    `json:"envelope_version"`               // 信封版本，v2起绑定关联数据
+	KeyID              string `json:"key_id,omitempty"`               // 加密所用的设备公钥ID
+	EncryptedAESKey    string `json:"encrypted_aes_key"`              // RSA加密的AES密钥（v1）
+	EphemeralPublicKey string `json:"ephemeral_public_key,omitempty"` // 临时X25519公钥（v2）
+	EncryptedContent   string `json:"encrypted_content"`              // 加密的消息内容
+	IV                 string `json:"iv"`                             // AEAD nonce
 }
 
 // MessageContent 原始消息内容
@@ -37,16 +49,46 @@ func NewCryptoService() *CryptoService {
 	return &CryptoService{}
 }
 
-// EncryptMessage 使用RSA+AES混合加密消息
-// publicKeyPEM: PEM格式的RSA公钥
+// EncryptMessage 使用设备公钥加密消息
+// 加密方案由设备注册时上传的公钥类型决定：RSA公钥使用v1，X25519公钥使用v2
+// publicKeyPEM: PEM格式的PKIX公钥
 // message: 要加密的消息内容
-func (s *CryptoService) EncryptMessage(publicKeyPEM string, message MessageContent) (*EncryptedMessage, error) {
+// binding: 密文绑定的设备、消息ID、服务器名称和创建时间（作为AEAD关联数据）
+func (s *CryptoService) EncryptMessage(publicKeyPEM string, message MessageContent, binding MessageBinding) (*EncryptedMessage, error) {
 	// 1. 将消息序列化为JSON
 	messageJSON, err := json.Marshal(message)
 	if err != nil {
 		return nil, errors.New("failed to marshal message: " + err.Error())
 	}
 
+	publicKey, err := parsePublicKeyPEM(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted *EncryptedMessage
+	associatedData := binding.AssociatedData()
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		encrypted, err = s.encryptV1(publicKey, messageJSON, associatedData)
+	case *ecdh.PublicKey:
+		if publicKey.Curve() != ecdh.X25519() {
+			return nil, errors.New("unsupported ECDH curve, expected X25519")
+		}
+		encrypted, err = s.encryptV2(publicKey, messageJSON, associatedData)
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted.EnvelopeVersion = MessageEnvelopeV2
+	return encrypted, nil
+}
+
+// encryptV1 使用RSA+AES混合加密消息
+func (s *CryptoService) encryptV1(publicKey *rsa.PublicKey, messageJSON []byte, associatedData []byte) (*EncryptedMessage, error) {
 	// 2. 生成随机AES密钥（32字节 = AES-256）
 	aesKey := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, aesKey); err != nil {
@@ -54,18 +96,19 @@ func (s *CryptoService) EncryptMessage(publicKeyPEM string, message MessageConte
 	}
 
 	// 3. 使用AES-GCM加密消息内容
-	encryptedContent, iv, err := s.encryptWithAES(aesKey, messageJSON)
+	encryptedContent, iv, err := s.encryptWithAES(aesKey, messageJSON, associatedData)
 	if err != nil {
 		return nil, err
 	}
 
 	// 4. 使用RSA公钥加密AES密钥
-	encryptedAESKey, err := s.encryptWithRSA(publicKeyPEM, aesKey)
+	encryptedAESKey, err := s.encryptWithRSA(publicKey, aesKey)
 	if err != nil {
 		return nil, err
 	}
 
 	return &EncryptedMessage{
+		CryptoVersion:    MessageCryptoV1,
 		EncryptedAESKey:  base64.StdEncoding.EncodeToString(encryptedAESKey),
 		EncryptedContent: base64.StdEncoding.EncodeToString(encryptedContent),
 		IV:               base64.StdEncoding.EncodeToString(iv),
@@ -73,7 +116,7 @@ func (s *CryptoService) EncryptMessage(publicKeyPEM string, message MessageConte
 }
 
 // encryptWithAES 使用AES-GCM加密数据
-func (s *CryptoService) encryptWithAES(key []byte, plaintext []byte) ([]byte, []byte, error) {
+func (s *CryptoService) encryptWithAES(key []byte, plaintext []byte, associatedData []byte) ([]byte, []byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, nil, errors.New("failed to create AES cipher: " + err.Error())
@@ -92,30 +135,27 @@ func (s *CryptoService) encryptWithAES(key []byte, plaintext []byte) ([]byte, []
 	}
 
 	// 加密数据
-	ciphertext := aesGCM.Seal(nil, iv, plaintext, nil)
+	ciphertext := aesGCM.Seal(nil, iv, plaintext, associatedData)
 
 	return ciphertext, iv, nil
 }
 
-// encryptWithRSA 使用RSA-OAEP加密数据
-func (s *CryptoService) encryptWithRSA(publicKeyPEM string, plaintext []byte) ([]byte, error) {
-	// 解析PEM格式的公钥
+// parsePublicKeyPEM 解析PEM格式的PKIX公钥
+func parsePublicKeyPEM(publicKeyPEM string) (interface{}, error) {
 	block, _ := pem.Decode([]byte(publicKeyPEM))
 	if block == nil {
 		return nil, errors.New("failed to parse PEM block containing public key")
 	}
 
-	// 解析公钥
-	pubInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, errors.New("failed to parse public key: " + err.Error())
 	}
+	return publicKey, nil
+}
 
-	rsaPubKey, ok := pubInterface.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("not an RSA public key")
-	}
-
+// encryptWithRSA 使用RSA-OAEP加密数据
+func (s *CryptoService) encryptWithRSA(rsaPubKey *rsa.PublicKey, plaintext []byte) ([]byte, error) {
 	// 使用RSA-OAEP加密
 	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPubKey, plaintext, nil)
 	if err != nil {
@@ -126,7 +166,45 @@ func (s *CryptoService) encryptWithRSA(publicKeyPEM string, plaintext []byte) ([
 }
 
 // DecryptMessage 解密消息（用于测试或服务端验证，实际解密在客户端）
-func (s *CryptoService) DecryptMessage(privateKeyPEM string, encrypted *EncryptedMessage) (*MessageContent, error) {
+// privateKeyPEM 为PKCS8格式的RSA（v1）或X25519（v2）私钥
+// binding 为解密方期望的上下文；信封v2的密文与其不一致时解密失败
+func (s *CryptoService) DecryptMessage(privateKeyPEM string, encrypted *EncryptedMessage, binding MessageBinding) (*MessageContent, error) {
+	var associatedData []byte
+	switch encrypted.EnvelopeVersion {
+	case 0, MessageEnvelopeV1:
+	case MessageEnvelopeV2:
+		associatedData = binding.AssociatedData()
+	default:
+		return nil, fmt.Errorf("unsupported envelope version %d", encrypted.EnvelopeVersion)
+	}
+
+	var (
+		plaintext []byte
+		err       error
+	)
+	switch encrypted.CryptoVersion {
+	case 0, MessageCryptoV1:
+		plaintext, err = s.decryptV1(privateKeyPEM, encrypted, associatedData)
+	case MessageCryptoV2:
+		plaintext, err = s.decryptV2(privateKeyPEM, encrypted, associatedData)
+	default:
+		return nil, fmt.Errorf("unsupported crypto version %d", encrypted.CryptoVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 反序列化JSON
+	var message MessageContent
+	if err := json.Unmarshal(plaintext, &message); err != nil {
+		return nil, errors.New("failed to unmarshal message: " + err.Error())
+	}
+
+	return &message, nil
+}
+
+// decryptV1 解密RSA+AES混合加密的消息
+func (s *CryptoService) decryptV1(privateKeyPEM string, encrypted *EncryptedMessage, associatedData []byte) ([]byte, error) {
 	// 1. Base64解码
 	encryptedAESKey, err := base64.StdEncoding.DecodeString(encrypted.EncryptedAESKey)
 	if err != nil {
@@ -150,18 +228,7 @@ func (s *CryptoService) DecryptMessage(privateKeyPEM string, encrypted *Encrypte
 	}
 
 	// 3. 使用AES密钥解密消息内容
-	plaintext, err := s.decryptWithAES(aesKey, encryptedContent, iv)
-	if err != nil {
-		return nil, err
-	}
-
-	// 4. 反序列化JSON
-	var message MessageContent
-	if err := json.Unmarshal(plaintext, &message); err != nil {
-		return nil, errors.New("failed to unmarshal message: " + err.Error())
-	}
-
-	return &message, nil
+	return s.decryptWithAES(aesKey, encryptedContent, iv, associatedData)
 }
 
 // decryptWithRSA 使用RSA-OAEP解密数据
@@ -190,7 +257,7 @@ func (s *CryptoService) decryptWithRSA(privateKeyPEM string, ciphertext []byte)
 }
 
 // decryptWithAES 使用AES-GCM解密数据
-func (s *CryptoService) decryptWithAES(key []byte, ciphertext []byte, iv []byte) ([]byte, error) {
+func (s *CryptoService) decryptWithAES(key []byte, ciphertext []byte, iv []byte, associatedData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, errors.New("failed to create AES cipher: " + err.Error())
@@ -201,7 +268,7 @@ func (s *CryptoService) decryptWithAES(key []byte, ciphertext []byte, iv []byte)
 		return nil, errors.New("failed to create GCM: " + err.Error())
 	}
 
-	plaintext, err := aesGCM.Open(nil, iv, ciphertext, nil)
+	plaintext, err := aesGCM.Open(nil, iv, ciphertext, associatedData)
 	if err != nil {
 		return nil, errors.New("failed to decrypt with AES: " + err.Error())
 	}
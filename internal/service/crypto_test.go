@@ -0,0 +1,174 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestCryptoServiceRoundTripV1(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	encrypted := encryptTestMessage(t, &privateKey.PublicKey, MessageCryptoV1)
+	if encrypted.EncryptedAESKey == "" || encrypted.EphemeralPublicKey != "" {
+		t.Fatalf("unexpected v1 envelope: %+v", encrypted)
+	}
+	assertDecryptsTestMessage(t, encodePrivateKeyPEM(t, privateKey), encrypted)
+}
+
+func TestCryptoServiceRoundTripV2(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	encrypted := encryptTestMessage(t, privateKey.PublicKey(), MessageCryptoV2)
+	if encrypted.EncryptedAESKey != "" || encrypted.EphemeralPublicKey == "" {
+		t.Fatalf("unexpected v2 envelope: %+v", encrypted)
+	}
+	assertDecryptsTestMessage(t, encodePrivateKeyPEM(t, privateKey), encrypted)
+}
+
+func TestCryptoServiceV2RejectsWrongKey(t *testing.T) {
+	recipient, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	encrypted := encryptTestMessage(t, recipient.PublicKey(), MessageCryptoV2)
+	if _, err := NewCryptoService().DecryptMessage(encodePrivateKeyPEM(t, other), encrypted, testBinding); err == nil {
+		t.Fatal("DecryptMessage with another key succeeded")
+	}
+}
+
+func TestCryptoServiceRejectsCiphertextOutsideItsBinding(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	otherBindings := map[string]MessageBinding{
+		"device":      NewMessageBinding("0b6f2f5e-3a4d-4c3a-9d55-6f3b7f1f0a11", testBinding.MessageID, testBinding.ServerName, testBinding.CreatedAt),
+		"message id":  NewMessageBinding(testBinding.DeviceID, "9d0b6a52-3c55-4f0e-8d2e-2b3c9f6e7a01", testBinding.ServerName, testBinding.CreatedAt),
+		"server name": NewMessageBinding(testBinding.DeviceID, testBinding.MessageID, "other-server", testBinding.CreatedAt),
+		"created at":  NewMessageBinding(testBinding.DeviceID, testBinding.MessageID, testBinding.ServerName, testBinding.CreatedAt.Add(time.Millisecond)),
+	}
+
+	keys := []struct {
+		public  interface{}
+		private interface{}
+		version int
+	}{
+		{&rsaKey.PublicKey, rsaKey, MessageCryptoV1},
+		{x25519Key.PublicKey(), x25519Key, MessageCryptoV2},
+	}
+	for _, key := range keys {
+		encrypted := encryptTestMessage(t, key.public, key.version)
+		for name, binding := range otherBindings {
+			if _, err := NewCryptoService().DecryptMessage(encodePrivateKeyPEM(t, key.private), encrypted, binding); err == nil {
+				t.Fatalf("crypto v%d: DecryptMessage with a different %s succeeded", key.version, name)
+			}
+		}
+	}
+}
+
+func TestCryptoServiceDecryptsEnvelopeV1WithoutBinding(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	messageJSON, err := json.Marshal(testMessage)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	encrypted, err := NewCryptoService().encryptV1(&privateKey.PublicKey, messageJSON, nil)
+	if err != nil {
+		t.Fatalf("encryptV1 returned error: %v", err)
+	}
+	encrypted.EnvelopeVersion = MessageEnvelopeV1
+
+	assertDecryptsTestMessage(t, encodePrivateKeyPEM(t, privateKey), encrypted)
+}
+
+func TestMessageBindingAssociatedData(t *testing.T) {
+	binding := NewMessageBinding(
+		"D5E2A0A0-36A8-4D8B-BCB7-469C7F09FC61",
+		"6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88",
+		"server\nname",
+		time.Date(2026, 10, 19, 8, 30, 0, 123456789, time.FixedZone("CST", 8*3600)),
+	)
+
+	want := "dengdeng/message-ad/v2\n" +
+		"d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61\n" +
+		"6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88\n" +
+		"2026-10-19T00:30:00.123Z\n" +
+		"server\nname"
+	if got := string(binding.AssociatedData()); got != want {
+		t.Fatalf("AssociatedData() = %q, want %q", got, want)
+	}
+}
+
+var testBinding = NewMessageBinding(
+	"d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61",
+	"6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88",
+	"test-server",
+	time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+)
+
+var testMessage = MessageContent{
+	Title:      "标题",
+	Content:    "内容",
+	Data:       []map[string]interface{}{{"key": "__url", "value": "https://example.com"}},
+	ServerName: "test-server",
+}
+
+func encryptTestMessage(t *testing.T, publicKey interface{}, wantVersion int) *EncryptedMessage {
+	t.Helper()
+	encrypted, err := NewCryptoService().EncryptMessage(encodePublicKeyPEM(t, publicKey), testMessage, testBinding)
+	if err != nil {
+		t.Fatalf("EncryptMessage returned error: %v", err)
+	}
+	if encrypted.CryptoVersion != wantVersion || encrypted.EnvelopeVersion != MessageEnvelopeV2 {
+		t.Fatalf("versions = crypto v%d envelope v%d, want crypto v%d envelope v2",
+			encrypted.CryptoVersion, encrypted.EnvelopeVersion, wantVersion)
+	}
+	return encrypted
+}
+
+func assertDecryptsTestMessage(t *testing.T, privateKeyPEM string, encrypted *EncryptedMessage) {
+	t.Helper()
+	message, err := NewCryptoService().DecryptMessage(privateKeyPEM, encrypted, testBinding)
+	if err != nil {
+		t.Fatalf("DecryptMessage returned error: %v", err)
+	}
+	if message.Title != testMessage.Title || message.Content != testMessage.Content ||
+		message.ServerName != testMessage.ServerName || len(message.Data) != 1 {
+		t.Fatalf("decrypted message = %+v, want %+v", message, testMessage)
+	}
+}
+
+func encodePrivateKeyPEM(t *testing.T, privateKey interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey returned error: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// messageCryptoV2Info 消息加密方案v2的HKDF info
+const messageCryptoV2Info = "dengdeng/message/v2"
+
+// encryptV2 使用临时X25519密钥协商加密消息（ECIES方式）：
+// 内容密钥为共享密钥的HKDF-SHA256（salt为临时公钥和接收方公钥），内容用ChaCha20-Poly1305加密
+func (s *CryptoService) encryptV2(recipient *ecdh.PublicKey, messageJSON []byte, associatedData []byte) (*EncryptedMessage, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.New("failed to generate ephemeral key: " + err.Error())
+	}
+
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, errors.New("failed to compute X25519 shared secret: " + err.Error())
+	}
+
+	key, err := deriveMessageKeyV2(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, errors.New("failed to create ChaCha20-Poly1305: " + err.Error())
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.New("failed to generate nonce: " + err.Error())
+	}
+
+	ciphertext := aead.Seal(nil, nonce, messageJSON, associatedData)
+
+	return &EncryptedMessage{
+		CryptoVersion:      MessageCryptoV2,
+		EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		EncryptedContent:   base64.StdEncoding.EncodeToString(ciphertext),
+		IV:                 base64.StdEncoding.EncodeToString(nonce),
+	}, nil
+}
+
+// decryptV2 用设备的PKCS8 X25519私钥解密 encryptV2 的结果
+func (s *CryptoService) decryptV2(privateKeyPEM string, encrypted *EncryptedMessage, associatedData []byte) ([]byte, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("failed to parse private key: " + err.Error())
+	}
+	privateKey, ok := parsed.(*ecdh.PrivateKey)
+	if !ok || privateKey.Curve() != ecdh.X25519() {
+		return nil, errors.New("not an X25519 private key")
+	}
+
+	ephemeralBytes, err := base64.StdEncoding.DecodeString(encrypted.EphemeralPublicKey)
+	if err != nil {
+		return nil, errors.New("failed to decode ephemeral public key: " + err.Error())
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, errors.New("invalid ephemeral public key: " + err.Error())
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted.EncryptedContent)
+	if err != nil {
+		return nil, errors.New("failed to decode encrypted content: " + err.Error())
+	}
+	nonce, err := base64.StdEncoding.DecodeString(encrypted.IV)
+	if err != nil {
+		return nil, errors.New("failed to decode IV: " + err.Error())
+	}
+
+	shared, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, errors.New("failed to compute X25519 shared secret: " + err.Error())
+	}
+
+	key, err := deriveMessageKeyV2(shared, ephemeralBytes, privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, errors.New("failed to create ChaCha20-Poly1305: " + err.Error())
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, errors.New("failed to decrypt with ChaCha20-Poly1305: " + err.Error())
+	}
+	return plaintext, nil
+}
+
+// deriveMessageKeyV2 从X25519共享密钥派生32字节内容密钥
+// salt 为临时公钥‖接收方公钥，使密钥绑定本次协商
+func deriveMessageKeyV2(shared []byte, ephemeralPublicKey []byte, recipientPublicKey []byte) ([]byte, error) {
+	salt := make([]byte, 0, len(ephemeralPublicKey)+len(recipientPublicKey))
+	salt = append(salt, ephemeralPublicKey...)
+	salt = append(salt, recipientPublicKey...)
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(messageCryptoV2Info)), key); err != nil {
+		return nil, errors.New("failed to derive message key: " + err.Error())
+	}
+	return key, nil
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// 分组成员来源
+const (
+	GroupMemberSourceAdmin  = "admin"  // 管理员分配
+	GroupMemberSourceDevice = "device" // App 自行订阅的话题
+)
+
+// MaxDeviceSubscriptions 每台设备最多自行订阅的话题数
+const MaxDeviceSubscriptions = 50
+
+// MaxGroupMembers 每个分组最多的成员数，分组推送在一次请求内为每个成员加密保存一份
+const MaxGroupMembers = 2000
+
+var (
+	// ErrGroupMembershipManaged 设备试图退出管理员分配的分组
+	ErrGroupMembershipManaged = errors.New("membership was assigned by the administrator")
+	// ErrTooManySubscriptions 设备订阅的话题已达 MaxDeviceSubscriptions 个
+	ErrTooManySubscriptions = fmt.Errorf("device cannot subscribe to more than %d topics", MaxDeviceSubscriptions)
+	// ErrGroupManaged 设备试图订阅有管理员分配成员的分组
+	// 这类分组只能由管理员添加成员，避免任意设备收到发给该分组的消息
+	ErrGroupManaged = errors.New("group is managed by the administrator")
+	// ErrGroupFull 分组成员已达 MaxGroupMembers 个
+	ErrGroupFull = fmt.Errorf("group cannot have more than %d members", MaxGroupMembers)
+)
+
+// GroupMembership 设备所在的一个分组
+type GroupMembership struct {
+	Group     string `json:"group"`
+	Source    string `json:"source"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// GroupSummary 至少有一个成员的分组
+type GroupSummary struct {
+	Name            string `json:"name"`
+	MemberCount     int64  `json:"memberCount"`
+	AssignedCount   int64  `json:"assignedCount"`   // 管理员分配的成员数
+	SubscribedCount int64  `json:"subscribedCount"` // App 订阅的成员数
+}
+
+// GroupMember 分组中的一台设备
+type GroupMember struct {
+	DeviceID   string `json:"deviceId"`
+	DeviceType string `json:"deviceType,omitempty"`
+	IsActive   bool   `json:"isActive"`
+	Source     string `json:"source"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// GroupPushTarget 分组中的活跃成员及加密和通知所需的信息，PushToken 仍为加密存储的形式
+type GroupPushTarget struct {
+	DeviceID     string
+	PushToken    string
+	PublicKey    string
+	PublicKeyID  string
+	PrivacyLevel string
+}
+
+// IsValidGroupName 分组名是否合法：1-64 位小写字母、数字、'-'、'_' 或 '.'
+func IsValidGroupName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// AddGroupMembers 将设备分配到分组，跳过不存在的设备，返回新加入的成员数
+func AddGroupMembers(ctx context.Context, db *sql.DB, group string, deviceIDs []string, source string, actor AuditActor) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin group membership transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	members, _, err := lockGroup(ctx, tx, group)
+	if err != nil {
+		return 0, err
+	}
+
+	var added int64
+	for _, deviceID := range deviceIDs {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO device_group_members (group_name, device_id, source)
+			SELECT $1, device_id, $3
+			FROM devices
+			WHERE device_id::TEXT = $2
+			ON CONFLICT (group_name, device_id) DO NOTHING
+		`, group, deviceID, source)
+		if err != nil {
+			return 0, fmt.Errorf("add group member: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("add group member: %w", err)
+		}
+		added += rows
+	}
+	if members+added > MaxGroupMembers {
+		return 0, ErrGroupFull
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditGroupMemberAdd, "", map[string]interface{}{
+		"group":      group,
+		"deviceIds":  deviceIDs,
+		"addedCount": added,
+	})); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit group membership transaction: %w", err)
+	}
+	return added, nil
+}
+
+// RemoveGroupMember 从分组中移除设备，返回设备原来是否为成员
+func RemoveGroupMember(ctx context.Context, db *sql.DB, group string, deviceID string, actor AuditActor) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin group membership transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM device_group_members
+		WHERE group_name = $1 AND device_id::TEXT = $2
+	`, group, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("remove group member: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("remove group member: %w", err)
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditGroupMemberRemove, deviceID, map[string]interface{}{"group": group})); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit group membership transaction: %w", err)
+	}
+	return true, nil
+}
+
+// SubscribeGroup 设备订阅话题，返回是否为新加入
+// 已有的成员关系保留原来的来源；有管理员分配成员的分组不能订阅
+func SubscribeGroup(ctx context.Context, db *sql.DB, deviceID string, group string, actor AuditActor) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin group membership transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 先锁分组再锁设备行，与 AddGroupMembers 的加锁顺序一致；并发订阅时按顺序检查上限
+	members, managed, err := lockGroup(ctx, tx, group)
+	if err != nil {
+		return false, err
+	}
+	var subscriptions int
+	err = tx.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM device_group_members m
+		        WHERE m.device_id = d.device_id AND m.source = $2)
+		FROM devices d
+		WHERE d.device_id = $1 AND d.is_active = true
+		FOR UPDATE
+	`, deviceID, GroupMemberSourceDevice).Scan(&subscriptions)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrDeviceNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("lock device for subscription: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO device_group_members (group_name, device_id, source)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (group_name, device_id) DO NOTHING
+	`, group, deviceID, GroupMemberSourceDevice)
+	if err != nil {
+		return false, fmt.Errorf("subscribe group: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("subscribe group: %w", err)
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	if managed {
+		return false, ErrGroupManaged
+	}
+	if members >= MaxGroupMembers {
+		return false, ErrGroupFull
+	}
+	if subscriptions >= MaxDeviceSubscriptions {
+		return false, ErrTooManySubscriptions
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditGroupSubscribe, deviceID, map[string]interface{}{"group": group})); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit group membership transaction: %w", err)
+	}
+	return true, nil
+}
+
+// UnsubscribeGroup 取消设备自行订阅的话题，返回原来是否已订阅
+// 管理员分配的成员关系不能由设备移除
+func UnsubscribeGroup(ctx context.Context, db *sql.DB, deviceID string, group string, actor AuditActor) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin group membership transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var source string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM device_group_members
+		WHERE group_name = $1 AND device_id = $2 AND source = $3
+		RETURNING source
+	`, group, deviceID, GroupMemberSourceDevice).Scan(&source)
+	if err == nil {
+		if err := RecordAuditEvent(ctx, tx, actor.Event(AuditGroupUnsubscribe, deviceID, map[string]interface{}{"group": group})); err != nil {
+			return false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return false, fmt.Errorf("commit group membership transaction: %w", err)
+		}
+		return true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("unsubscribe group: %w", err)
+	}
+
+	var assigned bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM device_group_members WHERE group_name = $1 AND device_id = $2)
+	`, group, deviceID).Scan(&assigned); err != nil {
+		return false, fmt.Errorf("query group membership: %w", err)
+	}
+	if assigned {
+		return false, ErrGroupMembershipManaged
+	}
+	return false, nil
+}
+
+// ListDeviceGroups 按名称返回设备所在的所有分组
+func ListDeviceGroups(ctx context.Context, db *sql.DB, deviceID string) ([]GroupMembership, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT group_name, source,
+		       to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')
+		FROM device_group_members
+		WHERE device_id = $1
+		ORDER BY group_name
+	`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("query device groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []GroupMembership{}
+	for rows.Next() {
+		var membership GroupMembership
+		if err := rows.Scan(&membership.Group, &membership.Source, &membership.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan device group: %w", err)
+		}
+		groups = append(groups, membership)
+	}
+	return groups, rows.Err()
+}
+
+// ListGroups 按名称返回所有至少有一个成员的分组
+func ListGroups(ctx context.Context, db *sql.DB) ([]GroupSummary, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT group_name, COUNT(*),
+		       COUNT(*) FILTER (WHERE source = $1),
+		       COUNT(*) FILTER (WHERE source = $2)
+		FROM device_group_members
+		GROUP BY group_name
+		ORDER BY group_name
+	`, GroupMemberSourceAdmin, GroupMemberSourceDevice)
+	if err != nil {
+		return nil, fmt.Errorf("query groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []GroupSummary{}
+	for rows.Next() {
+		var group GroupSummary
+		if err := rows.Scan(&group.Name, &group.MemberCount, &group.AssignedCount, &group.SubscribedCount); err != nil {
+			return nil, fmt.Errorf("scan group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// ListGroupMembers 返回分组中的设备，先加入的在前
+func ListGroupMembers(ctx context.Context, db *sql.DB, group string) ([]GroupMember, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.device_id, COALESCE(d.device_type, ''), COALESCE(d.is_active, false), m.source,
+		       to_char(m.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')
+		FROM device_group_members m
+		JOIN devices d ON d.device_id = m.device_id
+		WHERE m.group_name = $1
+		ORDER BY m.created_at, m.device_id
+	`, group)
+	if err != nil {
+		return nil, fmt.Errorf("query group members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []GroupMember{}
+	for rows.Next() {
+		var member GroupMember
+		if err := rows.Scan(&member.DeviceID, &member.DeviceType, &member.IsActive, &member.Source, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan group member: %w", err)
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// ListGroupPushTargets 返回分组推送的活跃成员
+// 在有上限之前就超过 MaxGroupMembers 的分组返回 ErrGroupFull，不在一次请求内推送
+func ListGroupPushTargets(ctx context.Context, db *sql.DB, group string) ([]GroupPushTarget, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.device_id, d.push_token, COALESCE(d.public_key, ''), COALESCE(d.public_key_id, ''), d.privacy_level
+		FROM device_group_members m
+		JOIN devices d ON d.device_id = m.device_id
+		WHERE m.group_name = $1 AND d.is_active = true
+		ORDER BY m.created_at, d.device_id
+		LIMIT $2
+	`, group, MaxGroupMembers+1)
+	if err != nil {
+		return nil, fmt.Errorf("query group push targets: %w", err)
+	}
+	defer rows.Close()
+
+	targets := []GroupPushTarget{}
+	for rows.Next() {
+		var target GroupPushTarget
+		if err := rows.Scan(&target.DeviceID, &target.PushToken, &target.PublicKey, &target.PublicKeyID, &target.PrivacyLevel); err != nil {
+			return nil, fmt.Errorf("scan group push target: %w", err)
+		}
+		targets = append(targets, target)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) > MaxGroupMembers {
+		return nil, ErrGroupFull
+	}
+	return targets, nil
+}
+
+// lockGroup 在 tx 剩余的时间内串行化该分组的成员变更，返回成员数以及是否有管理员分配的成员
+func lockGroup(ctx context.Context, tx *sql.Tx, group string) (int64, bool, error) {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('device_group:' || $1))`, group); err != nil {
+		return 0, false, fmt.Errorf("lock group: %w", err)
+	}
+	var (
+		members int64
+		managed bool
+	)
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(BOOL_OR(source = $2), false)
+		FROM device_group_members
+		WHERE group_name = $1
+	`, group, GroupMemberSourceAdmin).Scan(&members, &managed); err != nil {
+		return 0, false, fmt.Errorf("count group members: %w", err)
+	}
+	return members, managed, nil
+}
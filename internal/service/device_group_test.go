@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSubscribeGroupRejectsOperatorManagedGroup(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	assigned := insertTestDevice(t, db)
+	outsider := insertTestDevice(t, db)
+
+	if _, err := AddGroupMembers(ctx, db, "ops", []string{assigned}, GroupMemberSourceAdmin, SystemAuditActor); err != nil {
+		t.Fatalf("AddGroupMembers returned error: %v", err)
+	}
+	if _, err := SubscribeGroup(ctx, db, outsider, "ops", SystemAuditActor); !errors.Is(err, ErrGroupManaged) {
+		t.Fatalf("SubscribeGroup error = %v, want ErrGroupManaged", err)
+	}
+	if subscribed, err := SubscribeGroup(ctx, db, assigned, "ops", SystemAuditActor); err != nil || subscribed {
+		t.Fatalf("assigned member resubscribe = %v, %v, want false, nil", subscribed, err)
+	}
+
+	targets, err := ListGroupPushTargets(ctx, db, "ops")
+	if err != nil || len(targets) != 1 || targets[0].DeviceID != assigned {
+		t.Fatalf("group targets = %+v, %v, want only %s", targets, err, assigned)
+	}
+}
+
+func TestSubscribeGroupEnforcesSubscriptionLimit(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+
+	for i := 0; i < MaxDeviceSubscriptions; i++ {
+		if subscribed, err := SubscribeGroup(ctx, db, deviceID, fmt.Sprintf("topic-%d", i), SystemAuditActor); err != nil || !subscribed {
+			t.Fatalf("SubscribeGroup %d = %v, %v", i, subscribed, err)
+		}
+	}
+	if _, err := SubscribeGroup(ctx, db, deviceID, "one-too-many", SystemAuditActor); !errors.Is(err, ErrTooManySubscriptions) {
+		t.Fatalf("SubscribeGroup past the limit error = %v, want ErrTooManySubscriptions", err)
+	}
+
+	groups, err := ListDeviceGroups(ctx, db, deviceID)
+	if err != nil || len(groups) != MaxDeviceSubscriptions {
+		t.Fatalf("device has %d groups, %v, want %d", len(groups), err, MaxDeviceSubscriptions)
+	}
+}
+
+func TestGroupMembershipIsCapped(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO devices (device_id, push_token)
+		SELECT gen_random_uuid(), 'token-' || n FROM generate_series(1, $1) AS n
+	`, MaxGroupMembers); err != nil {
+		t.Fatalf("insert devices: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO device_group_members (group_name, device_id, source)
+		SELECT 'crowd', device_id, $1 FROM devices
+	`, GroupMemberSourceDevice); err != nil {
+		t.Fatalf("fill group: %v", err)
+	}
+
+	extra := insertTestDevice(t, db)
+	if _, err := SubscribeGroup(ctx, db, extra, "crowd", SystemAuditActor); !errors.Is(err, ErrGroupFull) {
+		t.Fatalf("SubscribeGroup to a full group error = %v, want ErrGroupFull", err)
+	}
+	if _, err := AddGroupMembers(ctx, db, "crowd", []string{extra}, GroupMemberSourceAdmin, SystemAuditActor); !errors.Is(err, ErrGroupFull) {
+		t.Fatalf("AddGroupMembers to a full group error = %v, want ErrGroupFull", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO device_group_members (group_name, device_id, source) VALUES ('crowd', $1, $2)
+	`, extra, GroupMemberSourceDevice); err != nil {
+		t.Fatalf("overfill group: %v", err)
+	}
+	if _, err := ListGroupPushTargets(ctx, db, "crowd"); !errors.Is(err, ErrGroupFull) {
+		t.Fatalf("ListGroupPushTargets for an oversized group error = %v, want ErrGroupFull", err)
+	}
+}
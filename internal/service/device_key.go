@@ -0,0 +1,107 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MinRSAPublicKeyBits 设备RSA公钥的最小模长
+const MinRSAPublicKeyBits = 2048
+
+// 设备公钥类型
+const (
+	PublicKeyTypeRSA    = "rsa"
+	PublicKeyTypeX25519 = "x25519"
+)
+
+var ErrInvalidPublicKey = errors.New("invalid public key")
+
+// DevicePublicKey 校验通过的设备公钥
+type DevicePublicKey struct {
+	PEM         string
+	Type        string
+	Bits        int
+	KeyID       string
+	Fingerprint string
+}
+
+// ParseDevicePublicKey 校验注册时上传的PEM格式PKIX公钥并计算指纹
+// RSA公钥使用消息加密方案v1，X25519公钥使用v2
+func ParseDevicePublicKey(publicKeyPEM string) (*DevicePublicKey, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(publicKeyPEM)))
+	if block == nil {
+		return nil, fmt.Errorf("%w: expected a PEM block", ErrInvalidPublicKey)
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%w: expected PEM type PUBLIC KEY (PKIX), got %s", ErrInvalidPublicKey, block.Type)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+
+	key := &DevicePublicKey{
+		PEM:         publicKeyPEM,
+		KeyID:       PublicKeyID(block.Bytes),
+		Fingerprint: PublicKeyFingerprint(block.Bytes),
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if err := validateRSAPublicKey(pub); err != nil {
+			return nil, err
+		}
+		key.Type = PublicKeyTypeRSA
+		key.Bits = pub.N.BitLen()
+	case *ecdh.PublicKey:
+		if pub.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("%w: unsupported ECDH curve, expected X25519", ErrInvalidPublicKey)
+		}
+		key.Type = PublicKeyTypeX25519
+		key.Bits = 256
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidPublicKey, pub)
+	}
+
+	return key, nil
+}
+
+func validateRSAPublicKey(pub *rsa.PublicKey) error {
+	if bits := pub.N.BitLen(); bits < MinRSAPublicKeyBits {
+		return fmt.Errorf("%w: RSA key must be at least %d bits, got %d", ErrInvalidPublicKey, MinRSAPublicKeyBits, bits)
+	}
+	if pub.E < 3 || pub.E%2 == 0 {
+		return fmt.Errorf("%w: RSA public exponent %d is not allowed", ErrInvalidPublicKey, pub.E)
+	}
+	return nil
+}
+
+// PublicKeyID 返回DER编码的SubjectPublicKeyInfo的SHA-256摘要的前16个十六进制字符，
+// 用于在同一设备内标识公钥
+func PublicKeyID(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PublicKeyFingerprint 返回 "SHA256:" 加上DER编码的SubjectPublicKeyInfo的SHA-256摘要（无填充base64）
+func PublicKeyFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// CryptoVersion 返回该公钥类型使用的消息加密方案版本
+func (k *DevicePublicKey) CryptoVersion() int {
+	if k.Type == PublicKeyTypeX25519 {
+		return MessageCryptoV2
+	}
+	return MessageCryptoV1
+}
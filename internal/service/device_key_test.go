@@ -0,0 +1,93 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseDevicePublicKeyAcceptsRSA2048(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	key, err := ParseDevicePublicKey(encodePublicKeyPEM(t, &privateKey.PublicKey))
+	if err != nil {
+		t.Fatalf("ParseDevicePublicKey returned error: %v", err)
+	}
+	if key.Type != PublicKeyTypeRSA || key.Bits != 2048 {
+		t.Fatalf("key = %+v, want rsa 2048", key)
+	}
+	if !strings.HasPrefix(key.Fingerprint, "SHA256:") || len(key.Fingerprint) != len("SHA256:")+43 {
+		t.Fatalf("fingerprint = %q", key.Fingerprint)
+	}
+	if len(key.KeyID) != 16 {
+		t.Fatalf("key id = %q, want 16 hex characters", key.KeyID)
+	}
+
+	again, err := ParseDevicePublicKey(encodePublicKeyPEM(t, &privateKey.PublicKey))
+	if err != nil {
+		t.Fatalf("ParseDevicePublicKey returned error: %v", err)
+	}
+	if again.KeyID != key.KeyID {
+		t.Fatalf("key id is not stable: %q != %q", again.KeyID, key.KeyID)
+	}
+}
+
+func TestParseDevicePublicKeyAcceptsX25519(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	key, err := ParseDevicePublicKey(encodePublicKeyPEM(t, privateKey.PublicKey()))
+	if err != nil {
+		t.Fatalf("ParseDevicePublicKey returned error: %v", err)
+	}
+	if key.Type != PublicKeyTypeX25519 || key.CryptoVersion() != MessageCryptoV2 {
+		t.Fatalf("key = %+v, want x25519 with crypto v2", key)
+	}
+}
+
+func TestParseDevicePublicKeyRejectsMalformedAndToyKeys(t *testing.T) {
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	invalidKeys := []string{
+		"",
+		"not a pem",
+		string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&smallKey.PublicKey)})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("garbage")})),
+		encodePublicKeyPEM(t, &smallKey.PublicKey),
+		encodePublicKeyPEM(t, &ecKey.PublicKey),
+	}
+
+	for _, invalidKey := range invalidKeys {
+		if _, err := ParseDevicePublicKey(invalidKey); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Fatalf("ParseDevicePublicKey(%q) error = %v, want ErrInvalidPublicKey", invalidKey, err)
+		}
+	}
+}
+
+func encodePublicKeyPEM(t *testing.T, pub interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey returned error: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
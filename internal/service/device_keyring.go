@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+)
+
+// KeyRotationChallengeTTL 公钥轮换挑战的有效期
+const KeyRotationChallengeTTL = 5 * time.Minute
+
+// KeyRotationChallengeTitle 标识解密后的内容为公钥轮换挑战
+const KeyRotationChallengeTitle = "key_rotation_challenge"
+
+var (
+	ErrDeviceNotFound       = errors.New("device not found")
+	ErrDeviceHasNoKey       = errors.New("device has no current public key")
+	ErrKeyChallengeInvalid  = errors.New("key rotation challenge is invalid or expired")
+	ErrKeyChallengeMismatch = errors.New("key rotation challenge response does not match")
+	ErrKeyChallengeStale    = errors.New("device key changed since the challenge was issued")
+	ErrKeyRotationSameKey   = errors.New("new public key is the current key")
+)
+
+// DeviceKey 设备公钥环中的一个公钥
+// 当前公钥没有 ValidUntil；已停用的公钥保留到用它加密的待接收消息全部确认或过期
+type DeviceKey struct {
+	KeyID               string  `json:"keyId"`
+	Fingerprint         string  `json:"fingerprint"`
+	CryptoVersion       int     `json:"cryptoVersion"`
+	ValidFrom           string  `json:"validFrom"`
+	ValidUntil          *string `json:"validUntil"`
+	PendingMessageCount int64   `json:"pendingMessageCount"`
+}
+
+// KeyRotationChallenge 用设备当前公钥加密的随机秘密，答出它即证明持有对应的私钥
+type KeyRotationChallenge struct {
+	ChallengeID        string `json:"challengeId"`
+	KeyID              string `json:"keyId"`
+	CryptoVersion      int    `json:"cryptoVersion"`
+	EnvelopeVersion    int    `json:"envelopeVersion"`
+	EncryptedAESKey    string `json:"encryptedAESKey"`
+	EphemeralPublicKey string `json:"ephemeralPublicKey,omitempty"`
+	EncryptedContent   string `json:"encryptedContent"`
+	IV                 string `json:"iv"`
+	ServerName         string `json:"serverName"`
+	CreatedAt          string `json:"createdAt"`
+	ExpiresAt          string `json:"expiresAt"`
+}
+
+// CurrentDeviceKey 推送方加密新消息所用的公钥
+type CurrentDeviceKey struct {
+	DeviceID      string `json:"deviceId"`
+	KeyID         string `json:"keyId"`
+	PublicKey     string `json:"publicKey"`
+	Fingerprint   string `json:"fingerprint"`
+	CryptoVersion int    `json:"cryptoVersion"`
+}
+
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// SetCurrentDeviceKey 将 key 加入设备公钥环作为当前公钥，并停用原来的当前公钥
+// 停用的公钥仍留在公钥环中，App 仍可解密用它加密的待接收消息；重复设置同一公钥不做任何改变
+func SetCurrentDeviceKey(ctx context.Context, db sqlExecutor, deviceID string, key *DevicePublicKey) error {
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO device_keys (device_id, key_id, public_key, fingerprint, crypto_version, valid_from)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (device_id, key_id) DO UPDATE
+		SET public_key = EXCLUDED.public_key,
+		    valid_from = CASE WHEN device_keys.valid_until IS NULL THEN device_keys.valid_from ELSE NOW() END,
+		    valid_until = NULL
+	`, deviceID, key.KeyID, key.PEM, key.Fingerprint, key.CryptoVersion()); err != nil {
+		return fmt.Errorf("insert device key: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE device_keys SET valid_until = NOW()
+		WHERE device_id = $1 AND key_id <> $2 AND valid_until IS NULL
+	`, deviceID, key.KeyID); err != nil {
+		return fmt.Errorf("retire previous device key: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE devices
+		SET public_key = $2, public_key_fingerprint = $3, public_key_id = $4, updated_at = NOW()
+		WHERE device_id = $1
+	`, deviceID, key.PEM, key.Fingerprint, key.KeyID); err != nil {
+		return fmt.Errorf("update device current key: %w", err)
+	}
+	return nil
+}
+
+// GetCurrentDeviceKey 返回活跃设备的当前公钥
+func GetCurrentDeviceKey(ctx context.Context, db *sql.DB, deviceID string) (*CurrentDeviceKey, error) {
+	key := CurrentDeviceKey{}
+	err := db.QueryRowContext(ctx, `
+		SELECT d.device_id::TEXT, k.key_id, k.public_key, k.fingerprint, k.crypto_version
+		FROM devices d
+		JOIN device_keys k ON k.device_id = d.device_id AND k.key_id = d.public_key_id
+		WHERE d.device_id = $1 AND d.is_active = true
+	`, deviceID).Scan(&key.DeviceID, &key.KeyID, &key.PublicKey, &key.Fingerprint, &key.CryptoVersion)
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceHasNoKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query current device key: %w", err)
+	}
+	return &key, nil
+}
+
+// ListDeviceKeys 返回设备公钥环，当前公钥在前
+func ListDeviceKeys(ctx context.Context, db *sql.DB, deviceID string) ([]DeviceKey, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT k.key_id, k.fingerprint, k.crypto_version,
+		       to_char(k.valid_from AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'),
+		       to_char(k.valid_until AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'),
+		       (SELECT COUNT(*) FROM pending_messages p
+		        WHERE p.device_id = k.device_id AND p.key_id = k.key_id
+		          AND p.delivered = false AND p.expires_at > NOW())
+		FROM device_keys k
+		WHERE k.device_id = $1
+		ORDER BY k.valid_until DESC NULLS FIRST, k.valid_from DESC
+	`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("query device keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []DeviceKey{}
+	for rows.Next() {
+		var (
+			key        DeviceKey
+			validUntil sql.NullString
+		)
+		if err := rows.Scan(&key.KeyID, &key.Fingerprint, &key.CryptoVersion,
+			&key.ValidFrom, &validUntil, &key.PendingMessageCount); err != nil {
+			return nil, fmt.Errorf("scan device key: %w", err)
+		}
+		if validUntil.Valid {
+			key.ValidUntil = &validUntil.String
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// CreateKeyRotationChallenge 用设备当前公钥加密随机挑战，信封格式与待接收消息相同
+func CreateKeyRotationChallenge(ctx context.Context, db *sql.DB, crypto *CryptoService, deviceID string, serverName string) (*KeyRotationChallenge, error) {
+	var publicKey, keyID sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT public_key, public_key_id FROM devices
+		WHERE device_id = $1 AND is_active = true
+	`, deviceID).Scan(&publicKey, &keyID)
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query device key: %w", err)
+	}
+	if publicKey.String == "" || keyID.String == "" {
+		return nil, ErrDeviceHasNoKey
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate key rotation challenge: %w", err)
+	}
+	response := base64.StdEncoding.EncodeToString(secret)
+
+	binding := NewMessageBinding(deviceID, uuid.NewString(), serverName, time.Now())
+	expiresAt := binding.CreatedAt.Add(KeyRotationChallengeTTL)
+	encrypted, err := crypto.EncryptMessage(publicKey.String, MessageContent{
+		Title:      KeyRotationChallengeTitle,
+		Content:    response,
+		ServerName: serverName,
+	}, binding)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt key rotation challenge: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO device_key_challenges (id, device_id, key_id, response_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, binding.MessageID, binding.DeviceID, keyID.String, hashChallengeResponse(response), binding.CreatedAt, expiresAt); err != nil {
+		return nil, fmt.Errorf("save key rotation challenge: %w", err)
+	}
+
+	return &KeyRotationChallenge{
+		ChallengeID:        binding.MessageID,
+		KeyID:              keyID.String,
+		CryptoVersion:      encrypted.CryptoVersion,
+		EnvelopeVersion:    encrypted.EnvelopeVersion,
+		EncryptedAESKey:    encrypted.EncryptedAESKey,
+		EphemeralPublicKey: encrypted.EphemeralPublicKey,
+		EncryptedContent:   encrypted.EncryptedContent,
+		IV:                 encrypted.IV,
+		ServerName:         serverName,
+		CreatedAt:          binding.CreatedAt.Format(MessageTimeLayout),
+		ExpiresAt:          expiresAt.Format(MessageTimeLayout),
+	}, nil
+}
+
+// RotateDeviceKey 校验轮换挑战的答案后用 newKey 替换设备当前公钥，每个挑战只能使用一次
+func RotateDeviceKey(ctx context.Context, db *sql.DB, deviceID string, challengeID string, response string, newKey *DevicePublicKey, actor AuditActor) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin key rotation transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 无论验证结果如何，挑战只能使用一次
+	var challengeKeyID, responseHash string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM device_key_challenges
+		WHERE id::TEXT = $1 AND device_id = $2 AND expires_at > NOW()
+		RETURNING key_id, response_hash
+	`, challengeID, deviceID).Scan(&challengeKeyID, &responseHash)
+	if err == sql.ErrNoRows {
+		return ErrKeyChallengeInvalid
+	}
+	if err != nil {
+		return fmt.Errorf("consume key rotation challenge: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashChallengeResponse(response)), []byte(responseHash)) != 1 {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit key rotation transaction: %w", err)
+		}
+		return ErrKeyChallengeMismatch
+	}
+
+	var currentKeyID sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT public_key_id FROM devices WHERE device_id = $1 FOR UPDATE
+	`, deviceID).Scan(&currentKeyID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrDeviceNotFound
+		}
+		return fmt.Errorf("lock device for key rotation: %w", err)
+	}
+	if currentKeyID.String != challengeKeyID {
+		return ErrKeyChallengeStale
+	}
+	if newKey.KeyID == challengeKeyID {
+		return ErrKeyRotationSameKey
+	}
+
+	if err := SetCurrentDeviceKey(ctx, tx, deviceID, newKey); err != nil {
+		return err
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditDeviceKeyRotate, deviceID, map[string]interface{}{"publicKeyId": newKey.KeyID})); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit key rotation transaction: %w", err)
+	}
+	return nil
+}
+
+func hashChallengeResponse(response string) string {
+	sum := sha256.Sum256([]byte(response))
+	return hex.EncodeToString(sum[:])
+}
+
+// BackfillDeviceKeys 把公钥环出现之前注册的设备的当前公钥写入 device_keys
+// 校验失败的公钥被跳过，其消息的公钥ID保持为空
+func BackfillDeviceKeys(ctx context.Context, db *sql.DB) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT device_id::TEXT, public_key FROM devices
+		WHERE public_key_id IS NULL AND public_key IS NOT NULL AND public_key <> ''
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("query devices without key id: %w", err)
+	}
+
+	type storedKey struct{ deviceID, publicKey string }
+	var devices []storedKey
+	for rows.Next() {
+		var device storedKey
+		if err := rows.Scan(&device.deviceID, &device.publicKey); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan device without key id: %w", err)
+		}
+		devices = append(devices, device)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("query devices without key id: %w", err)
+	}
+
+	backfilled := 0
+	for _, device := range devices {
+		key, err := ParseDevicePublicKey(device.publicKey)
+		if err != nil {
+			logger.Error("Device key backfill: device %s skipped: %v", device.deviceID, err)
+			continue
+		}
+		if err := SetCurrentDeviceKey(ctx, db, device.deviceID, key); err != nil {
+			return backfilled, err
+		}
+		backfilled++
+	}
+	return backfilled, nil
+}
+
+// drainedDeviceKeyCleanupSQL 删除已没有待接收消息的停用公钥，以及过期未答的轮换挑战
+const drainedDeviceKeyCleanupSQL = `
+DELETE FROM device_keys k
+WHERE k.valid_until IS NOT NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM pending_messages p
+    WHERE p.device_id = k.device_id AND p.key_id = k.key_id AND p.delivered = false
+  )
+`
+
+const expiredKeyChallengeCleanupSQL = `
+DELETE FROM device_key_challenges WHERE expires_at < NOW()
+`
+
+// CleanDrainedDeviceKeys 删除待接收消息已全部确认或过期的停用公钥
+func CleanDrainedDeviceKeys(ctx context.Context, db *sql.DB) (int64, error) {
+	if _, err := db.ExecContext(ctx, expiredKeyChallengeCleanupSQL); err != nil {
+		return 0, err
+	}
+
+	result, err := db.ExecContext(ctx, drainedDeviceKeyCleanupSQL)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
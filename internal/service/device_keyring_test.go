@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDrainedDeviceKeyCleanupOnlyRemovesRetiredKeys(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO device_keys (device_id, key_id, public_key, fingerprint, valid_until)
+		VALUES ($1, 'current', 'pem', 'fp', NULL),
+		       ($1, 'retired-pending', 'pem', 'fp', NOW()),
+		       ($1, 'retired-drained', 'pem', 'fp', NOW())
+	`, deviceID); err != nil {
+		t.Fatalf("insert device keys: %v", err)
+	}
+	insertTestMessage(t, db, deviceID, "retired-pending")
+	delivered := insertTestMessage(t, db, deviceID, "retired-drained")
+	if _, err := db.ExecContext(ctx, `UPDATE pending_messages SET delivered = true WHERE id::TEXT = $1`, delivered); err != nil {
+		t.Fatalf("confirm message: %v", err)
+	}
+
+	removed, err := CleanDrainedDeviceKeys(ctx, db)
+	if err != nil {
+		t.Fatalf("CleanDrainedDeviceKeys returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("removed %d keys, want 1", removed)
+	}
+
+	keys, err := ListDeviceKeys(ctx, db, deviceID)
+	if err != nil {
+		t.Fatalf("ListDeviceKeys returned error: %v", err)
+	}
+	var remaining []string
+	for _, key := range keys {
+		remaining = append(remaining, key.KeyID)
+	}
+	sort.Strings(remaining)
+	if strings.Join(remaining, ",") != "current,retired-pending" {
+		t.Fatalf("remaining keys = %v, want current and retired-pending", remaining)
+	}
+}
+
+func TestHashChallengeResponse(t *testing.T) {
+	hash := hashChallengeResponse("response")
+	if len(hash) != 64 {
+		t.Fatalf("hash length = %d, want 64", len(hash))
+	}
+	if hash == hashChallengeResponse("response ") {
+		t.Fatal("different responses must not share a hash")
+	}
+}
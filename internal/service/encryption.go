@@ -3,46 +3,201 @@ package service
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"strings"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/config"
 )
 
+// pushTokenIndexLabel 用于从 TokenIndexKeyID 指定的加密密钥派生盲索引密钥
+const pushTokenIndexLabel = "dengdeng/push-token-index/v1"
+
+// pushTokenProofLabel 用于从盲索引密钥派生Push Token持有证明的HMAC密钥
+const pushTokenProofLabel = "dengdeng/push-token-proof/v1"
+
+// PushTokenProofWindow Push Token持有证明的时间窗口，上一个窗口签发的证明仍然有效
+const PushTokenProofWindow = 10 * time.Minute
+
+// LegacyEncryptionKeyID 是 PUSH_TOKEN_ENCRYPTION_KEY 在密钥环中的ID，
+// 没有密钥ID前缀的旧密文也使用该密钥解密
+const LegacyEncryptionKeyID = "legacy"
+
 // EncryptionService Push Token加密服务
+// 密文格式为 "<密钥ID>:<base64(nonce+密文)>"，便于密钥轮换
 type EncryptionService struct {
-	key []byte // 32字节密钥（AES-256）
+	keys        map[string][]byte // 密钥ID -> 32字节密钥（AES-256）
+	keyOrder    []string          // 解密无前缀旧密文时的尝试顺序
+	activeKeyID string            // 用于加密的密钥ID
+	indexKey    []byte            // 盲索引HMAC密钥
 }
 
 // NewEncryptionService 创建加密服务
-func NewEncryptionService(keyStr string) (*EncryptionService, error) {
-	var key []byte
+// 密钥环由 EncryptionKey（ID为legacy）和 EncryptionKeys（"ID:密钥"）组成，
+// 只有 ActiveEncryptionKeyID 对应的密钥用于加密，其余密钥仅用于解密。
+// 盲索引密钥必须显式配置：TokenIndexKey，或用 TokenIndexKeyID 指定从哪个加密密钥派生，
+// 避免密钥环顺序变化时盲索引悄悄改变，导致设备去重失效。
+func NewEncryptionService(cfg config.SecurityConfig) (*EncryptionService, error) {
+	s := &EncryptionService{keys: make(map[string][]byte)}
+
+	if cfg.EncryptionKey != "" {
+		key, err := parseAESKey(cfg.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key must be 32 bytes (raw string or base64 encoded)")
+		}
+		s.addKey(LegacyEncryptionKeyID, key)
+	}
+
+	for _, entry := range cfg.EncryptionKeys {
+		id, keyStr, ok := strings.Cut(entry, ":")
+		if !ok || !isValidEncryptionKeyID(id) {
+			return nil, fmt.Errorf("encryption keyring entries must look like <id>:<key>, id is 1-16 letters, digits, '-' or '_'")
+		}
+		if _, exists := s.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate encryption key id %q", id)
+		}
+		key, err := parseAESKey(keyStr)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes (raw string or base64 encoded)", id)
+		}
+		s.addKey(id, key)
+	}
+
+	if len(s.keyOrder) == 0 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes (raw string or base64 encoded)")
+	}
+
+	s.activeKeyID = cfg.ActiveEncryptionKeyID
+	if s.activeKeyID == "" {
+		s.activeKeyID = s.keyOrder[0]
+	}
+	if _, ok := s.keys[s.activeKeyID]; !ok {
+		return nil, fmt.Errorf("active encryption key id %q is not in the keyring", s.activeKeyID)
+	}
 
+	switch {
+	case cfg.TokenIndexKey != "":
+		indexKey, err := parseAESKey(cfg.TokenIndexKey)
+		if err != nil {
+			return nil, fmt.Errorf("push token index key must be 32 bytes (raw string or base64 encoded)")
+		}
+		s.indexKey = indexKey
+	case cfg.TokenIndexKeyID != "":
+		key, ok := s.keys[cfg.TokenIndexKeyID]
+		if !ok {
+			return nil, fmt.Errorf("push token index key id %q is not in the keyring", cfg.TokenIndexKeyID)
+		}
+		s.indexKey = deriveKey(key, pushTokenIndexLabel)
+	default:
+		return nil, fmt.Errorf("push token index key is not configured: set PUSH_TOKEN_INDEX_KEY, or PUSH_TOKEN_INDEX_KEY_ID=%s to keep the index derived by earlier versions", LegacyEncryptionKeyID)
+	}
+
+	return s, nil
+}
+
+func (s *EncryptionService) addKey(id string, key []byte) {
+	s.keys[id] = key
+	s.keyOrder = append(s.keyOrder, id)
+}
+
+// parseAESKey 解析32字节密钥（base64编码或原始字符串）
+func parseAESKey(keyStr string) ([]byte, error) {
 	// 尝试base64解码
 	decoded, err := base64.StdEncoding.DecodeString(keyStr)
 	if err == nil && len(decoded) == 32 {
 		// 成功解码且长度为32字节，使用解码后的密钥
-		key = decoded
-	} else if len(keyStr) == 32 {
+		return decoded, nil
+	}
+	if len(keyStr) == 32 {
 		// 直接是32字节的原始字符串
-		key = []byte(keyStr)
-	} else {
-		return nil, fmt.Errorf("encryption key must be 32 bytes (raw string or base64 encoded)")
+		return []byte(keyStr), nil
 	}
+	return nil, fmt.Errorf("key must be 32 bytes")
+}
 
-	return &EncryptionService{
-		key: key,
-	}, nil
+func isValidEncryptionKeyID(id string) bool {
+	if id == "" || len(id) > 16 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if isASCIILetterOrDigit(c) || c == '-' || c == '_' {
+			continue
+		}
+		return false
+	}
+	return true
 }
 
-// Encrypt 加密Push Token
-func (s *EncryptionService) Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(s.key)
-	if err != nil {
-		return "", err
+func isASCIILetterOrDigit(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// ActiveKeyID 返回当前用于加密的密钥ID
+func (s *EncryptionService) ActiveKeyID() string {
+	return s.activeKeyID
+}
+
+// KeyIDs 返回密钥环中的所有密钥ID
+func (s *EncryptionService) KeyIDs() []string {
+	return append([]string(nil), s.keyOrder...)
+}
+
+// IsActiveCiphertext 判断密文是否已使用当前密钥加密
+func (s *EncryptionService) IsActiveCiphertext(ciphertext string) bool {
+	return strings.HasPrefix(ciphertext, s.activeKeyID+":")
+}
+
+// BlindIndex 计算Push Token的盲索引（HMAC-SHA256，十六进制）
+// 相同Token总是得到相同索引，用于查重和唯一约束，不可逆推出Token
+func (s *EncryptionService) BlindIndex(plaintext string) string {
+	mac := hmac.New(sha256.New, s.indexKey)
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PushTokenProof 计算设备接管一个Push Token所需的持有证明
+// 证明只通过推送下发到该Token，能回传证明即说明调用方确实持有该Token
+func (s *EncryptionService) PushTokenProof(deviceID string, pushToken string, now time.Time) string {
+	return s.pushTokenProof(deviceID, pushToken, pushTokenProofWindowIndex(now))
+}
+
+// VerifyPushTokenProof 校验持有证明，接受当前和上一个时间窗口签发的证明
+func (s *EncryptionService) VerifyPushTokenProof(deviceID string, pushToken string, proof string, now time.Time) bool {
+	window := pushTokenProofWindowIndex(now)
+	for _, w := range []int64{window, window - 1} {
+		if hmac.Equal([]byte(proof), []byte(s.pushTokenProof(deviceID, pushToken, w))) {
+			return true
+		}
 	}
+	return false
+}
+
+func (s *EncryptionService) pushTokenProof(deviceID string, pushToken string, window int64) string {
+	mac := hmac.New(sha256.New, deriveKey(s.indexKey, pushTokenProofLabel))
+	fmt.Fprintf(mac, "%s\x00%s\x00%d", strings.ToLower(deviceID), pushToken, window)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
 
-	gcm, err := cipher.NewGCM(block)
+func pushTokenProofWindowIndex(now time.Time) int64 {
+	return now.Unix() / int64(PushTokenProofWindow/time.Second)
+}
+
+// Encrypt 使用当前密钥加密Push Token
+func (s *EncryptionService) Encrypt(plaintext string) (string, error) {
+	gcm, err := newGCM(s.keys[s.activeKeyID])
 	if err != nil {
 		return "", err
 	}
@@ -53,22 +208,47 @@ func (s *EncryptionService) Encrypt(plaintext string) (string, error) {
 	}
 
 	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return s.activeKeyID + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
 // Decrypt 解密Push Token
+// 带密钥ID前缀的密文使用对应密钥；无前缀的旧密文依次尝试密钥环中的密钥
 func (s *EncryptionService) Decrypt(ciphertext string) (string, error) {
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	// base64字母表不包含':'，因此可以据此区分是否带有密钥ID
+	if id, encoded, ok := strings.Cut(ciphertext, ":"); ok {
+		key, exists := s.keys[id]
+		if !exists {
+			return "", fmt.Errorf("unknown encryption key id %q", id)
+		}
+		return decryptWithKey(key, encoded)
+	}
+
+	var lastErr error
+	for _, id := range s.keyOrder {
+		plaintext, err := decryptWithKey(s.keys[id], ciphertext)
+		if err == nil {
+			return plaintext, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	block, err := aes.NewCipher(s.key)
+func decryptWithKey(key []byte, encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		return "", err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
@@ -78,9 +258,7 @@ func (s *EncryptionService) Decrypt(ciphertext string) (string, error) {
 	}
 
 	nonce := data[:gcm.NonceSize()]
-	ciphertext = string(data[gcm.NonceSize():])
-
-	plaintext, err := gcm.Open(nil, nonce, []byte(ciphertext), nil)
+	plaintext, err := gcm.Open(nil, nonce, data[gcm.NonceSize():], nil)
 	if err != nil {
 		return "", err
 	}
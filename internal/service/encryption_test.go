@@ -0,0 +1,141 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/config"
+)
+
+const (
+	testEncryptionKey    = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=" // base64("0123456789abcdef0123456789abcdef")
+	testRotatedKeyString = "fedcba9876543210fedcba9876543210"
+)
+
+func TestBlindIndexIsDeterministicWhileCiphertextIsNot(t *testing.T) {
+	enc, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKeyID: LegacyEncryptionKeyID})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+
+	first, _ := enc.Encrypt("push-token")
+	second, _ := enc.Encrypt("push-token")
+	if first == second {
+		t.Fatal("ciphertexts must differ because of the random nonce")
+	}
+	if enc.BlindIndex("push-token") != enc.BlindIndex("push-token") {
+		t.Fatal("blind index must be deterministic")
+	}
+	if enc.BlindIndex("push-token") == enc.BlindIndex("other-token") {
+		t.Fatal("different tokens must have different blind indexes")
+	}
+}
+
+func TestBlindIndexDependsOnIndexKey(t *testing.T) {
+	derived, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKeyID: LegacyEncryptionKeyID})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+	explicit, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKey: testRotatedKeyString})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+
+	if derived.BlindIndex("push-token") == explicit.BlindIndex("push-token") {
+		t.Fatal("explicit index key must change the blind index")
+	}
+	if _, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKey: "short"}); err == nil {
+		t.Fatal("NewEncryptionService accepted an invalid index key")
+	}
+}
+
+func TestKeyRotationKeepsOldCiphertextReadable(t *testing.T) {
+	old, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKeyID: LegacyEncryptionKeyID})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+	oldCiphertext, _ := old.Encrypt("push-token")
+	if !strings.HasPrefix(oldCiphertext, LegacyEncryptionKeyID+":") {
+		t.Fatalf("ciphertext %q is not prefixed with the key id", oldCiphertext)
+	}
+
+	rotated, err := NewEncryptionService(config.SecurityConfig{
+		EncryptionKey:         testEncryptionKey,
+		EncryptionKeys:        []string{"k2:" + testRotatedKeyString},
+		ActiveEncryptionKeyID: "k2",
+		TokenIndexKeyID:       LegacyEncryptionKeyID,
+	})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+
+	if plaintext, err := rotated.Decrypt(oldCiphertext); err != nil || plaintext != "push-token" {
+		t.Fatalf("Decrypt(old) = %q, %v", plaintext, err)
+	}
+	if rotated.IsActiveCiphertext(oldCiphertext) {
+		t.Fatal("old ciphertext must be reported for re-encryption")
+	}
+
+	newCiphertext, _ := rotated.Encrypt("push-token")
+	if !rotated.IsActiveCiphertext(newCiphertext) {
+		t.Fatalf("new ciphertext %q is not encrypted with the active key", newCiphertext)
+	}
+	if rotated.BlindIndex("push-token") != old.BlindIndex("push-token") {
+		t.Fatal("rotating the encryption key must not change the blind index")
+	}
+}
+
+func TestDecryptAcceptsUnprefixedLegacyCiphertext(t *testing.T) {
+	enc, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKeyID: LegacyEncryptionKeyID})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+	ciphertext, _ := enc.Encrypt("push-token")
+	unprefixed := strings.TrimPrefix(ciphertext, LegacyEncryptionKeyID+":")
+
+	if plaintext, err := enc.Decrypt(unprefixed); err != nil || plaintext != "push-token" {
+		t.Fatalf("Decrypt(unprefixed) = %q, %v", plaintext, err)
+	}
+}
+
+func TestNewEncryptionServiceRejectsBadKeyring(t *testing.T) {
+	configs := []config.SecurityConfig{
+		{},
+		{EncryptionKey: testEncryptionKey, EncryptionKeys: []string{"no-separator"}},
+		{EncryptionKey: testEncryptionKey, EncryptionKeys: []string{"legacy:" + testRotatedKeyString}},
+		{EncryptionKey: testEncryptionKey, ActiveEncryptionKeyID: "missing", TokenIndexKeyID: LegacyEncryptionKeyID},
+		{EncryptionKey: testEncryptionKey},
+		{EncryptionKey: testEncryptionKey, TokenIndexKeyID: "missing"},
+	}
+
+	for _, cfg := range configs {
+		if _, err := NewEncryptionService(cfg); err == nil {
+			t.Fatalf("NewEncryptionService(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestPushTokenProofIsBoundToDeviceTokenAndWindow(t *testing.T) {
+	enc, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKeyID: LegacyEncryptionKeyID})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+
+	const deviceID = "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61"
+	issued := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	proof := enc.PushTokenProof(deviceID, "push-token", issued)
+
+	if !enc.VerifyPushTokenProof(strings.ToUpper(deviceID), "push-token", proof, issued.Add(PushTokenProofWindow)) {
+		t.Fatal("proof must stay valid during the next window")
+	}
+	if enc.VerifyPushTokenProof(deviceID, "push-token", proof, issued.Add(2*PushTokenProofWindow)) {
+		t.Fatal("proof must expire after two windows")
+	}
+	if enc.VerifyPushTokenProof("6f1c2b9e-0a4d-4b57-9a8e-3c2d1e0f9b88", "push-token", proof, issued) {
+		t.Fatal("proof must not be valid for another device")
+	}
+	if enc.VerifyPushTokenProof(deviceID, "other-token", proof, issued) {
+		t.Fatal("proof must not be valid for another push token")
+	}
+}
@@ -19,6 +19,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// MaxBatchPushTokens 单次推送请求最多携带的Push Token数
+const MaxBatchPushTokens = 1000
+
 // HuaweiPushService 华为Push Kit v3推送服务
 type HuaweiPushService struct {
 	config      config.HuaweiPushConfig
@@ -55,8 +58,8 @@ func NewHuaweiPushService(cfg config.HuaweiPushConfig) (*HuaweiPushService, erro
 	logger.Info("Initializing Huawei Push Service...")
 	logger.Debug("  Service Account File: %s", cfg.ServiceAccountFile)
 
-	// 从服务账号密钥文件读取配置
-	privateKey, keyID, subAccount, projectID, err := loadServiceAccount(cfg.ServiceAccountFile)
+	// 从服务账号密钥读取配置（文件 > 环境变量 > 嵌入，由config解析）
+	privateKey, keyID, subAccount, projectID, err := loadServiceAccount(cfg.ServiceAccountJSON)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load service account: %w", err)
 	}
@@ -78,18 +81,15 @@ func NewHuaweiPushService(cfg config.HuaweiPushConfig) (*HuaweiPushService, erro
 	}, nil
 }
 
-// loadServiceAccount 从嵌入的配置加载服务账号
-func loadServiceAccount(filePath string) (*rsa.PrivateKey, string, string, string, error) {
+// loadServiceAccount 解析服务账号密钥JSON
+func loadServiceAccount(serviceAccountJSON string) (*rsa.PrivateKey, string, string, string, error) {
 	logger.Debug("Loading service account...")
 
-	// 使用嵌入的配置（编译时注入）
-	embeddedJSON := config.GetEmbeddedPrivateJSON()
-	if embeddedJSON == "" {
-		return nil, "", "", "", fmt.Errorf("embedded service account configuration is empty")
+	if serviceAccountJSON == "" {
+		return nil, "", "", "", fmt.Errorf("service account configuration is empty, set HUAWEI_SERVICE_ACCOUNT_FILE or embed it at build time")
 	}
 
-	logger.Debug("Using embedded service account configuration")
-	data := []byte(embeddedJSON)
+	data := []byte(serviceAccountJSON)
 
 	var config ServiceAccountConfig
 	if err := json.Unmarshal(data, &config); err != nil {
@@ -165,6 +165,7 @@ type Notification struct {
 	InboxContent []string    `json:"inboxContent,omitempty"` // 收件箱样式内容数组
 	Badge        *Badge      `json:"badge,omitempty"`        // 通知消息角标
 	Sound        string      `json:"sound,omitempty"`        // 自定义铃声
+	NotifyID     int32       `json:"notifyId,omitempty"`     // 通知ID，相同ID的通知会被覆盖，也用于撤回
 }
 
 // ClickAction 点击行为
@@ -223,10 +224,51 @@ type PushResponse struct {
 	RequestID string `json:"requestId"`
 }
 
+// 推送响应码
+const (
+	pushCodeSuccess        = "80000000"
+	pushCodePartialSuccess = "80100000" // 部分Token发送成功，msg 中列出失败的Token
+)
+
+// partialPushResult 部分成功时 msg 字段的内容
+type partialPushResult struct {
+	Success       int      `json:"success"`
+	Failure       int      `json:"failure"`
+	IllegalTokens []string `json:"illegal_tokens"`
+}
+
+// PartialPushError 批量推送部分成功：IllegalTokens 中的Token未送达，其余Token已发送
+type PartialPushError struct {
+	Success       int
+	Failure       int
+	IllegalTokens []string
+}
+
+func (e *PartialPushError) Error() string {
+	return fmt.Sprintf("push partially failed: success=%d, failure=%d", e.Success, e.Failure)
+}
+
 // SendNotification 发送通知消息（Alert）
-func (s *HuaweiPushService) SendNotification(pushToken, title, body string, data map[string]interface{}) error {
+// notifyID 为0时由华为推送服务自动生成，无法覆盖或撤回
+func (s *HuaweiPushService) SendNotification(pushToken string, notifyID int32, title, body string, data map[string]interface{}) error {
 	logger.Debug("Sending notification: title=%s, body=%s, token=%s...", title, body, pushToken[:20])
 
+	// 构建通知消息payload
+	payload := AlertPayload{
+		Notification: alertNotification(notifyID, title, body, data),
+	}
+
+	// 默认使用测试消息选项
+	options := &PushOptions{
+		TestMessage: false,
+		TTL:         86400, // 1天
+	}
+
+	return s.sendPush(0, []string{pushToken}, payload, options)
+}
+
+// alertNotification 构建通知栏消息，单发和批量发送共用
+func alertNotification(notifyID int32, title, body string, data map[string]interface{}) Notification {
 	// 构建点击行为
 	clickAction := ClickAction{
 		ActionType: 0, // 0: 打开应用首页
@@ -244,6 +286,7 @@ func (s *HuaweiPushService) SendNotification(pushToken, title, body string, data
 		Body:        body,
 		ClickAction: clickAction,
 		Badge:       &Badge{AddNum: 1}, // 默认角标加1
+		NotifyID:    notifyID,
 	}
 
 	// 判断body是否包含\n（支持URL编码的\n和实际的换行符）
@@ -261,18 +304,7 @@ func (s *HuaweiPushService) SendNotification(pushToken, title, body string, data
 		notification.Body = processedBody
 	}
 
-	// 构建通知消息payload
-	payload := AlertPayload{
-		Notification: notification,
-	}
-
-	// 默认使用测试消息选项
-	options := &PushOptions{
-		TestMessage: false,
-		TTL:         86400, // 1天
-	}
-
-	return s.sendPush(0, []string{pushToken}, payload, options)
+	return notification
 }
 
 // SendFormUpdate 发送卡片刷新消息
@@ -322,30 +354,16 @@ func (s *HuaweiPushService) SendVoIPCall(pushToken string, extraData string) err
 	return s.sendPush(10, []string{pushToken}, payload, options)
 }
 
-// SendBatchNotification 批量发送通知消息
-func (s *HuaweiPushService) SendBatchNotification(pushTokens []string, title, body string, data map[string]interface{}) error {
-	if len(pushTokens) > 1000 {
-		return fmt.Errorf("batch size exceeds limit: %d (max 1000)", len(pushTokens))
-	}
-
-	// 构建点击行为
-	clickAction := ClickAction{
-		ActionType: 0, // 0: 打开应用首页
-	}
-
-	if data != nil {
-		clickAction.Data = data
+// SendBatchNotification 批量发送通知消息，所有设备收到相同的通知
+// notifyID 为0时由华为推送服务自动生成
+func (s *HuaweiPushService) SendBatchNotification(pushTokens []string, notifyID int32, title, body string, data map[string]interface{}) error {
+	if len(pushTokens) > MaxBatchPushTokens {
+		return fmt.Errorf("batch size exceeds limit: %d (max %d)", len(pushTokens), MaxBatchPushTokens)
 	}
 
 	// 构建通知消息payload
 	payload := AlertPayload{
-		Notification: Notification{
-			Category:    "WORK",
-			Title:       "[工作提醒]" + title,
-			Body:        body,
-			ClickAction: clickAction,
-			Badge:       &Badge{AddNum: 1}, // 默认角标加1
-		},
+		Notification: alertNotification(notifyID, title, body, data),
 	}
 
 	options := &PushOptions{
@@ -356,6 +374,19 @@ func (s *HuaweiPushService) SendBatchNotification(pushTokens []string, title, bo
 	return s.sendPush(0, pushTokens, payload, options)
 }
 
+// SendBatchBackgroundMessage 批量发送后台消息
+func (s *HuaweiPushService) SendBatchBackgroundMessage(pushTokens []string, extraData string) error {
+	if len(pushTokens) > MaxBatchPushTokens {
+		return fmt.Errorf("batch size exceeds limit: %d (max %d)", len(pushTokens), MaxBatchPushTokens)
+	}
+
+	payload := BackgroundPayload{
+		ExtraData: extraData,
+	}
+
+	return s.sendPush(6, pushTokens, payload, nil)
+}
+
 // sendPush 通用推送方法
 func (s *HuaweiPushService) sendPush(pushType int, tokens []string, payload interface{}, options *PushOptions) error {
 	logger.Debug("sendPush: type=%d, tokens=%d", pushType, len(tokens))
@@ -377,6 +408,25 @@ func (s *HuaweiPushService) sendPush(pushType int, tokens []string, payload inte
 		PushOptions: options,
 	}
 
+	return s.postPushAPI("messages:send", jwtToken, map[string]string{"push-type": strconv.Itoa(pushType)}, requestBody)
+}
+
+// RevokeNotification 撤回已发送到设备的通知（按 notifyId），设备离线时在上线后撤回
+func (s *HuaweiPushService) RevokeNotification(pushToken string, notifyID int32) error {
+	jwtToken, err := s.getAccessToken()
+	if err != nil {
+		return fmt.Errorf("failed to get JWT token: %w", err)
+	}
+
+	requestBody := map[string]interface{}{
+		"notifyId": notifyID,
+		"token":    []string{pushToken},
+	}
+	return s.postPushAPI("messages:revoke", jwtToken, nil, requestBody)
+}
+
+// postPushAPI 调用 Push Kit 接口并检查响应码
+func (s *HuaweiPushService) postPushAPI(method string, jwtToken string, headers map[string]string, requestBody interface{}) error {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
@@ -384,7 +434,7 @@ func (s *HuaweiPushService) sendPush(pushType int, tokens []string, payload inte
 	logger.Debug("Request payload: %s", string(jsonData))
 
 	// 构建请求
-	url := fmt.Sprintf("%s/%s/messages:send", s.config.PushAPIURL, s.projectID)
+	url := fmt.Sprintf("%s/%s/%s", s.config.PushAPIURL, s.projectID, method)
 	logger.Debug("Push URL: %s", url)
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
@@ -394,7 +444,9 @@ func (s *HuaweiPushService) sendPush(pushType int, tokens []string, payload inte
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+jwtToken)
-	req.Header.Set("push-type", strconv.Itoa(pushType))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 
 	// 发送请求
 	logger.Debug("Sending push request to Huawei...")
@@ -420,15 +472,34 @@ func (s *HuaweiPushService) sendPush(pushType int, tokens []string, payload inte
 	}
 
 	// 检查响应状态
-	if pushResp.Code != "80000000" {
-		logger.Error("Push failed: code=%s, msg=%s, requestId=%s", pushResp.Code, pushResp.Msg, pushResp.RequestID)
-		return fmt.Errorf("push failed: code=%s, msg=%s", pushResp.Code, pushResp.Msg)
+	if err := pushResponseError(pushResp); err != nil {
+		return err
 	}
 
 	logger.Info("✓ Push sent successfully (requestId=%s)", pushResp.RequestID)
 	return nil
 }
 
+// pushResponseError 检查 Push Kit 响应码；部分成功时返回 *PartialPushError，
+// 由调用方只把失败的Token对应的设备标记为失败
+func pushResponseError(pushResp PushResponse) error {
+	switch pushResp.Code {
+	case pushCodeSuccess:
+		return nil
+	case pushCodePartialSuccess:
+		var result partialPushResult
+		if err := json.Unmarshal([]byte(pushResp.Msg), &result); err != nil {
+			logger.Error("Push partially failed with unreadable result: msg=%s, requestId=%s", pushResp.Msg, pushResp.RequestID)
+			return fmt.Errorf("push partially failed: msg=%s", pushResp.Msg)
+		}
+		logger.Error("Push partially failed: success=%d, failure=%d, requestId=%s", result.Success, result.Failure, pushResp.RequestID)
+		return &PartialPushError{Success: result.Success, Failure: result.Failure, IllegalTokens: result.IllegalTokens}
+	default:
+		logger.Error("Push failed: code=%s, msg=%s, requestId=%s", pushResp.Code, pushResp.Msg, pushResp.RequestID)
+		return fmt.Errorf("push failed: code=%s, msg=%s", pushResp.Code, pushResp.Msg)
+	}
+}
+
 // getAccessToken 生成JWT token作为访问令牌
 func (s *HuaweiPushService) getAccessToken() (string, error) {
 	s.tokenMutex.Lock()
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPushResponseErrorReportsRejectedTokens(t *testing.T) {
+	if err := pushResponseError(PushResponse{Code: "80000000"}); err != nil {
+		t.Fatalf("success code returned %v", err)
+	}
+
+	err := pushResponseError(PushResponse{
+		Code: "80100000",
+		Msg:  `{"success":2,"failure":1,"illegal_tokens":["bad-token"]}`,
+	})
+	var partial *PartialPushError
+	if !errors.As(err, &partial) {
+		t.Fatalf("partial success error = %v, want *PartialPushError", err)
+	}
+	if partial.Success != 2 || partial.Failure != 1 || len(partial.IllegalTokens) != 1 || partial.IllegalTokens[0] != "bad-token" {
+		t.Fatalf("partial = %+v", partial)
+	}
+
+	for _, resp := range []PushResponse{
+		{Code: "80100000", Msg: "Some tokens failed"},
+		{Code: "80300007", Msg: "All the tokens are invalid"},
+	} {
+		err := pushResponseError(resp)
+		if err == nil || errors.As(err, &partial) {
+			t.Fatalf("pushResponseError(%+v) = %v, want a whole-batch failure", resp, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"strings"
+	"time"
+)
+
+// 消息信封版本
+const (
+	MessageEnvelopeV1 = 1 // AEAD 不带关联数据（旧消息）
+	MessageEnvelopeV2 = 2 // AEAD 关联数据绑定设备、消息ID、服务器名称和创建时间
+)
+
+// messageAssociatedDataLabel 是信封v2关联数据的前缀，区分用途和版本
+const messageAssociatedDataLabel = "dengdeng/message-ad/v2"
+
+// MessageTimeLayout 是消息创建时间在API和关联数据中的格式（UTC，毫秒精度），
+// 与数据库查询中的 to_char(... 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') 一致
+const MessageTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// MessageBinding 是消息密文绑定的上下文
+// 密文只有在同一设备、同一消息ID、同一服务器和同一创建时间下才能解密，
+// 因此存储的密文无法被转投给其他设备或作为另一条消息重新插入
+type MessageBinding struct {
+	DeviceID   string
+	MessageID  string
+	ServerName string
+	CreatedAt  time.Time
+}
+
+// NewMessageBinding 为即将保存的新消息创建绑定上下文
+// 创建时间截断到毫秒，保证与客户端拿到的 createdAt 完全一致
+func NewMessageBinding(deviceID string, messageID string, serverName string, createdAt time.Time) MessageBinding {
+	return MessageBinding{
+		DeviceID:   strings.ToLower(deviceID),
+		MessageID:  strings.ToLower(messageID),
+		ServerName: serverName,
+		CreatedAt:  createdAt.UTC().Truncate(time.Millisecond),
+	}
+}
+
+// AssociatedData 返回AEAD关联数据：
+//
+//	dengdeng/message-ad/v2\n<device_id>\n<message_id>\n<created_at>\n<server_name>
+//
+// 服务器名称放在最后，因此其中包含换行也不会产生歧义
+func (b MessageBinding) AssociatedData() []byte {
+	return []byte(strings.Join([]string{
+		messageAssociatedDataLabel,
+		b.DeviceID,
+		b.MessageID,
+		b.CreatedAt.UTC().Format(MessageTimeLayout),
+		b.ServerName,
+	}, "\n"))
+}
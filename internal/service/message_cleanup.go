@@ -11,12 +11,13 @@ import (
 const expiredMessageCleanupSQL = `
 DELETE FROM pending_messages
 WHERE expires_at < NOW()
-   OR (delivered = true AND confirmed_at < NOW() - INTERVAL '24 hours')
+   OR (delivered = true AND confirmed_at <= NOW() - $1 * INTERVAL '1 second')
 `
 
-// CleanExpiredMessages removes pending messages that can no longer be delivered.
-func CleanExpiredMessages(ctx context.Context, db *sql.DB) (int64, error) {
-	result, err := db.ExecContext(ctx, expiredMessageCleanupSQL)
+// CleanExpiredMessages removes pending messages that can no longer be
+// delivered and confirmed messages older than confirmedRetention.
+func CleanExpiredMessages(ctx context.Context, db *sql.DB, confirmedRetention time.Duration) (int64, error) {
+	result, err := db.ExecContext(ctx, expiredMessageCleanupSQL, int64(confirmedRetention/time.Second))
 	if err != nil {
 		return 0, err
 	}
@@ -29,14 +30,21 @@ func CleanExpiredMessages(ctx context.Context, db *sql.DB) (int64, error) {
 	return rowsAffected, nil
 }
 
+// CleanupOptions configures the periodic cleanup job.
+type CleanupOptions struct {
+	Interval           time.Duration
+	AuditRetention     time.Duration // zero keeps audit events forever
+	ConfirmedRetention time.Duration // zero purges confirmed messages on the next run
+}
+
 // StartExpiredMessageCleanup runs cleanup immediately, then repeats on interval.
-func StartExpiredMessageCleanup(ctx context.Context, db *sql.DB, interval time.Duration) context.CancelFunc {
+func StartExpiredMessageCleanup(ctx context.Context, db *sql.DB, opts CleanupOptions) context.CancelFunc {
 	cleanupCtx, cancel := context.WithCancel(ctx)
 
 	go func() {
-		runCleanup(cleanupCtx, db)
+		runCleanup(cleanupCtx, db, opts)
 
-		ticker := time.NewTicker(interval)
+		ticker := time.NewTicker(opts.Interval)
 		defer ticker.Stop()
 
 		for {
@@ -44,7 +52,7 @@ func StartExpiredMessageCleanup(ctx context.Context, db *sql.DB, interval time.D
 			case <-cleanupCtx.Done():
 				return
 			case <-ticker.C:
-				runCleanup(cleanupCtx, db)
+				runCleanup(cleanupCtx, db, opts)
 			}
 		}
 	}()
@@ -52,8 +60,8 @@ func StartExpiredMessageCleanup(ctx context.Context, db *sql.DB, interval time.D
 	return cancel
 }
 
-func runCleanup(ctx context.Context, db *sql.DB) {
-	deleted, err := CleanExpiredMessages(ctx, db)
+func runCleanup(ctx context.Context, db *sql.DB, opts CleanupOptions) {
+	deleted, err := CleanExpiredMessages(ctx, db, opts.ConfirmedRetention)
 	if err != nil {
 		logger.Error("Expired pending message cleanup failed: %v", err)
 		return
@@ -61,4 +69,31 @@ func runCleanup(ctx context.Context, db *sql.DB) {
 	if deleted > 0 {
 		logger.Info("Expired pending message cleanup removed %d rows", deleted)
 	}
+
+	drained, err := CleanDrainedDeviceKeys(ctx, db)
+	if err != nil {
+		logger.Error("Drained device key cleanup failed: %v", err)
+		return
+	}
+	if drained > 0 {
+		logger.Info("Drained device key cleanup removed %d retired keys", drained)
+	}
+
+	stale, err := CleanStaleRecipients(ctx, db)
+	if err != nil {
+		logger.Error("Recipient pairing cleanup failed: %v", err)
+		return
+	}
+	if stale > 0 {
+		logger.Info("Recipient pairing cleanup removed %d rows", stale)
+	}
+
+	audited, err := CleanAuditEvents(ctx, db, opts.AuditRetention)
+	if err != nil {
+		logger.Error("Audit event retention cleanup failed: %v", err)
+		return
+	}
+	if audited > 0 {
+		logger.Info("Audit event retention cleanup removed %d events", audited)
+	}
 }
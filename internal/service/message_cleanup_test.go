@@ -1,15 +1,92 @@
 package service
 
 import (
+	"context"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
-func TestExpiredMessageCleanupSQLOnlyTouchesPendingMessages(t *testing.T) {
-	if !strings.Contains(expiredMessageCleanupSQL, "pending_messages") {
-		t.Fatal("cleanup query must delete from pending_messages")
+func TestCleanExpiredMessagesKeepsDeliverableAndRetainedMessages(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+
+	messages := map[string]string{}
+	for _, tc := range []struct {
+		name   string
+		update string
+	}{
+		{"pending", ``},
+		{"expired", `expires_at = NOW() - INTERVAL '1 minute'`},
+		{"confirmed-recent", `delivered = true, confirmed_at = NOW() - INTERVAL '10 minutes'`},
+		{"confirmed-old", `delivered = true, confirmed_at = NOW() - INTERVAL '2 hours'`},
+	} {
+		id := insertTestMessage(t, db, deviceID, "")
+		messages[id] = tc.name
+		if tc.update == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE pending_messages SET `+tc.update+` WHERE id = $1`, id); err != nil {
+			t.Fatalf("prepare %s message: %v", tc.name, err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO push_statistics (date, push_type, total_count) VALUES (CURRENT_DATE - 400, 'notification', 1)
+	`); err != nil {
+		t.Fatalf("insert push statistics: %v", err)
+	}
+
+	removed, err := CleanExpiredMessages(ctx, db, time.Hour)
+	if err != nil {
+		t.Fatalf("CleanExpiredMessages returned error: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("removed %d messages, want 2", removed)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id::TEXT FROM pending_messages`)
+	if err != nil {
+		t.Fatalf("query remaining messages: %v", err)
+	}
+	defer rows.Close()
+	var remaining []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		remaining = append(remaining, messages[id])
+	}
+	sort.Strings(remaining)
+	if got := strings.Join(remaining, ","); got != "confirmed-recent,pending" {
+		t.Fatalf("remaining messages = %s, want confirmed-recent,pending", got)
+	}
+
+	var statistics int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM push_statistics`).Scan(&statistics); err != nil {
+		t.Fatalf("count push statistics: %v", err)
+	}
+	if statistics != 1 {
+		t.Fatalf("cleanup removed push statistics, %d rows left", statistics)
+	}
+}
+
+func TestCleanExpiredMessagesWithoutRetentionPurgesConfirmed(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+	id := insertTestMessage(t, db, deviceID, "")
+	if _, err := db.ExecContext(ctx, `UPDATE pending_messages SET delivered = true, confirmed_at = NOW() WHERE id = $1`, id); err != nil {
+		t.Fatalf("confirm message: %v", err)
+	}
+
+	removed, err := CleanExpiredMessages(ctx, db, 0)
+	if err != nil {
+		t.Fatalf("CleanExpiredMessages returned error: %v", err)
 	}
-	if strings.Contains(expiredMessageCleanupSQL, "push_statistics") {
-		t.Fatal("cleanup query must not touch or expose push statistics")
+	if removed != 1 {
+		t.Fatalf("removed %d messages, want the confirmed one", removed)
 	}
 }
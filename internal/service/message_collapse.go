@@ -0,0 +1,33 @@
+package service
+
+import "fmt"
+
+// MaxCollapseKeyLength 推送参数 collapse_key 的最大长度
+const MaxCollapseKeyLength = 64
+
+// ErrInvalidCollapseKey 折叠键过长或包含 [A-Za-z0-9._:-] 以外的字符
+var ErrInvalidCollapseKey = fmt.Errorf("collapse_key must be 1-%d characters of letters, digits, '.', '_', ':' or '-'", MaxCollapseKeyLength)
+
+// ValidateCollapseKey 校验非空的折叠键
+// 折叠键以明文保存并返回给设备，因此只允许较短的标识符
+func ValidateCollapseKey(key string) error {
+	if key == "" || len(key) > MaxCollapseKeyLength {
+		return ErrInvalidCollapseKey
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.' || c == '_' || c == ':' || c == '-':
+		default:
+			return ErrInvalidCollapseKey
+		}
+	}
+	return nil
+}
+
+// CollapseNotificationID 派生折叠键相同的消息共用的 Push Kit notifyId
+// 按服务器名称和推送方API Key区分，不同服务器和推送方不会替换彼此的通知
+func CollapseNotificationID(serverName string, senderKeyID string, collapseKey string) int32 {
+	return NotificationID("collapse\x00" + serverName + "\x00" + senderKeyID + "\x00" + collapseKey)
+}
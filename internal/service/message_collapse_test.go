@@ -0,0 +1,35 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCollapseKey(t *testing.T) {
+	for _, key := range []string{"disk", "disk:var", "host-1.cpu_load", strings.Repeat("a", MaxCollapseKeyLength)} {
+		if err := ValidateCollapseKey(key); err != nil {
+			t.Fatalf("ValidateCollapseKey(%q) = %v", key, err)
+		}
+	}
+	for _, key := range []string{"", "disk usage", "磁盘", "a/b", strings.Repeat("a", MaxCollapseKeyLength+1)} {
+		if err := ValidateCollapseKey(key); err == nil {
+			t.Fatalf("ValidateCollapseKey(%q) should fail", key)
+		}
+	}
+}
+
+func TestCollapseNotificationIDIsScopedPerSender(t *testing.T) {
+	id := CollapseNotificationID("server", "", "disk")
+	if id != CollapseNotificationID("server", "", "disk") {
+		t.Fatal("same collapse key must map to the same notification ID")
+	}
+	if id == CollapseNotificationID("server", "key-1", "disk") {
+		t.Fatal("different senders must not share a notification ID")
+	}
+	if id == CollapseNotificationID("other server", "", "disk") {
+		t.Fatal("different servers must not share a notification ID")
+	}
+	if id <= 0 {
+		t.Fatalf("notification ID must be positive: %d", id)
+	}
+}
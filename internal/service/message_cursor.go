@@ -0,0 +1,51 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// 待接收消息分页
+const (
+	DefaultPendingMessageLimit = 100
+	MaxPendingMessageLimit     = 500
+)
+
+// ErrInvalidMessageCursor 游标格式错误
+var ErrInvalidMessageCursor = errors.New("invalid message cursor")
+
+// messageCursorSeqPrefix 标明游标内容为序号，便于以后扩展游标格式
+const messageCursorSeqPrefix = "seq:"
+
+// MessageCursor 标识待接收消息列表中的位置，消息按设备内序号 seq 排序
+// seq 在插入事务内持有设备行锁分配，提交顺序与序号一致，因此游标之后不会再出现更小的序号；
+// created_at 由应用生成、先于提交，按它分页会跳过晚提交的消息
+type MessageCursor struct {
+	Seq int64
+}
+
+// Encode 将游标编码为不透明字符串（base64url of "seq:<序号>"）
+func (c MessageCursor) Encode() string {
+	raw := messageCursorSeqPrefix + strconv.FormatInt(c.Seq, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeMessageCursor 解析 Encode 生成的游标
+func DecodeMessageCursor(value string) (MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return MessageCursor{}, ErrInvalidMessageCursor
+	}
+
+	seq, ok := strings.CutPrefix(string(raw), messageCursorSeqPrefix)
+	if !ok {
+		return MessageCursor{}, ErrInvalidMessageCursor
+	}
+	parsed, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil {
+		return MessageCursor{}, ErrInvalidMessageCursor
+	}
+	return MessageCursor{Seq: parsed}, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dengdeng-harmonyos/server/internal/database"
+)
+
+func TestMessageCursorRoundTripKeepsSeq(t *testing.T) {
+	for _, seq := range []int64{0, 1, 42, -3} {
+		decoded, err := DecodeMessageCursor(MessageCursor{Seq: seq}.Encode())
+		if err != nil {
+			t.Fatalf("DecodeMessageCursor: %v", err)
+		}
+		if decoded.Seq != seq {
+			t.Fatalf("decoded = %+v, want seq %d", decoded, seq)
+		}
+	}
+}
+
+func TestDecodeMessageCursorRejectsGarbage(t *testing.T) {
+	for _, value := range []string{"", "not base64!", "MTIz", "MTIzOm5vdC1hLXV1aWQ", "MTc5MjM5ODYwMDEyMzQ1NjoyZjBjNWMxZS03YTUyLTRkM2MtOWE1NC0wZDdlNWI3YTljMTE", "c2VxOg", "c2VxOmFiYw"} {
+		if _, err := DecodeMessageCursor(value); !errors.Is(err, ErrInvalidMessageCursor) {
+			t.Fatalf("DecodeMessageCursor(%q) error = %v", value, err)
+		}
+	}
+}
+
+func TestInitTablesNumbersLegacyMessagesBeforeAssignedSeq(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+
+	numbered := insertTestMessage(t, db, deviceID, "")
+	older := insertTestMessage(t, db, deviceID, "")
+	newer := insertTestMessage(t, db, deviceID, "")
+	// Messages stored before sequence numbers existed
+	if _, err := db.ExecContext(ctx, `
+		UPDATE pending_messages
+		SET seq = NULL, created_at = NOW() - CASE WHEN id = $1 THEN INTERVAL '2 hours' ELSE INTERVAL '1 hour' END
+		WHERE id IN ($1, $2)
+	`, older, newer); err != nil {
+		t.Fatalf("clear seq: %v", err)
+	}
+
+	if err := (&database.Database{DB: db}).InitTables(); err != nil {
+		t.Fatalf("InitTables returned error: %v", err)
+	}
+
+	var order []string
+	rows, err := db.QueryContext(ctx, `SELECT id::TEXT FROM pending_messages WHERE device_id = $1 ORDER BY seq`, deviceID)
+	if err != nil {
+		t.Fatalf("query messages: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		order = append(order, id)
+	}
+	if len(order) != 3 || order[0] != older || order[1] != newer || order[2] != numbered {
+		t.Fatalf("seq order = %v, want [%s %s %s]", order, older, newer, numbered)
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+	"github.com/lib/pq"
+)
+
+// PendingMessageChannel 通知有新待接收消息的 Postgres NOTIFY 通道，内容为接收设备ID
+const PendingMessageChannel = "pending_messages"
+
+const (
+	// MaxPendingMessageWait 长轮询 wait 参数的上限，低于常见反向代理60秒的默认读超时
+	MaxPendingMessageWait = 50 * time.Second
+	// MaxWaitersPerDevice 每台设备同时进行的长轮询请求上限
+	MaxWaitersPerDevice = 4
+	// MaxStreamsPerDevice 每台设备同时打开的SSE流上限，每个流占用一个连接并在每次唤醒时重新查询
+	MaxStreamsPerDevice = 4
+
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// NotifyPendingMessage 向所有监听 PendingMessageChannel 的服务实例通知 deviceID 有新待接收消息
+func NotifyPendingMessage(ctx context.Context, db sqlExecutor, deviceID string) error {
+	if _, err := db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, PendingMessageChannel, deviceID); err != nil {
+		return fmt.Errorf("notify pending message: %w", err)
+	}
+	return nil
+}
+
+// MessageHub 将待接收消息通知分发给本实例的SSE流和长轮询请求
+// 唤醒不携带数据，订阅方从自己的游标重新查询 pending_messages，因此唤醒被合并或重复都不会丢失或重复消息
+type MessageHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+	waiters     map[string]int
+	streams     map[string]int
+}
+
+// NewMessageHub 创建空的分发器，调用 Listen 后才能收到其它实例的通知
+func NewMessageHub() *MessageHub {
+	return &MessageHub{
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+		waiters:     make(map[string]int),
+		streams:     make(map[string]int),
+	}
+}
+
+// Subscribe 订阅 deviceID 的新消息通知，可能有新消息时返回的通道会收到一个值；
+// 订阅方离开时必须调用返回的取消函数
+func (h *MessageHub) Subscribe(deviceID string) (<-chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	if h.subscribers[deviceID] == nil {
+		h.subscribers[deviceID] = make(map[chan struct{}]struct{})
+	}
+	h.subscribers[deviceID][wake] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return wake, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[deviceID], wake)
+			if len(h.subscribers[deviceID]) == 0 {
+				delete(h.subscribers, deviceID)
+			}
+			h.mu.Unlock()
+		})
+	}
+}
+
+// SubscribeWaiter 长轮询请求的 Subscribe，deviceID 已有 limit 个请求在等待时不订阅并返回 false
+func (h *MessageHub) SubscribeWaiter(deviceID string, limit int) (<-chan struct{}, func(), bool) {
+	return h.subscribeLimited(h.waiters, deviceID, limit)
+}
+
+// SubscribeStream SSE流的 Subscribe，deviceID 已打开 limit 个流时不订阅并返回 false
+func (h *MessageHub) SubscribeStream(deviceID string, limit int) (<-chan struct{}, func(), bool) {
+	return h.subscribeLimited(h.streams, deviceID, limit)
+}
+
+// subscribeLimited 在 counts[deviceID] 低于 limit 时订阅，counts 由 h.mu 保护
+func (h *MessageHub) subscribeLimited(counts map[string]int, deviceID string, limit int) (<-chan struct{}, func(), bool) {
+	h.mu.Lock()
+	if counts[deviceID] >= limit {
+		h.mu.Unlock()
+		return nil, nil, false
+	}
+	counts[deviceID]++
+	h.mu.Unlock()
+
+	wake, unsubscribe := h.Subscribe(deviceID)
+	var once sync.Once
+	return wake, func() {
+		once.Do(func() {
+			unsubscribe()
+			h.mu.Lock()
+			if counts[deviceID]--; counts[deviceID] <= 0 {
+				delete(counts, deviceID)
+			}
+			h.mu.Unlock()
+		})
+	}, true
+}
+
+// Publish 唤醒本实例中 deviceID 的所有订阅方
+func (h *MessageHub) Publish(deviceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for wake := range h.subscribers[deviceID] {
+		signal(wake)
+	}
+}
+
+// wakeAll 唤醒本实例的所有订阅方，监听连接重连后调用，因为断开期间的通知已经丢失
+func (h *MessageHub) wakeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscribers := range h.subscribers {
+		for wake := range subscribers {
+			signal(wake)
+		}
+	}
+}
+
+// Subscribers 返回本实例中 deviceID 的订阅方数量
+func (h *MessageHub) Subscribers(deviceID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[deviceID])
+}
+
+// Listen 打开专用的 LISTEN 连接并将通知分发给本实例的订阅方，直到 ctx 结束；连接断开后自动重连
+func (h *MessageHub) Listen(ctx context.Context, dsn string) error {
+	listener := pq.NewListener(dsn, listenerMinReconnect, listenerMaxReconnect, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			logger.Error("Pending message listener disconnected: %v", err)
+		case pq.ListenerEventReconnected:
+			logger.Info("Pending message listener reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			logger.Error("Pending message listener reconnect failed: %v", err)
+		}
+	})
+	if err := listener.Listen(PendingMessageChannel); err != nil {
+		listener.Close()
+		return fmt.Errorf("listen %s: %w", PendingMessageChannel, err)
+	}
+
+	go func() {
+		defer listener.Close()
+		ping := time.NewTicker(listenerPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.NotificationChannel():
+				// nil 表示连接已重连
+				if n == nil {
+					h.wakeAll()
+					continue
+				}
+				h.Publish(n.Extra)
+			case <-ping.C:
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
+
+func signal(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import "testing"
+
+func TestMessageHubWakesOnlyMatchingDevice(t *testing.T) {
+	hub := NewMessageHub()
+	wakeA, cancelA := hub.Subscribe("device-a")
+	defer cancelA()
+	wakeB, cancelB := hub.Subscribe("device-b")
+	defer cancelB()
+
+	// Repeated publishes coalesce into one pending wake-up
+	hub.Publish("device-a")
+	hub.Publish("device-a")
+
+	select {
+	case <-wakeA:
+	default:
+		t.Fatal("device-a subscriber was not woken")
+	}
+	select {
+	case <-wakeA:
+		t.Fatal("wake-ups should coalesce")
+	default:
+	}
+	select {
+	case <-wakeB:
+		t.Fatal("device-b subscriber should not be woken")
+	default:
+	}
+}
+
+func TestMessageHubCancelRemovesSubscriber(t *testing.T) {
+	hub := NewMessageHub()
+	_, cancel := hub.Subscribe("device-a")
+	_, cancelOther := hub.Subscribe("device-a")
+	defer cancelOther()
+
+	if got := hub.Subscribers("device-a"); got != 2 {
+		t.Fatalf("subscribers = %d, want 2", got)
+	}
+	cancel()
+	cancel()
+	if got := hub.Subscribers("device-a"); got != 1 {
+		t.Fatalf("subscribers after cancel = %d, want 1", got)
+	}
+}
+
+func TestMessageHubWakeAll(t *testing.T) {
+	hub := NewMessageHub()
+	wakeA, cancelA := hub.Subscribe("device-a")
+	defer cancelA()
+	wakeB, cancelB := hub.Subscribe("device-b")
+	defer cancelB()
+
+	hub.wakeAll()
+	for name, wake := range map[string]<-chan struct{}{"device-a": wakeA, "device-b": wakeB} {
+		select {
+		case <-wake:
+		default:
+			t.Fatalf("%s was not woken", name)
+		}
+	}
+}
+
+func TestMessageHubCapsWaitersPerDevice(t *testing.T) {
+	hub := NewMessageHub()
+	_, cancelFirst, ok := hub.SubscribeWaiter("device-a", 2)
+	if !ok {
+		t.Fatal("first waiter rejected")
+	}
+	_, cancelSecond, ok := hub.SubscribeWaiter("device-a", 2)
+	if !ok {
+		t.Fatal("second waiter rejected")
+	}
+	defer cancelSecond()
+
+	if _, _, ok := hub.SubscribeWaiter("device-a", 2); ok {
+		t.Fatal("third waiter should exceed the cap")
+	}
+	if _, cancel, ok := hub.SubscribeWaiter("device-b", 2); !ok {
+		t.Fatal("cap must be per device")
+	} else {
+		cancel()
+	}
+
+	cancelFirst()
+	cancelFirst()
+	_, cancelThird, ok := hub.SubscribeWaiter("device-a", 2)
+	if !ok {
+		t.Fatal("waiter slot was not released")
+	}
+	cancelThird()
+	if got := hub.Subscribers("device-a"); got != 1 {
+		t.Fatalf("subscribers = %d, want 1", got)
+	}
+}
+
+func TestMessageHubCapsStreamsSeparatelyFromWaiters(t *testing.T) {
+	hub := NewMessageHub()
+	_, cancelStream, ok := hub.SubscribeStream("device-a", 1)
+	if !ok {
+		t.Fatal("first stream rejected")
+	}
+	if _, _, ok := hub.SubscribeStream("device-a", 1); ok {
+		t.Fatal("second stream should exceed the cap")
+	}
+	_, cancelWaiter, ok := hub.SubscribeWaiter("device-a", 1)
+	if !ok {
+		t.Fatal("open streams must not count against the waiter cap")
+	}
+	defer cancelWaiter()
+
+	cancelStream()
+	_, cancelAgain, ok := hub.SubscribeStream("device-a", 1)
+	if !ok {
+		t.Fatal("closing a stream did not release its slot")
+	}
+	cancelAgain()
+	if hub.Subscribers("device-a") != 1 {
+		t.Fatalf("subscribers = %d, want only the waiter", hub.Subscribers("device-a"))
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+var (
+	// ErrMessageNotRecallable 消息不存在或已过期
+	ErrMessageNotRecallable = errors.New("message not found or expired")
+	// ErrMessageAlreadyConfirmed 设备已确认该消息
+	ErrMessageAlreadyConfirmed = errors.New("message already confirmed by the device")
+	// ErrMessageRecallForbidden 消息由其它推送方API Key发送
+	ErrMessageRecallForbidden = errors.New("message was sent with a different API key")
+)
+
+// NotificationID 由消息ID派生 Push Kit 的 notifyId
+// 同一消息总是得到同一ID，不保存也能在之后撤回其通知
+func NotificationID(messageID string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(messageID))
+	id := int32(h.Sum32() & 0x7fffffff)
+	if id == 0 {
+		id = 1 // 0 表示由 Push Kit 自行分配ID
+	}
+	return id
+}
+
+// RecalledMessage RecallPendingMessage 删除的待接收消息
+type RecalledMessage struct {
+	MessageID string
+	DeviceID  string
+	NotifyID  int32 // 消息通知的 Push Kit notifyId
+}
+
+// RecallPendingMessage 删除未确认的待接收消息
+// 只有发送该消息的推送方可以撤回：同一个API Key，或未使用API Key发送的消息由未携带Key的请求撤回；
+// 未携带Key时 senderKeyID 为空
+func RecallPendingMessage(ctx context.Context, db *sql.DB, messageID string, senderKeyID string) (RecalledMessage, error) {
+	var (
+		recalled  RecalledMessage
+		owner     string
+		delivered bool
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT id::TEXT, device_id, COALESCE(sender_key_id::TEXT, ''), COALESCE(notify_id, 0), delivered
+		FROM pending_messages
+		WHERE id = $1 AND expires_at > NOW()
+	`, messageID).Scan(&recalled.MessageID, &recalled.DeviceID, &owner, &recalled.NotifyID, &delivered)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RecalledMessage{}, ErrMessageNotRecallable
+	}
+	if err != nil {
+		return RecalledMessage{}, fmt.Errorf("query pending message: %w", err)
+	}
+	if recalled.NotifyID == 0 {
+		// 保存 notify_id 之前的消息
+		recalled.NotifyID = NotificationID(recalled.MessageID)
+	}
+	if owner != senderKeyID {
+		return RecalledMessage{}, ErrMessageRecallForbidden
+	}
+	if delivered {
+		return RecalledMessage{}, ErrMessageAlreadyConfirmed
+	}
+
+	// 并发的确认先标记该行时以确认为准
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM pending_messages WHERE id = $1 AND delivered = false
+	`, messageID)
+	if err != nil {
+		return RecalledMessage{}, fmt.Errorf("delete pending message: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return RecalledMessage{}, ErrMessageAlreadyConfirmed
+	}
+	return recalled, nil
+}
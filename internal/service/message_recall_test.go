@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNotificationIDIsStablePositiveAndDistinct(t *testing.T) {
+	a := NotificationID("0d6f1d2e-8a43-4b8e-9f0c-1b2a3c4d5e6f")
+	b := NotificationID("6c1f0e2d-3b4a-4c5d-8e9f-0a1b2c3d4e5f")
+
+	if a != NotificationID("0d6f1d2e-8a43-4b8e-9f0c-1b2a3c4d5e6f") {
+		t.Fatal("notification ID must be stable for a message")
+	}
+	if a <= 0 || b <= 0 {
+		t.Fatalf("notification IDs must be positive: %d, %d", a, b)
+	}
+	if a == b {
+		t.Fatalf("different messages got the same notification ID %d", a)
+	}
+}
+
+func TestRecallPendingMessageOnlyBySender(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+	senderKey := uuid.NewString()
+
+	keyed := insertTestMessage(t, db, deviceID, "")
+	anonymous := insertTestMessage(t, db, deviceID, "")
+	if _, err := db.ExecContext(ctx, `UPDATE pending_messages SET sender_key_id = $1 WHERE id = $2`, senderKey, keyed); err != nil {
+		t.Fatalf("set sender key: %v", err)
+	}
+
+	for _, tc := range []struct {
+		messageID string
+		senderKey string
+	}{
+		{keyed, ""},
+		{keyed, uuid.NewString()},
+		{anonymous, senderKey},
+	} {
+		if _, err := RecallPendingMessage(ctx, db, tc.messageID, tc.senderKey); !errors.Is(err, ErrMessageRecallForbidden) {
+			t.Fatalf("RecallPendingMessage(%s, %q) error = %v, want ErrMessageRecallForbidden", tc.messageID, tc.senderKey, err)
+		}
+	}
+
+	if _, err := RecallPendingMessage(ctx, db, keyed, senderKey); err != nil {
+		t.Fatalf("sender could not recall its message: %v", err)
+	}
+	if _, err := RecallPendingMessage(ctx, db, anonymous, ""); err != nil {
+		t.Fatalf("anonymous sender could not recall its message: %v", err)
+	}
+}
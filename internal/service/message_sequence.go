@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSequenceDeviceNotFound 设备不存在
+	ErrSequenceDeviceNotFound = errors.New("device not found")
+	// ErrSequenceAhead 设备上报了尚未分配的序号
+	ErrSequenceAhead = errors.New("sequence number has not been assigned yet")
+)
+
+// SequenceState 设备已分配的消息序号与其最近上报已处理的序号
+type SequenceState struct {
+	LastAssignedSeq int64  `json:"lastAssignedSeq"`
+	LastSeenSeq     int64  `json:"lastSeenSeq"`
+	LastSeenAt      string `json:"lastSeenAt,omitempty"`
+	// MissedCount LastSeenSeq 之后已无法拉取的序号数：消息已过期、被撤回、被替换，
+	// 或在设备上报之前已确认并被清除
+	MissedCount int64 `json:"missedCount"`
+}
+
+// NextMessageSeq 为 deviceID 分配下一个消息序号
+// UPDATE 持有的行锁使并发推送串行化，序号唯一且按提交顺序递增；
+// 需在插入消息的事务内调用，回滚时不会留下空缺
+func NextMessageSeq(ctx context.Context, db sqlExecutor, deviceID string) (int64, error) {
+	var seq int64
+	err := db.QueryRowContext(ctx, `
+		UPDATE devices SET message_seq = message_seq + 1
+		WHERE device_id = $1
+		RETURNING message_seq
+	`, deviceID).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrSequenceDeviceNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("assign message sequence: %w", err)
+	}
+	return seq, nil
+}
+
+// ReportLastSeenSeq 记录设备已处理的最大序号，保存的值不会回退
+func ReportLastSeenSeq(ctx context.Context, db *sql.DB, deviceID string, seq int64) (SequenceState, error) {
+	var state SequenceState
+	err := db.QueryRowContext(ctx, `
+		UPDATE devices
+		SET last_seen_seq = GREATEST(last_seen_seq, $2),
+		    last_seen_seq_at = NOW()
+		WHERE device_id = $1 AND $2 <= message_seq
+		RETURNING message_seq, last_seen_seq
+	`, deviceID, seq).Scan(&state.LastAssignedSeq, &state.LastSeenSeq)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM devices WHERE device_id = $1)`, deviceID).Scan(&exists); err != nil {
+			return SequenceState{}, fmt.Errorf("query device: %w", err)
+		}
+		if !exists {
+			return SequenceState{}, ErrSequenceDeviceNotFound
+		}
+		return SequenceState{}, ErrSequenceAhead
+	}
+	if err != nil {
+		return SequenceState{}, fmt.Errorf("record last seen sequence: %w", err)
+	}
+	return state, nil
+}
+
+// GetSequenceState 返回设备的消息序号，以及最近上报的序号之后有多少消息已无法拉取
+func GetSequenceState(ctx context.Context, db *sql.DB, deviceID string) (SequenceState, error) {
+	var (
+		state      SequenceState
+		fetchable  int64
+		lastSeenAt sql.NullString
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT d.message_seq, d.last_seen_seq,
+		       to_char(d.last_seen_seq_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'),
+		       (SELECT COUNT(*) FROM pending_messages p
+		        WHERE p.device_id = d.device_id AND p.seq > d.last_seen_seq
+		          AND (p.delivered = true OR p.expires_at > NOW()))
+		FROM devices d
+		WHERE d.device_id = $1
+	`, deviceID).Scan(&state.LastAssignedSeq, &state.LastSeenSeq, &lastSeenAt, &fetchable)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SequenceState{}, ErrSequenceDeviceNotFound
+	}
+	if err != nil {
+		return SequenceState{}, fmt.Errorf("query sequence state: %w", err)
+	}
+	state.LastSeenAt = lastSeenAt.String
+	state.MissedCount = missedSequenceCount(state.LastAssignedSeq, state.LastSeenSeq, fetchable)
+	return state, nil
+}
+
+// missedSequenceCount 统计 (lastSeen, lastAssigned] 中既无法拉取也未被确认的序号数
+func missedSequenceCount(lastAssigned int64, lastSeen int64, fetchable int64) int64 {
+	missed := lastAssigned - lastSeen - fetchable
+	if missed < 0 {
+		return 0
+	}
+	return missed
+}
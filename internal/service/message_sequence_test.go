@@ -0,0 +1,20 @@
+package service
+
+import "testing"
+
+func TestMissedSequenceCount(t *testing.T) {
+	cases := []struct {
+		assigned, seen, fetchable, want int64
+	}{
+		{0, 0, 0, 0},
+		{10, 10, 0, 0}, // app is up to date
+		{10, 7, 3, 0},  // three newer messages still pending
+		{10, 7, 1, 2},  // two expired or were recalled before the app saw them
+		{10, 7, 5, 0},  // confirmed rows retained beyond seen never go negative
+	}
+	for _, tc := range cases {
+		if got := missedSequenceCount(tc.assigned, tc.seen, tc.fetchable); got != tc.want {
+			t.Fatalf("missedSequenceCount(%d, %d, %d) = %d, want %d", tc.assigned, tc.seen, tc.fetchable, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dengdeng-harmonyos/server/internal/config"
+)
+
+// messageSignatureLabel 是消息签名内容的前缀，区分用途和版本
+const messageSignatureLabel = "dengdeng/message-sig/v1"
+
+// messageSigningKeyLabel 用于从 SigningKeyID 指定的Push Token加密密钥派生签名密钥
+const messageSigningKeyLabel = "dengdeng/message-signing/v1"
+
+// 签名密钥来源（用于日志，不包含密钥本身）
+const (
+	SigningKeySourceConfig  = "configured"
+	SigningKeySourceDerived = "derived from push token encryption key"
+)
+
+// MessageSigner 使用服务端Ed25519密钥为保存的消息信封签名，
+// App 用公开的服务端公钥验证消息确实来自该服务器
+type MessageSigner struct {
+	privateKey ed25519.PrivateKey
+	keyID      string
+	source     string
+}
+
+// NewMessageSigner 创建消息签名器
+// cfg.SigningKey 为base64编码的32字节种子或64字节私钥；为空时必须用
+// cfg.SigningKeyID 指定从哪个Push Token加密密钥派生，避免密钥环顺序变化时公钥悄悄改变
+func NewMessageSigner(cfg config.SecurityConfig, encryption *EncryptionService) (*MessageSigner, error) {
+	var (
+		seed   []byte
+		source string
+	)
+	// 编译时注入的空secret会留下换行，按未配置处理
+	if signingKey := strings.TrimSpace(cfg.SigningKey); signingKey != "" {
+		decoded, err := base64.StdEncoding.DecodeString(signingKey)
+		if err != nil {
+			return nil, fmt.Errorf("signing key must be base64 encoded")
+		}
+		switch len(decoded) {
+		case ed25519.SeedSize:
+			seed = decoded
+		case ed25519.PrivateKeySize:
+			seed = decoded[:ed25519.SeedSize]
+		default:
+			return nil, fmt.Errorf("signing key must be a %d byte Ed25519 seed or %d byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+		}
+		source = SigningKeySourceConfig
+	} else if cfg.SigningKeyID != "" {
+		if encryption == nil {
+			return nil, fmt.Errorf("signing key id %q needs the push token keyring", cfg.SigningKeyID)
+		}
+		key, ok := encryption.keys[cfg.SigningKeyID]
+		if !ok {
+			return nil, fmt.Errorf("signing key id %q is not in the push token keyring", cfg.SigningKeyID)
+		}
+		seed = deriveKey(key, messageSigningKeyLabel)
+		source = SigningKeySourceDerived
+	} else {
+		return nil, fmt.Errorf("signing key is not configured: set SERVER_SIGNING_KEY, or SERVER_SIGNING_KEY_ID=%s to keep the key derived by earlier versions", LegacyEncryptionKeyID)
+	}
+
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	sum := sha256.Sum256(publicKey)
+
+	return &MessageSigner{
+		privateKey: privateKey,
+		keyID:      hex.EncodeToString(sum[:8]),
+		source:     source,
+	}, nil
+}
+
+// KeyID 返回签名公钥ID（公钥SHA-256的前16个十六进制字符）
+func (s *MessageSigner) KeyID() string {
+	return s.keyID
+}
+
+// Source 返回签名密钥来源
+func (s *MessageSigner) Source() string {
+	return s.source
+}
+
+// PublicKey 返回签名公钥
+func (s *MessageSigner) PublicKey() ed25519.PublicKey {
+	return s.privateKey.Public().(ed25519.PublicKey)
+}
+
+// PublicKeyPEM 返回PKIX PEM格式的签名公钥
+func (s *MessageSigner) PublicKeyPEM() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// Sign 为消息信封签名，返回base64编码的Ed25519签名
+func (s *MessageSigner) Sign(binding MessageBinding, encrypted *EncryptedMessage) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, MessageSignaturePayload(binding, encrypted)))
+}
+
+// VerifyMessageSignature 验证消息信封签名
+func VerifyMessageSignature(publicKey ed25519.PublicKey, binding MessageBinding, encrypted *EncryptedMessage, signature string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(publicKey, MessageSignaturePayload(binding, encrypted), decoded)
+}
+
+// MessageSignaturePayload 返回被签名的内容，各字段以换行分隔：
+//
+//	dengdeng/message-sig/v1
+//	<device_id>
+//	<message_id>
+//	<created_at>
+//	<crypto_version>
+//	<envelope_version>
+//	<key_id>
+//	<encrypted_aes_key>
+//	<ephemeral_public_key>
+//	<encrypted_content>
+//	<iv>
+//	<server_name>
+//
+// 缺省字段为空字符串；服务器名称放在最后，因此其中包含换行也不会产生歧义
+func MessageSignaturePayload(binding MessageBinding, encrypted *EncryptedMessage) []byte {
+	return []byte(strings.Join([]string{
+		messageSignatureLabel,
+		binding.DeviceID,
+		binding.MessageID,
+		binding.CreatedAt.UTC().Format(MessageTimeLayout),
+		strconv.Itoa(encrypted.CryptoVersion),
+		strconv.Itoa(encrypted.EnvelopeVersion),
+		encrypted.KeyID,
+		encrypted.EncryptedAESKey,
+		encrypted.EphemeralPublicKey,
+		encrypted.EncryptedContent,
+		encrypted.IV,
+		binding.ServerName,
+	}, "\n"))
+}
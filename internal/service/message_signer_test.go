@@ -0,0 +1,89 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/dengdeng-harmonyos/server/internal/config"
+)
+
+func TestMessageSignerSignsEnvelopeAndMetadata(t *testing.T) {
+	seed := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	signer, err := NewMessageSigner(config.SecurityConfig{SigningKey: seed}, nil)
+	if err != nil {
+		t.Fatalf("NewMessageSigner returned error: %v", err)
+	}
+	if signer.Source() != SigningKeySourceConfig || len(signer.KeyID()) != 16 {
+		t.Fatalf("signer source = %q, key id = %q", signer.Source(), signer.KeyID())
+	}
+
+	envelope := &EncryptedMessage{
+		CryptoVersion:      MessageCryptoV2,
+		EnvelopeVersion:    MessageEnvelopeV2,
+		KeyID:              "00112233aabbccdd",
+		EphemeralPublicKey: "ZXBoZW1lcmFs",
+		EncryptedContent:   "Y2lwaGVydGV4dA==",
+		IV:                 "bm9uY2U=",
+	}
+	signature := signer.Sign(testBinding, envelope)
+	if !VerifyMessageSignature(signer.PublicKey(), testBinding, envelope, signature) {
+		t.Fatal("signature does not verify")
+	}
+
+	tamperedEnvelope := *envelope
+	tamperedEnvelope.EncryptedContent = "b3RoZXI="
+	otherDevice := testBinding
+	otherDevice.DeviceID = "0b6f2f5e-3a4d-4c3a-9d55-6f3b7f1f0a11"
+	if VerifyMessageSignature(signer.PublicKey(), testBinding, &tamperedEnvelope, signature) {
+		t.Fatal("signature verifies for tampered ciphertext")
+	}
+	if VerifyMessageSignature(signer.PublicKey(), otherDevice, envelope, signature) {
+		t.Fatal("signature verifies for another device")
+	}
+}
+
+func TestMessageSignerDerivesStableKeyFromEncryptionKey(t *testing.T) {
+	encryption, err := NewEncryptionService(config.SecurityConfig{EncryptionKey: testEncryptionKey, TokenIndexKeyID: LegacyEncryptionKeyID})
+	if err != nil {
+		t.Fatalf("NewEncryptionService returned error: %v", err)
+	}
+
+	first, err := NewMessageSigner(config.SecurityConfig{SigningKey: "\n", SigningKeyID: LegacyEncryptionKeyID}, encryption)
+	if err != nil {
+		t.Fatalf("NewMessageSigner returned error: %v", err)
+	}
+	second, err := NewMessageSigner(config.SecurityConfig{SigningKeyID: LegacyEncryptionKeyID}, encryption)
+	if err != nil {
+		t.Fatalf("NewMessageSigner returned error: %v", err)
+	}
+	if first.Source() != SigningKeySourceDerived || !first.PublicKey().Equal(second.PublicKey()) {
+		t.Fatal("derived signing key is not stable")
+	}
+
+	if _, err := NewMessageSigner(config.SecurityConfig{}, encryption); err == nil {
+		t.Fatal("NewMessageSigner derived a key without SigningKeyID")
+	}
+	if _, err := NewMessageSigner(config.SecurityConfig{SigningKeyID: "missing"}, encryption); err == nil {
+		t.Fatal("NewMessageSigner accepted a key id that is not in the keyring")
+	}
+
+	pemKey, err := first.PublicKeyPEM()
+	if err != nil || !strings.Contains(pemKey, "BEGIN PUBLIC KEY") {
+		t.Fatalf("PublicKeyPEM() = %q, %v", pemKey, err)
+	}
+}
+
+func TestNewMessageSignerRejectsBadKeys(t *testing.T) {
+	for _, key := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewMessageSigner(config.SecurityConfig{SigningKey: key}, nil); err == nil {
+			t.Fatalf("NewMessageSigner accepted %q", key)
+		}
+	}
+
+	full := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	if _, err := NewMessageSigner(config.SecurityConfig{SigningKey: base64.StdEncoding.EncodeToString(full)}, nil); err != nil {
+		t.Fatalf("NewMessageSigner rejected a 64 byte private key: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// 向推送方报告的消息投递状态
+const (
+	MessageStatusPending   = "pending"   // 已保存，设备尚未拉取
+	MessageStatusFetched   = "fetched"   // 已返回给设备，尚未确认
+	MessageStatusConfirmed = "confirmed" // 设备已确认，密文已清除
+	MessageStatusExpired   = "expired"   // 在 expires_at 之前未被确认
+)
+
+// ErrMessageStatusNotFound 消息不存在、已被清除，或由其它推送方API Key发送
+var ErrMessageStatusNotFound = errors.New("message not found")
+
+// MessageStatus 推送方看到的一条消息的投递状态
+type MessageStatus struct {
+	MessageID   string `json:"message_id"`
+	Status      string `json:"status"`
+	CreatedAt   string `json:"created_at"`
+	ExpiresAt   string `json:"expires_at"`
+	ConfirmedAt string `json:"confirmed_at,omitempty"`
+}
+
+// GetMessageStatus 查询消息的投递状态，已确认的消息在保留期结束前仍可查询
+// 只有发送该消息的推送方可以查询：同一个API Key，或未使用API Key发送的消息由未携带Key的请求查询
+func GetMessageStatus(ctx context.Context, db *sql.DB, messageID string, senderKeyID string) (MessageStatus, error) {
+	var (
+		status    MessageStatus
+		owner     string
+		delivered bool
+		fetched   bool
+		expired   bool
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT id::TEXT, COALESCE(sender_key_id::TEXT, ''), delivered, COALESCE(notification_sent, false),
+		       expires_at <= NOW(),
+		       to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'),
+		       to_char(expires_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'),
+		       COALESCE(to_char(confirmed_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), '')
+		FROM pending_messages
+		WHERE id = $1
+	`, messageID).Scan(&status.MessageID, &owner, &delivered, &fetched, &expired,
+		&status.CreatedAt, &status.ExpiresAt, &status.ConfirmedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MessageStatus{}, ErrMessageStatusNotFound
+	}
+	if err != nil {
+		return MessageStatus{}, fmt.Errorf("query message status: %w", err)
+	}
+	if owner != senderKeyID {
+		return MessageStatus{}, ErrMessageStatusNotFound
+	}
+
+	status.Status = messageStatus(delivered, fetched, expired)
+	return status, nil
+}
+
+func messageStatus(delivered bool, fetched bool, expired bool) string {
+	switch {
+	case delivered:
+		return MessageStatusConfirmed
+	case expired:
+		return MessageStatusExpired
+	case fetched:
+		return MessageStatusFetched
+	default:
+		return MessageStatusPending
+	}
+}
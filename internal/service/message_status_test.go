@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMessageStatusPrecedence(t *testing.T) {
+	cases := []struct {
+		delivered, fetched, expired bool
+		want                        string
+	}{
+		{false, false, false, MessageStatusPending},
+		{false, true, false, MessageStatusFetched},
+		{false, true, true, MessageStatusExpired},
+		{true, true, true, MessageStatusConfirmed},
+		{true, false, false, MessageStatusConfirmed},
+	}
+	for _, tc := range cases {
+		if got := messageStatus(tc.delivered, tc.fetched, tc.expired); got != tc.want {
+			t.Fatalf("messageStatus(%v, %v, %v) = %s, want %s", tc.delivered, tc.fetched, tc.expired, got, tc.want)
+		}
+	}
+}
+
+func TestGetMessageStatusOnlyForSender(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+	senderKey := uuid.NewString()
+
+	keyed := insertTestMessage(t, db, deviceID, "")
+	anonymous := insertTestMessage(t, db, deviceID, "")
+	if _, err := db.ExecContext(ctx, `UPDATE pending_messages SET sender_key_id = $1 WHERE id = $2`, senderKey, keyed); err != nil {
+		t.Fatalf("set sender key: %v", err)
+	}
+
+	for _, tc := range []struct {
+		messageID string
+		senderKey string
+	}{
+		{keyed, ""},
+		{keyed, uuid.NewString()},
+		{anonymous, senderKey},
+	} {
+		if _, err := GetMessageStatus(ctx, db, tc.messageID, tc.senderKey); !errors.Is(err, ErrMessageStatusNotFound) {
+			t.Fatalf("GetMessageStatus(%s, %q) error = %v, want ErrMessageStatusNotFound", tc.messageID, tc.senderKey, err)
+		}
+	}
+
+	status, err := GetMessageStatus(ctx, db, keyed, senderKey)
+	if err != nil || status.Status != MessageStatusPending {
+		t.Fatalf("GetMessageStatus for the sender = %+v, %v", status, err)
+	}
+	if _, err := GetMessageStatus(ctx, db, anonymous, ""); err != nil {
+		t.Fatalf("anonymous sender could not query its message: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+import "fmt"
+
+// 通知隐私级别
+const (
+	PrivacyLevelStandard = "standard" // 通知栏显示真实标题和内容
+	PrivacyLevelPrivate  = "private"  // 通知栏只显示占位文案，真实内容仅在App解密后可见
+)
+
+// IsValidPrivacyLevel 判断隐私级别是否有效
+func IsValidPrivacyLevel(level string) bool {
+	return level == PrivacyLevelStandard || level == PrivacyLevelPrivate
+}
+
+// EffectivePrivacyLevel 合并设备设置和单次请求的隐私级别
+// 任一方要求 private 即为 private，推送方不能降低设备选择的隐私级别
+func EffectivePrivacyLevel(deviceLevel string, requestLevel string) string {
+	if deviceLevel == PrivacyLevelPrivate || requestLevel == PrivacyLevelPrivate {
+		return PrivacyLevelPrivate
+	}
+	return PrivacyLevelStandard
+}
+
+// PrivateNotificationText 返回隐私模式下通知栏显示的占位标题和内容
+func PrivateNotificationText(serverName string) (string, string) {
+	return "新消息", fmt.Sprintf("你收到一条来自 %s 的新消息", serverName)
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEffectivePrivacyLevelNeverDowngradesDevice(t *testing.T) {
+	cases := []struct {
+		device, request, want string
+	}{
+		{PrivacyLevelStandard, "", PrivacyLevelStandard},
+		{PrivacyLevelStandard, PrivacyLevelPrivate, PrivacyLevelPrivate},
+		{PrivacyLevelPrivate, "", PrivacyLevelPrivate},
+		{PrivacyLevelPrivate, PrivacyLevelStandard, PrivacyLevelPrivate},
+		{"", "", PrivacyLevelStandard},
+	}
+
+	for _, tc := range cases {
+		if got := EffectivePrivacyLevel(tc.device, tc.request); got != tc.want {
+			t.Fatalf("EffectivePrivacyLevel(%q, %q) = %q, want %q", tc.device, tc.request, got, tc.want)
+		}
+	}
+}
+
+func TestPrivateNotificationTextOnlyNamesServer(t *testing.T) {
+	title, content := PrivateNotificationText("测试服务器")
+	if title == "" || !strings.Contains(content, "测试服务器") {
+		t.Fatalf("PrivateNotificationText = %q, %q", title, content)
+	}
+}
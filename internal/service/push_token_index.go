@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+)
+
+// PushTokenIndexBackfillResult 一次盲索引回填的结果
+type PushTokenIndexBackfillResult struct {
+	Indexed int
+	Merged  int
+	Failed  int
+}
+
+type storedPushToken struct {
+	deviceID       string
+	encryptedToken string
+}
+
+// BackfillPushTokenIndex 为盲索引出现之前注册的设备计算 push_token_hash
+// Push Token 相同的设备合并到最近活跃的那一台
+func BackfillPushTokenIndex(ctx context.Context, db *sql.DB, encryption *EncryptionService) (PushTokenIndexBackfillResult, error) {
+	var result PushTokenIndexBackfillResult
+
+	// 最近活跃的设备优先获得索引，重复的旧设备随后合并到它上面
+	rows, err := db.QueryContext(ctx, `
+		SELECT device_id::TEXT, push_token
+		FROM devices
+		WHERE push_token_hash IS NULL
+		ORDER BY last_active_at DESC NULLS LAST, created_at DESC
+	`)
+	if err != nil {
+		return result, fmt.Errorf("query unindexed devices: %w", err)
+	}
+
+	var devices []storedPushToken
+	for rows.Next() {
+		var device storedPushToken
+		if err := rows.Scan(&device.deviceID, &device.encryptedToken); err != nil {
+			rows.Close()
+			return result, fmt.Errorf("scan unindexed device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+	if err := rows.Close(); err != nil {
+		return result, fmt.Errorf("query unindexed devices: %w", err)
+	}
+
+	for _, device := range devices {
+		pushToken, err := encryption.Decrypt(device.encryptedToken)
+		if err != nil {
+			logger.Error("Push token index backfill: cannot decrypt token of device %s: %v", device.deviceID, err)
+			result.Failed++
+			continue
+		}
+
+		merged, err := indexDevicePushToken(ctx, db, device.deviceID, encryption.BlindIndex(pushToken))
+		if err != nil {
+			return result, err
+		}
+		if merged {
+			result.Merged++
+		} else {
+			result.Indexed++
+		}
+	}
+
+	return result, nil
+}
+
+func indexDevicePushToken(ctx context.Context, db *sql.DB, deviceID string, tokenHash string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin push token index transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var survivorID string
+	err = tx.QueryRowContext(ctx, `
+		SELECT device_id::TEXT FROM devices
+		WHERE push_token_hash = $1 AND device_id <> $2
+		FOR UPDATE
+	`, tokenHash, deviceID).Scan(&survivorID)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE devices SET push_token_hash = $1 WHERE device_id = $2
+		`, tokenHash, deviceID); err != nil {
+			return false, fmt.Errorf("set push token hash: %w", err)
+		}
+	case err != nil:
+		return false, fmt.Errorf("query device by push token hash: %w", err)
+	default:
+		if err := MergeDevices(ctx, tx, survivorID, deviceID, SystemAuditActor); err != nil {
+			return false, err
+		}
+		logger.Info("Push token index backfill: merged duplicate device %s into %s", deviceID, survivorID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit push token index transaction: %w", err)
+	}
+	return survivorID != "", nil
+}
+
+// MergeDevices 把重复设备 duplicateID 的分组、发送授权、接收者、公钥和待接收消息并入 survivorID，并删除重复设备
+// 只有保留设备不属于任何接收者时才转移接收者；否则保留设备不变，重复设备是其接收者的最后一台设备时删除该接收者
+//
+// 待接收消息用重复设备的公钥加密，关联数据和签名绑定重复设备的 device_id，因此原样投递：
+// 重复设备的公钥作为已停用的公钥并入保留设备的公钥环，bound_device_id 记录App验证和解密时应使用的 device_id，
+// 消息按原顺序编号在保留设备已有消息之后。保留设备已有同一接收者消息的副本时丢弃重复设备的副本，避免同一条消息出现两份
+//
+// 合并在两个 device_id 下各记录一条审计事件，按被删除的 device_id 也能查到去向
+func MergeDevices(ctx context.Context, tx *sql.Tx, survivorID string, duplicateID string, actor AuditActor) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO device_group_members (group_name, device_id, source)
+		SELECT group_name, $1::uuid, source
+		FROM device_group_members
+		WHERE device_id = $2
+		ON CONFLICT (group_name, device_id) DO NOTHING
+	`, survivorID, duplicateID); err != nil {
+		return fmt.Errorf("move group memberships to surviving device: %w", err)
+	}
+
+	// 发送授权继续有效，改为推送到保留设备
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE api_keys
+		SET owner_device_id = $1::uuid, allowed_device_ids = ARRAY[$1::TEXT], updated_at = NOW()
+		WHERE owner_device_id = $2::uuid
+	`, survivorID, duplicateID); err != nil {
+		return fmt.Errorf("move send grants to surviving device: %w", err)
+	}
+
+	moved, dropped, err := movePendingMessages(ctx, tx, survivorID, duplicateID)
+	if err != nil {
+		return err
+	}
+
+	var duplicateRecipient sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT recipient_id::TEXT FROM devices WHERE device_id = $1
+	`, duplicateID).Scan(&duplicateRecipient); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("query recipient of duplicate device: %w", err)
+	}
+	if duplicateRecipient.Valid {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE devices s
+			SET recipient_id = d.recipient_id, recipient_joined_at = d.recipient_joined_at, updated_at = NOW()
+			FROM devices d
+			WHERE s.device_id = $1 AND d.device_id = $2 AND s.recipient_id IS NULL
+		`, survivorID, duplicateID); err != nil {
+			return fmt.Errorf("move recipient to surviving device: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM devices WHERE device_id = $1
+	`, duplicateID); err != nil {
+		return fmt.Errorf("delete duplicate device: %w", err)
+	}
+	if duplicateRecipient.Valid {
+		if err := deleteEmptyRecipient(ctx, tx, duplicateRecipient.String); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Merged device %s into %s: moved %d pending messages, dropped %d duplicate recipient copies", duplicateID, survivorID, moved, dropped)
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditDeviceMerge, survivorID, map[string]interface{}{
+		"mergedDeviceId": duplicateID, "movedMessages": moved, "droppedMessages": dropped,
+	})); err != nil {
+		return err
+	}
+	return RecordAuditEvent(ctx, tx, actor.Event(AuditDeviceMerge, duplicateID, map[string]interface{}{
+		"survivorDeviceId": survivorID, "movedMessages": moved, "droppedMessages": dropped,
+	}))
+}
+
+// movePendingMessages 把重复设备未投递的消息及其加密所用的公钥转给保留设备，
+// 返回转移的消息数和因保留设备已有副本而丢弃的消息数
+func movePendingMessages(ctx context.Context, tx *sql.Tx, survivorID string, duplicateID string) (int64, int64, error) {
+	// 重复设备的公钥仍可用于解密旧消息，但不会再用于加密新消息
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO device_keys (device_id, key_id, public_key, fingerprint, crypto_version, valid_from, valid_until)
+		SELECT $1::uuid, key_id, public_key, fingerprint, crypto_version, valid_from, COALESCE(valid_until, NOW())
+		FROM device_keys
+		WHERE device_id = $2::uuid
+		ON CONFLICT (device_id, key_id) DO NOTHING
+	`, survivorID, duplicateID); err != nil {
+		return 0, 0, fmt.Errorf("move device keys to surviving device: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM pending_messages d
+		WHERE d.device_id = $2 AND d.delivered = false AND d.recipient_message_id IS NOT NULL
+		  AND EXISTS (
+		      SELECT 1 FROM pending_messages s
+		      WHERE s.device_id = $1 AND s.recipient_message_id = d.recipient_message_id
+		  )
+	`, survivorID, duplicateID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("drop duplicate recipient copies: %w", err)
+	}
+	dropped, _ := result.RowsAffected()
+
+	// 保持原顺序，编号在保留设备已有消息之后
+	result, err = tx.ExecContext(ctx, `
+		WITH moved AS (
+		    SELECT id, ROW_NUMBER() OVER (ORDER BY seq NULLS FIRST, created_at, id) AS n
+		    FROM pending_messages
+		    WHERE device_id = $2 AND delivered = false
+		),
+		bumped AS (
+		    UPDATE devices
+		    SET message_seq = message_seq + (SELECT COUNT(*) FROM moved)
+		    WHERE device_id = $1
+		    RETURNING message_seq - (SELECT COUNT(*) FROM moved) AS base
+		)
+		UPDATE pending_messages p
+		SET device_id = $1, bound_device_id = COALESCE(p.bound_device_id, $2::uuid), seq = bumped.base + moved.n
+		FROM moved, bumped
+		WHERE p.id = moved.id
+	`, survivorID, duplicateID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("move pending messages to surviving device: %w", err)
+	}
+	moved, _ := result.RowsAffected()
+	return moved, dropped, nil
+}
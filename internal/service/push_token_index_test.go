@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMergeDevicesMovesPendingMessages(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	survivor := insertTestDevice(t, db)
+	duplicate := insertTestDevice(t, db)
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO device_keys (device_id, key_id, public_key, fingerprint) VALUES ($1, 'old-key', 'pem', 'fp')
+	`, duplicate); err != nil {
+		t.Fatalf("insert device key: %v", err)
+	}
+
+	kept := insertTestMessage(t, db, survivor, "")
+	first := insertTestMessage(t, db, duplicate, "old-key")
+	second := insertTestMessage(t, db, duplicate, "old-key")
+	// 两台设备都持有同一条接收者消息的副本，只保留幸存设备的
+	sharedCopy := insertTestMessage(t, db, duplicate, "old-key")
+	recipientMessageID := uuid.NewString()
+	if _, err := db.ExecContext(ctx, `
+		UPDATE pending_messages SET recipient_message_id = $1 WHERE id IN ($2, $3)
+	`, recipientMessageID, kept, sharedCopy); err != nil {
+		t.Fatalf("mark recipient copies: %v", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if err := MergeDevices(ctx, tx, survivor, duplicate, SystemAuditActor); err != nil {
+		t.Fatalf("MergeDevices returned error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	for id, want := range map[string]struct {
+		seq   int64
+		bound string
+	}{kept: {1, ""}, first: {2, duplicate}, second: {3, duplicate}} {
+		var (
+			deviceID, bound string
+			seq             int64
+		)
+		if err := db.QueryRowContext(ctx, `
+			SELECT device_id, COALESCE(bound_device_id::TEXT, ''), seq FROM pending_messages WHERE id = $1
+		`, id).Scan(&deviceID, &bound, &seq); err != nil {
+			t.Fatalf("query %s: %v", id, err)
+		}
+		if deviceID != survivor || bound != want.bound || seq != want.seq {
+			t.Fatalf("message %s on %s bound %q seq %d, want %s bound %q seq %d", id, deviceID, bound, seq, survivor, want.bound, want.seq)
+		}
+	}
+
+	var copies int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pending_messages WHERE id = $1`, sharedCopy).Scan(&copies); err != nil || copies != 0 {
+		t.Fatalf("duplicate recipient copy left behind: %d, %v", copies, err)
+	}
+
+	var retired bool
+	if err := db.QueryRowContext(ctx, `
+		SELECT valid_until IS NOT NULL FROM device_keys WHERE device_id = $1 AND key_id = 'old-key'
+	`, survivor).Scan(&retired); err != nil || !retired {
+		t.Fatalf("duplicate key on survivor retired = %v, %v", retired, err)
+	}
+	var lastSeq int64
+	if err := db.QueryRowContext(ctx, `SELECT message_seq FROM devices WHERE device_id = $1`, survivor).Scan(&lastSeq); err != nil || lastSeq != 3 {
+		t.Fatalf("survivor message_seq = %d, %v, want 3", lastSeq, err)
+	}
+}
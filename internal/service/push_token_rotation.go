@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dengdeng-harmonyos/server/internal/logger"
+)
+
+const pushTokenReencryptBatchSize = 200
+
+// PushTokenReencryptResult 一次Push Token重新加密的结果
+type PushTokenReencryptResult struct {
+	Total       int
+	Reencrypted int
+	Failed      int
+}
+
+// ReencryptPushTokens 用当前密钥重新加密所有未使用当前密钥加密的Push Token
+// 分批处理并在每批之后记录进度，无法解密的Token跳过并计数
+func ReencryptPushTokens(ctx context.Context, db *sql.DB, encryption *EncryptionService) (PushTokenReencryptResult, error) {
+	var result PushTokenReencryptResult
+	activePrefix := encryption.ActiveKeyID() + ":"
+
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM devices WHERE NOT starts_with(push_token, $1)
+	`, activePrefix).Scan(&result.Total); err != nil {
+		return result, fmt.Errorf("count push tokens to re-encrypt: %w", err)
+	}
+	if result.Total == 0 {
+		return result, nil
+	}
+
+	logger.Info("Push token re-encryption: %d tokens to migrate to key %s", result.Total, encryption.ActiveKeyID())
+
+	lastDeviceID := ""
+	processed := 0
+	for {
+		batch, err := loadPushTokenBatch(ctx, db, activePrefix, lastDeviceID)
+		if err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, device := range batch {
+			lastDeviceID = device.deviceID
+			processed++
+			updated, err := reencryptPushToken(ctx, db, encryption, device)
+			if err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				logger.Error("Push token re-encryption: device %s skipped: %v", device.deviceID, err)
+				result.Failed++
+				continue
+			}
+			if updated {
+				result.Reencrypted++
+			}
+		}
+
+		logger.Info("Push token re-encryption progress: %d/%d (failed=%d)",
+			processed, result.Total, result.Failed)
+	}
+
+	return result, nil
+}
+
+func loadPushTokenBatch(ctx context.Context, db *sql.DB, activePrefix string, afterDeviceID string) ([]storedPushToken, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT device_id::TEXT, push_token
+		FROM devices
+		WHERE NOT starts_with(push_token, $1) AND device_id::TEXT > $2
+		ORDER BY device_id::TEXT
+		LIMIT $3
+	`, activePrefix, afterDeviceID, pushTokenReencryptBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("query push tokens to re-encrypt: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []storedPushToken
+	for rows.Next() {
+		var device storedPushToken
+		if err := rows.Scan(&device.deviceID, &device.encryptedToken); err != nil {
+			return nil, fmt.Errorf("scan push token to re-encrypt: %w", err)
+		}
+		batch = append(batch, device)
+	}
+	return batch, rows.Err()
+}
+
+// reencryptPushToken 返回是否实际写入了新密文
+func reencryptPushToken(ctx context.Context, db *sql.DB, encryption *EncryptionService, device storedPushToken) (bool, error) {
+	pushToken, err := encryption.Decrypt(device.encryptedToken)
+	if err != nil {
+		return false, fmt.Errorf("decrypt: %w", err)
+	}
+
+	reencrypted, err := encryption.Encrypt(pushToken)
+	if err != nil {
+		return false, fmt.Errorf("encrypt: %w", err)
+	}
+
+	// 仅在密文未被并发更新（如 UpdateToken）或设备未被删除时写入
+	res, err := db.ExecContext(ctx, `
+		UPDATE devices SET push_token = $1
+		WHERE device_id = $2 AND push_token = $3
+	`, reencrypted, device.deviceID, device.encryptedToken)
+	if err != nil {
+		return false, fmt.Errorf("update: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("update: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// StartPushTokenReencryption 在后台把Push Token迁移到当前密钥，轮换密钥不会拖慢启动
+func StartPushTokenReencryption(ctx context.Context, db *sql.DB, encryption *EncryptionService) {
+	go func() {
+		started := time.Now()
+		result, err := ReencryptPushTokens(ctx, db, encryption)
+		if err != nil {
+			logger.Error("Push token re-encryption stopped: %v", err)
+			return
+		}
+		if result.Total > 0 {
+			logger.Info("Push token re-encryption finished: %d re-encrypted, %d failed in %v",
+				result.Reencrypted, result.Failed, time.Since(started))
+		}
+	}()
+}
@@ -0,0 +1,378 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PairingCodeTTL 配对码的有效期
+const PairingCodeTTL = 10 * time.Minute
+
+// MaxRecipientDevices 每个接收者最多的设备数，也即一次接收者推送最多扇出的设备数
+const MaxRecipientDevices = 10
+
+const (
+	pairingCodeLength = 8
+	// 32个字符，去掉 0/O 和 1/I，方便对照另一块屏幕输入
+	pairingCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrPairingCodeInvalid = errors.New("pairing code is invalid or expired")
+	ErrRecipientFull      = fmt.Errorf("recipient already has %d devices", MaxRecipientDevices)
+)
+
+// PairingCode 一次性短码，其它设备用它加入签发设备所属的接收者
+type PairingCode struct {
+	RecipientID string `json:"recipientId"`
+	Code        string `json:"code"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+// RecipientDevice 属于接收者的一台设备
+type RecipientDevice struct {
+	DeviceID     string `json:"deviceId"`
+	DeviceType   string `json:"deviceType,omitempty"`
+	AppVersion   string `json:"appVersion,omitempty"`
+	LastActiveAt string `json:"lastActiveAt,omitempty"`
+	JoinedAt     string `json:"joinedAt,omitempty"`
+}
+
+// ConfirmedSibling 接收者消息在其它设备上的副本，因同一消息在某台设备上被确认而一并确认
+type ConfirmedSibling struct {
+	MessageID string
+	DeviceID  string
+	NotifyID  int32 // 该副本通知的 Push Kit notifyId
+}
+
+// CreatePairingCode 为设备所属的接收者签发配对码，设备还没有接收者时先创建
+func CreatePairingCode(ctx context.Context, db *sql.DB, deviceID string) (*PairingCode, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin pairing transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var recipientID sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT recipient_id::TEXT FROM devices
+		WHERE device_id = $1 AND is_active = true
+		FOR UPDATE
+	`, deviceID).Scan(&recipientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock device for pairing: %w", err)
+	}
+
+	if !recipientID.Valid {
+		recipientID.String = uuid.NewString()
+		if _, err := tx.ExecContext(ctx, `INSERT INTO recipients (id) VALUES ($1)`, recipientID.String); err != nil {
+			return nil, fmt.Errorf("create recipient: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE devices SET recipient_id = $1, recipient_joined_at = NOW(), updated_at = NOW()
+			WHERE device_id = $2
+		`, recipientID.String, deviceID); err != nil {
+			return nil, fmt.Errorf("join recipient: %w", err)
+		}
+	}
+
+	code, err := generatePairingCode()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().UTC().Add(PairingCodeTTL)
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO recipient_pairing_codes (code_hash, recipient_id, device_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, hashPairingCode(code), recipientID.String, deviceID, expiresAt); err != nil {
+		return nil, fmt.Errorf("save pairing code: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit pairing transaction: %w", err)
+	}
+	return &PairingCode{
+		RecipientID: recipientID.String,
+		Code:        code,
+		ExpiresAt:   expiresAt.Format(MessageTimeLayout),
+	}, nil
+}
+
+// JoinRecipient 使用配对码将设备移入配对码所属的接收者，原接收者没有设备后被删除
+func JoinRecipient(ctx context.Context, db *sql.DB, deviceID string, code string, actor AuditActor) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin pairing transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT recipient_id::TEXT FROM devices
+		WHERE device_id = $1 AND is_active = true
+		FOR UPDATE
+	`, deviceID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrDeviceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("lock device for pairing: %w", err)
+	}
+
+	var recipientID string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM recipient_pairing_codes
+		WHERE code_hash = $1 AND expires_at > NOW()
+		RETURNING recipient_id::TEXT
+	`, hashPairingCode(NormalizePairingCode(code))).Scan(&recipientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrPairingCodeInvalid
+	}
+	if err != nil {
+		return "", fmt.Errorf("consume pairing code: %w", err)
+	}
+	if previous.String == recipientID {
+		return recipientID, commitRecipientJoin(ctx, tx, deviceID, recipientID, actor)
+	}
+
+	// 锁定接收者，并发加入时按顺序检查设备数上限
+	var members int
+	err = tx.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM devices WHERE recipient_id = r.id)
+		FROM recipients r
+		WHERE r.id = $1
+		FOR UPDATE
+	`, recipientID).Scan(&members)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrPairingCodeInvalid
+	}
+	if err != nil {
+		return "", fmt.Errorf("lock recipient: %w", err)
+	}
+	if members >= MaxRecipientDevices {
+		return "", ErrRecipientFull
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE devices SET recipient_id = $1, recipient_joined_at = NOW(), updated_at = NOW()
+		WHERE device_id = $2
+	`, recipientID, deviceID); err != nil {
+		return "", fmt.Errorf("join recipient: %w", err)
+	}
+	if previous.Valid {
+		if err := deleteEmptyRecipient(ctx, tx, previous.String); err != nil {
+			return "", err
+		}
+	}
+
+	if err := commitRecipientJoin(ctx, tx, deviceID, recipientID, actor); err != nil {
+		return "", err
+	}
+	return recipientID, nil
+}
+
+func commitRecipientJoin(ctx context.Context, tx *sql.Tx, deviceID string, recipientID string, actor AuditActor) error {
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditRecipientJoin, deviceID, map[string]interface{}{"recipientId": recipientID})); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit pairing transaction: %w", err)
+	}
+	return nil
+}
+
+// LeaveRecipient 将设备移出其接收者，返回离开的接收者ID，原本没有接收者时返回空字符串
+func LeaveRecipient(ctx context.Context, db *sql.DB, deviceID string, actor AuditActor) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin pairing transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT recipient_id::TEXT FROM devices WHERE device_id = $1 FOR UPDATE
+	`, deviceID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrDeviceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("lock device for pairing: %w", err)
+	}
+	if !previous.Valid {
+		return "", nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE devices SET recipient_id = NULL, recipient_joined_at = NULL, updated_at = NOW()
+		WHERE device_id = $1
+	`, deviceID); err != nil {
+		return "", fmt.Errorf("leave recipient: %w", err)
+	}
+	if err := deleteEmptyRecipient(ctx, tx, previous.String); err != nil {
+		return "", err
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditRecipientLeave, deviceID, map[string]interface{}{"recipientId": previous.String})); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit pairing transaction: %w", err)
+	}
+	return previous.String, nil
+}
+
+// GetDeviceRecipient 返回设备所属的接收者ID，没有时返回空字符串
+func GetDeviceRecipient(ctx context.Context, db *sql.DB, deviceID string) (string, error) {
+	var recipientID sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT recipient_id::TEXT FROM devices WHERE device_id = $1
+	`, deviceID).Scan(&recipientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrDeviceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("query device recipient: %w", err)
+	}
+	return recipientID.String, nil
+}
+
+// ListRecipientDevices 返回接收者的活跃设备，先加入的在前；接收者不存在时返回空列表
+func ListRecipientDevices(ctx context.Context, db *sql.DB, recipientID string) ([]RecipientDevice, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT device_id, COALESCE(device_type, ''), COALESCE(app_version, ''),
+		       COALESCE(to_char(last_active_at, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), ''),
+		       COALESCE(to_char(recipient_joined_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), '')
+		FROM devices
+		WHERE recipient_id = $1 AND is_active = true
+		ORDER BY recipient_joined_at ASC, device_id ASC
+	`, recipientID)
+	if err != nil {
+		return nil, fmt.Errorf("query recipient devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []RecipientDevice{}
+	for rows.Next() {
+		var device RecipientDevice
+		if err := rows.Scan(&device.DeviceID, &device.DeviceType, &device.AppVersion,
+			&device.LastActiveAt, &device.JoinedAt); err != nil {
+			return nil, fmt.Errorf("scan recipient device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// ConfirmRecipientSiblings 确认 deviceID 刚确认的接收者消息在其它设备上的副本，并同样清除密文
+// 返回的副本需要清除其通知
+func ConfirmRecipientSiblings(ctx context.Context, tx *sql.Tx, deviceID string, messageIDs []string) ([]ConfirmedSibling, error) {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE pending_messages s
+		SET delivered = true,
+		    confirmed_at = NOW(),
+		    encrypted_aes_key = '',
+		    ephemeral_public_key = NULL,
+		    encrypted_content = '',
+		    iv = ''
+		FROM pending_messages m
+		WHERE m.device_id = $1
+		  AND m.id::TEXT = ANY($2)
+		  AND m.recipient_message_id IS NOT NULL
+		  AND s.recipient_message_id = m.recipient_message_id
+		  AND s.device_id <> m.device_id
+		  AND s.delivered = false
+		RETURNING s.id::TEXT, s.device_id, COALESCE(s.notify_id, 0)
+	`, deviceID, pq.Array(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("confirm recipient siblings: %w", err)
+	}
+	defer rows.Close()
+
+	var siblings []ConfirmedSibling
+	for rows.Next() {
+		var sibling ConfirmedSibling
+		if err := rows.Scan(&sibling.MessageID, &sibling.DeviceID, &sibling.NotifyID); err != nil {
+			return nil, fmt.Errorf("scan confirmed sibling: %w", err)
+		}
+		if sibling.NotifyID == 0 {
+			sibling.NotifyID = NotificationID(sibling.MessageID)
+		}
+		siblings = append(siblings, sibling)
+	}
+	return siblings, rows.Err()
+}
+
+// CleanStaleRecipients 删除过期的配对码，以及设备已全部删除的接收者
+func CleanStaleRecipients(ctx context.Context, db *sql.DB) (int64, error) {
+	codes, err := db.ExecContext(ctx, `DELETE FROM recipient_pairing_codes WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired pairing codes: %w", err)
+	}
+	recipients, err := db.ExecContext(ctx, `
+		DELETE FROM recipients r
+		WHERE NOT EXISTS (SELECT 1 FROM devices WHERE recipient_id = r.id)
+		  AND NOT EXISTS (SELECT 1 FROM recipient_pairing_codes WHERE recipient_id = r.id)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("delete empty recipients: %w", err)
+	}
+
+	removedCodes, _ := codes.RowsAffected()
+	removedRecipients, _ := recipients.RowsAffected()
+	return removedCodes + removedRecipients, nil
+}
+
+// NormalizePairingCode 将配对码转为大写，并去掉抄写时输入的分隔符
+func NormalizePairingCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ':
+			return -1
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return r
+		}
+	}, code)
+}
+
+func generatePairingCode() (string, error) {
+	buf := make([]byte, pairingCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate pairing code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = pairingCodeAlphabet[int(b)%len(pairingCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+func hashPairingCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func deleteEmptyRecipient(ctx context.Context, tx *sql.Tx, recipientID string) error {
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM recipients r
+		WHERE r.id = $1
+		  AND NOT EXISTS (SELECT 1 FROM devices WHERE recipient_id = r.id)
+	`, recipientID); err != nil {
+		return fmt.Errorf("delete empty recipient: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGeneratePairingCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		code, err := generatePairingCode()
+		if err != nil {
+			t.Fatalf("generatePairingCode() error = %v", err)
+		}
+		if len(code) != pairingCodeLength {
+			t.Fatalf("code %q has length %d, want %d", code, len(code), pairingCodeLength)
+		}
+		for _, r := range code {
+			if !strings.ContainsRune(pairingCodeAlphabet, r) {
+				t.Fatalf("code %q contains %q outside the alphabet", code, r)
+			}
+		}
+		if NormalizePairingCode(code) != code {
+			t.Fatalf("generated code %q is not normalized", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 45 {
+		t.Fatalf("only %d distinct codes out of 50", len(seen))
+	}
+}
+
+func TestNormalizePairingCode(t *testing.T) {
+	cases := map[string]string{
+		"ABCD2345":   "ABCD2345",
+		"abcd-2345":  "ABCD2345",
+		" abcd 2345": "ABCD2345",
+	}
+	for input, want := range cases {
+		if got := NormalizePairingCode(input); got != want {
+			t.Fatalf("NormalizePairingCode(%q) = %q, want %q", input, got, want)
+		}
+		if hashPairingCode(NormalizePairingCode(input)) != hashPairingCode(want) {
+			t.Fatalf("hash of %q differs from %q", input, want)
+		}
+	}
+}
+
+func TestJoinRecipientConsumesCode(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	owner := insertTestDevice(t, db)
+	joiner := insertTestDevice(t, db)
+	other := insertTestDevice(t, db)
+
+	code, err := CreatePairingCode(ctx, db, owner)
+	if err != nil {
+		t.Fatalf("CreatePairingCode returned error: %v", err)
+	}
+	recipientID, err := JoinRecipient(ctx, db, joiner, strings.ToLower(code.Code), SystemAuditActor)
+	if err != nil || recipientID != code.RecipientID {
+		t.Fatalf("JoinRecipient = %q, %v, want %q", recipientID, err, code.RecipientID)
+	}
+	if _, err := JoinRecipient(ctx, db, other, code.Code, SystemAuditActor); !errors.Is(err, ErrPairingCodeInvalid) {
+		t.Fatalf("reused code error = %v, want ErrPairingCodeInvalid", err)
+	}
+
+	expired, err := CreatePairingCode(ctx, db, owner)
+	if err != nil {
+		t.Fatalf("CreatePairingCode returned error: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE recipient_pairing_codes SET expires_at = NOW() - INTERVAL '1 second'`); err != nil {
+		t.Fatalf("expire codes: %v", err)
+	}
+	if _, err := JoinRecipient(ctx, db, other, expired.Code, SystemAuditActor); !errors.Is(err, ErrPairingCodeInvalid) {
+		t.Fatalf("expired code error = %v, want ErrPairingCodeInvalid", err)
+	}
+}
+
+func TestJoinRecipientEnforcesDeviceCap(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	owner := insertTestDevice(t, db)
+
+	var recipientID string
+	for i := 1; i < MaxRecipientDevices; i++ {
+		code, err := CreatePairingCode(ctx, db, owner)
+		if err != nil {
+			t.Fatalf("CreatePairingCode returned error: %v", err)
+		}
+		recipientID = code.RecipientID
+		if _, err := JoinRecipient(ctx, db, insertTestDevice(t, db), code.Code, SystemAuditActor); err != nil {
+			t.Fatalf("JoinRecipient %d returned error: %v", i, err)
+		}
+	}
+
+	code, err := CreatePairingCode(ctx, db, owner)
+	if err != nil {
+		t.Fatalf("CreatePairingCode returned error: %v", err)
+	}
+	extra := insertTestDevice(t, db)
+	if _, err := JoinRecipient(ctx, db, extra, code.Code, SystemAuditActor); !errors.Is(err, ErrRecipientFull) {
+		t.Fatalf("JoinRecipient past the cap error = %v, want ErrRecipientFull", err)
+	}
+	devices, err := ListRecipientDevices(ctx, db, recipientID)
+	if err != nil || len(devices) != MaxRecipientDevices {
+		t.Fatalf("recipient has %d devices, %v, want %d", len(devices), err, MaxRecipientDevices)
+	}
+}
+
+func TestConfirmRecipientSiblingsConfirmsOtherCopies(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	first := insertTestDevice(t, db)
+	second := insertTestDevice(t, db)
+
+	recipientMessage := uuid.NewString()
+	copyFirst := insertTestMessage(t, db, first, "")
+	copySecond := insertTestMessage(t, db, second, "")
+	unrelated := insertTestMessage(t, db, second, "")
+	if _, err := db.ExecContext(ctx, `
+		UPDATE pending_messages SET recipient_message_id = $1 WHERE id IN ($2, $3)
+	`, recipientMessage, copyFirst, copySecond); err != nil {
+		t.Fatalf("link recipient copies: %v", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	siblings, err := ConfirmRecipientSiblings(ctx, tx, first, []string{copyFirst})
+	if err != nil {
+		t.Fatalf("ConfirmRecipientSiblings returned error: %v", err)
+	}
+	if len(siblings) != 1 || siblings[0].MessageID != copySecond || siblings[0].DeviceID != second || siblings[0].NotifyID == 0 {
+		t.Fatalf("siblings = %+v, want the copy on %s", siblings, second)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	for id, want := range map[string]bool{copySecond: true, unrelated: false} {
+		var delivered bool
+		var content string
+		if err := db.QueryRowContext(ctx, `SELECT delivered, encrypted_content FROM pending_messages WHERE id = $1`, id).Scan(&delivered, &content); err != nil {
+			t.Fatalf("query %s: %v", id, err)
+		}
+		if delivered != want || (want && content != "") {
+			t.Fatalf("message %s delivered = %v, content = %q", id, delivered, content)
+		}
+	}
+}
+
+func TestMergeDevicesCarriesRecipient(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	survivor := insertTestDevice(t, db)
+	duplicate := insertTestDevice(t, db)
+	code, err := CreatePairingCode(ctx, db, duplicate)
+	if err != nil {
+		t.Fatalf("CreatePairingCode returned error: %v", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if err := MergeDevices(ctx, tx, survivor, duplicate, SystemAuditActor); err != nil {
+		t.Fatalf("MergeDevices returned error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	recipientID, err := GetDeviceRecipient(ctx, db, survivor)
+	if err != nil || recipientID != code.RecipientID {
+		t.Fatalf("survivor recipient = %q, %v, want %q", recipientID, err, code.RecipientID)
+	}
+	var joinedAt bool
+	if err := db.QueryRowContext(ctx, `SELECT recipient_joined_at IS NOT NULL FROM devices WHERE device_id = $1`, survivor).Scan(&joinedAt); err != nil || !joinedAt {
+		t.Fatalf("survivor recipient_joined_at set = %v, %v", joinedAt, err)
+	}
+}
+
+func TestMergeDevicesKeepsSurvivorRecipient(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	survivor := insertTestDevice(t, db)
+	duplicate := insertTestDevice(t, db)
+	kept, err := CreatePairingCode(ctx, db, survivor)
+	if err != nil {
+		t.Fatalf("CreatePairingCode returned error: %v", err)
+	}
+	dropped, err := CreatePairingCode(ctx, db, duplicate)
+	if err != nil {
+		t.Fatalf("CreatePairingCode returned error: %v", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if err := MergeDevices(ctx, tx, survivor, duplicate, SystemAuditActor); err != nil {
+		t.Fatalf("MergeDevices returned error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	if recipientID, err := GetDeviceRecipient(ctx, db, survivor); err != nil || recipientID != kept.RecipientID {
+		t.Fatalf("survivor recipient = %q, %v, want %q", recipientID, err, kept.RecipientID)
+	}
+	var remaining int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM recipients WHERE id = $1`, dropped.RecipientID).Scan(&remaining); err != nil || remaining != 0 {
+		t.Fatalf("duplicate's empty recipient left behind: %d, %v", remaining, err)
+	}
+}
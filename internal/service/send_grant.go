@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// MaxDeviceSendGrants 每台设备最多持有的有效发送授权数
+const MaxDeviceSendGrants = 20
+
+// ErrTooManySendGrants 设备的有效发送授权已达 MaxDeviceSendGrants 个
+var ErrTooManySendGrants = fmt.Errorf("device cannot have more than %d active send grants", MaxDeviceSendGrants)
+
+// SendGrantSpec 设备签发给推送方的发送授权
+type SendGrantSpec struct {
+	Name               string `json:"name" binding:"required"`
+	ExpiresInHours     int    `json:"expiresInHours"` // 0 表示永不过期
+	RateLimitPerMinute int    `json:"rateLimitPerMinute"`
+	DailyQuota         int    `json:"dailyQuota"`
+}
+
+// CreateSendGrant 签发属于 deviceID、只能推送到该设备的API Key，返回Key信息和原始Key
+func CreateSendGrant(ctx context.Context, db *sql.DB, deviceID string, spec SendGrantSpec, actor AuditActor) (*APIKey, string, error) {
+	normalized, err := sendGrantAPIKeySpec(deviceID, spec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate send grant key: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin send grant transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 锁定设备行，并发签发时按顺序检查授权数上限
+	var active int
+	err = tx.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM api_keys k
+		        WHERE k.owner_device_id = d.device_id AND k.revoked_at IS NULL
+		          AND (k.expires_at IS NULL OR k.expires_at > NOW()))
+		FROM devices d
+		WHERE d.device_id = $1 AND d.is_active = true
+		FOR UPDATE
+	`, deviceID).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("lock device for send grant: %w", err)
+	}
+	if active >= MaxDeviceSendGrants {
+		return nil, "", ErrTooManySendGrants
+	}
+
+	key := &APIKey{
+		Name:               normalized.Name,
+		KeyPrefix:          apiKeyDisplayPrefix(rawKey),
+		Scopes:             normalized.Scopes,
+		AllowedDeviceIDs:   normalized.AllowedDeviceIDs,
+		AllowedGroups:      normalized.AllowedGroups,
+		RateLimitPerMinute: normalized.RateLimitPerMinute,
+		DailyQuota:         normalized.DailyQuota,
+		OwnerDeviceID:      deviceID,
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO api_keys (
+			name, key_prefix, key_hash, scopes, allowed_device_ids, allowed_groups,
+			rate_limit_per_minute, daily_quota, owner_device_id, expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::uuid,
+		        CASE WHEN $10::INTEGER > 0 THEN NOW() + make_interval(hours => $10::INTEGER) END)
+		RETURNING id::TEXT,
+		          COALESCE(to_char(expires_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), ''),
+		          to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')
+	`, key.Name, key.KeyPrefix, hashAPIKey(rawKey), pq.Array(key.Scopes),
+		pq.Array(key.AllowedDeviceIDs), pq.Array(key.AllowedGroups),
+		key.RateLimitPerMinute, key.DailyQuota, deviceID, spec.ExpiresInHours,
+	).Scan(&key.ID, &key.ExpiresAt, &key.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("insert send grant: %w", err)
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditSendGrantCreate, deviceID, map[string]interface{}{
+		"apiKeyId":  key.ID,
+		"name":      key.Name,
+		"expiresAt": key.ExpiresAt,
+	})); err != nil {
+		return nil, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("commit send grant transaction: %w", err)
+	}
+	return key, rawKey, nil
+}
+
+// ListSendGrants 按创建时间倒序返回设备签发的所有授权，包括已吊销和已过期的，不包含原始Key
+func ListSendGrants(ctx context.Context, db *sql.DB, deviceID string) ([]APIKey, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE owner_device_id = $1::uuid
+		ORDER BY created_at DESC
+	`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("query send grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := []APIKey{}
+	for rows.Next() {
+		grant, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan send grant: %w", err)
+		}
+		grants = append(grants, *grant)
+	}
+	return grants, rows.Err()
+}
+
+// RevokeSendGrant 吊销 deviceID 签发的授权，其它设备的授权和管理员签发的Key返回 ErrAPIKeyNotFound
+func RevokeSendGrant(ctx context.Context, db *sql.DB, deviceID string, id string, actor AuditActor) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin send grant transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, NOW()), updated_at = NOW()
+		WHERE id::TEXT = $1 AND owner_device_id = $2::uuid
+	`, id, deviceID)
+	if err != nil {
+		return fmt.Errorf("revoke send grant: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke send grant: %w", err)
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	if err := RecordAuditEvent(ctx, tx, actor.Event(AuditSendGrantRevoke, deviceID, map[string]interface{}{"apiKeyId": id})); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit send grant transaction: %w", err)
+	}
+	return nil
+}
+
+// sendGrantAPIKeySpec 将授权转换为实际保存的Key：只能推送到签发设备，并撤回或查询自己发送的消息
+func sendGrantAPIKeySpec(deviceID string, spec SendGrantSpec) (APIKeySpec, error) {
+	if spec.ExpiresInHours < 0 {
+		return APIKeySpec{}, fmt.Errorf("%w: expiresInHours cannot be negative", ErrInvalidAPIKeySpec)
+	}
+	return normalizeAPIKeySpec(APIKeySpec{
+		Name:               spec.Name,
+		Scopes:             []string{ScopeNotification, ScopeManage},
+		AllowedDeviceIDs:   []string{deviceID},
+		RateLimitPerMinute: spec.RateLimitPerMinute,
+		DailyQuota:         spec.DailyQuota,
+	})
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSendGrantAPIKeySpecBindsIssuingDevice(t *testing.T) {
+	const deviceID = "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61"
+	spec, err := sendGrantAPIKeySpec(deviceID, SendGrantSpec{Name: " Grafana ", ExpiresInHours: 24, RateLimitPerMinute: 10})
+	if err != nil {
+		t.Fatalf("sendGrantAPIKeySpec returned error: %v", err)
+	}
+
+	if spec.Name != "Grafana" {
+		t.Fatalf("name = %q, want Grafana", spec.Name)
+	}
+	if len(spec.Scopes) != 2 || spec.Scopes[0] != ScopeManage || spec.Scopes[1] != ScopeNotification {
+		t.Fatalf("scopes = %v, want [manage notification]", spec.Scopes)
+	}
+	if len(spec.AllowedDeviceIDs) != 1 || spec.AllowedDeviceIDs[0] != deviceID || len(spec.AllowedGroups) != 0 {
+		t.Fatalf("bindings = %v %v, want only the issuing device", spec.AllowedDeviceIDs, spec.AllowedGroups)
+	}
+	if spec.RateLimitPerMinute != 10 {
+		t.Fatalf("rate limit = %d, want 10", spec.RateLimitPerMinute)
+	}
+}
+
+func TestSendGrantAPIKeySpecRejectsInvalidInput(t *testing.T) {
+	const deviceID = "d5e2a0a0-36a8-4d8b-bcb7-469c7f09fc61"
+	specs := []SendGrantSpec{
+		{Name: " "},
+		{Name: "Alice", ExpiresInHours: -1},
+		{Name: "Alice", RateLimitPerMinute: -1},
+	}
+
+	for _, spec := range specs {
+		if _, err := sendGrantAPIKeySpec(deviceID, spec); !errors.Is(err, ErrInvalidAPIKeySpec) {
+			t.Fatalf("sendGrantAPIKeySpec(%+v) error = %v, want ErrInvalidAPIKeySpec", spec, err)
+		}
+	}
+}
+
+func TestCreateSendGrantEnforcesActiveGrantLimit(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+
+	var first *APIKey
+	for i := 0; i < MaxDeviceSendGrants; i++ {
+		grant, _, err := CreateSendGrant(ctx, db, deviceID, SendGrantSpec{Name: fmt.Sprintf("grant-%d", i)}, SystemAuditActor)
+		if err != nil {
+			t.Fatalf("CreateSendGrant %d returned error: %v", i, err)
+		}
+		if first == nil {
+			first = grant
+		}
+	}
+	if _, _, err := CreateSendGrant(ctx, db, deviceID, SendGrantSpec{Name: "one-too-many"}, SystemAuditActor); !errors.Is(err, ErrTooManySendGrants) {
+		t.Fatalf("CreateSendGrant past the limit error = %v, want ErrTooManySendGrants", err)
+	}
+
+	// Revoked and expired grants no longer count
+	if err := RevokeSendGrant(ctx, db, deviceID, first.ID, SystemAuditActor); err != nil {
+		t.Fatalf("RevokeSendGrant returned error: %v", err)
+	}
+	if _, _, err := CreateSendGrant(ctx, db, deviceID, SendGrantSpec{Name: "replacement"}, SystemAuditActor); err != nil {
+		t.Fatalf("CreateSendGrant after a revoke returned error: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		UPDATE api_keys SET expires_at = NOW() - INTERVAL '1 second'
+		WHERE owner_device_id = $1::uuid AND name = 'grant-1'
+	`, deviceID); err != nil {
+		t.Fatalf("expire grant: %v", err)
+	}
+	if _, _, err := CreateSendGrant(ctx, db, deviceID, SendGrantSpec{Name: "after-expiry"}, SystemAuditActor); err != nil {
+		t.Fatalf("CreateSendGrant after an expiry returned error: %v", err)
+	}
+
+	// The limit is per device
+	if _, _, err := CreateSendGrant(ctx, db, insertTestDevice(t, db), SendGrantSpec{Name: "other"}, SystemAuditActor); err != nil {
+		t.Fatalf("CreateSendGrant for another device returned error: %v", err)
+	}
+}
+
+func TestCreateSendGrantExpiry(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	deviceID := insertTestDevice(t, db)
+	keys := NewAPIKeyService(db)
+
+	grant, rawKey, err := CreateSendGrant(ctx, db, deviceID, SendGrantSpec{Name: "Grafana", ExpiresInHours: 24}, SystemAuditActor)
+	if err != nil {
+		t.Fatalf("CreateSendGrant returned error: %v", err)
+	}
+	expiresAt, err := time.Parse(time.RFC3339, grant.ExpiresAt)
+	if err != nil {
+		t.Fatalf("expiresAt %q: %v", grant.ExpiresAt, err)
+	}
+	if until := time.Until(expiresAt); until < 23*time.Hour || until > 25*time.Hour {
+		t.Fatalf("grant expires in %s, want about 24h", until)
+	}
+	if _, err := keys.Authenticate(ctx, rawKey, ScopeNotification, "203.0.113.7"); err != nil {
+		t.Fatalf("Authenticate before expiry returned error: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE api_keys SET expires_at = NOW() - INTERVAL '1 second' WHERE id = $1`, grant.ID); err != nil {
+		t.Fatalf("expire grant: %v", err)
+	}
+	if _, err := keys.Authenticate(ctx, rawKey, ScopeNotification, "203.0.113.7"); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Fatalf("Authenticate after expiry error = %v, want ErrAPIKeyExpired", err)
+	}
+
+	forever, _, err := CreateSendGrant(ctx, db, deviceID, SendGrantSpec{Name: "Forever"}, SystemAuditActor)
+	if err != nil || forever.ExpiresAt != "" {
+		t.Fatalf("grant without expiry = %+v, %v", forever, err)
+	}
+}
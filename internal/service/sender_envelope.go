@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// MaxEncryptedContentLength 推送方预加密消息的base64密文最大长度
+const MaxEncryptedContentLength = 64 * 1024
+
+// SenderEnvelopeClockSkew 推送方提供的 created_at 与服务器时间允许的最大偏差
+const SenderEnvelopeClockSkew = 5 * time.Minute
+
+var ErrInvalidEnvelope = errors.New("invalid encrypted envelope")
+
+// ValidateSenderEnvelope 检查推送方加密的信封格式是否与设备当前公钥匹配
+// 服务端没有私钥，无法校验密文本身
+func ValidateSenderEnvelope(envelope *EncryptedMessage, keyID string, keyCryptoVersion int) error {
+	if envelope.KeyID != keyID {
+		return fmt.Errorf("%w: key_id %q is not the device's current key", ErrInvalidEnvelope, envelope.KeyID)
+	}
+	if envelope.CryptoVersion != keyCryptoVersion {
+		return fmt.Errorf("%w: crypto_version must be %d for this key", ErrInvalidEnvelope, keyCryptoVersion)
+	}
+	if envelope.EnvelopeVersion != MessageEnvelopeV1 && envelope.EnvelopeVersion != MessageEnvelopeV2 {
+		return fmt.Errorf("%w: unsupported envelope_version %d", ErrInvalidEnvelope, envelope.EnvelopeVersion)
+	}
+
+	if envelope.EncryptedContent == "" || len(envelope.EncryptedContent) > MaxEncryptedContentLength {
+		return fmt.Errorf("%w: encrypted_content must be 1-%d characters", ErrInvalidEnvelope, MaxEncryptedContentLength)
+	}
+	if _, err := base64.StdEncoding.DecodeString(envelope.EncryptedContent); err != nil {
+		return fmt.Errorf("%w: encrypted_content is not base64", ErrInvalidEnvelope)
+	}
+
+	switch envelope.CryptoVersion {
+	case MessageCryptoV1:
+		if envelope.EphemeralPublicKey != "" {
+			return fmt.Errorf("%w: ephemeral_public_key is only used by crypto v2", ErrInvalidEnvelope)
+		}
+		if err := checkBase64Field("encrypted_aes_key", envelope.EncryptedAESKey, 0); err != nil {
+			return err
+		}
+		return checkBase64Field("iv", envelope.IV, 12)
+	case MessageCryptoV2:
+		if envelope.EncryptedAESKey != "" {
+			return fmt.Errorf("%w: encrypted_aes_key is only used by crypto v1", ErrInvalidEnvelope)
+		}
+		if err := checkBase64Field("ephemeral_public_key", envelope.EphemeralPublicKey, 32); err != nil {
+			return err
+		}
+		return checkBase64Field("iv", envelope.IV, chacha20poly1305.NonceSize)
+	default:
+		return fmt.Errorf("%w: unsupported crypto_version %d", ErrInvalidEnvelope, envelope.CryptoVersion)
+	}
+}
+
+// ParseSenderCreatedAt 解析推送方绑定到关联数据中的 created_at，
+// 必须为 MessageTimeLayout 格式且与当前时间相近
+func ParseSenderCreatedAt(value string, now time.Time) (time.Time, error) {
+	createdAt, err := time.Parse(MessageTimeLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: created_at must look like %s", ErrInvalidEnvelope, MessageTimeLayout)
+	}
+	if skew := now.Sub(createdAt); skew > SenderEnvelopeClockSkew || skew < -SenderEnvelopeClockSkew {
+		return time.Time{}, fmt.Errorf("%w: created_at is more than %v from server time", ErrInvalidEnvelope, SenderEnvelopeClockSkew)
+	}
+	return createdAt, nil
+}
+
+func checkBase64Field(name string, value string, wantLen int) error {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return fmt.Errorf("%w: %s must be non-empty base64", ErrInvalidEnvelope, name)
+	}
+	if wantLen > 0 && len(decoded) != wantLen {
+		return fmt.Errorf("%w: %s must be %d bytes", ErrInvalidEnvelope, name, wantLen)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateSenderEnvelopeAcceptsClientSideEnvelopes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+
+	for _, publicKey := range []interface{}{&rsaKey.PublicKey, x25519Key.PublicKey()} {
+		key, err := ParseDevicePublicKey(encodePublicKeyPEM(t, publicKey))
+		if err != nil {
+			t.Fatalf("ParseDevicePublicKey returned error: %v", err)
+		}
+		envelope := encryptTestMessage(t, publicKey, key.CryptoVersion())
+		envelope.KeyID = key.KeyID
+
+		if err := ValidateSenderEnvelope(envelope, key.KeyID, key.CryptoVersion()); err != nil {
+			t.Fatalf("crypto v%d: ValidateSenderEnvelope returned error: %v", key.CryptoVersion(), err)
+		}
+
+		wrongKey := *envelope
+		wrongKey.KeyID = "0000000000000000"
+		wrongVersion := *envelope
+		wrongVersion.EnvelopeVersion = 9
+		badIV := *envelope
+		badIV.IV = "AAAA"
+		noContent := *envelope
+		noContent.EncryptedContent = ""
+		for name, invalid := range map[string]*EncryptedMessage{
+			"key id": &wrongKey, "envelope version": &wrongVersion, "iv": &badIV, "content": &noContent,
+		} {
+			if err := ValidateSenderEnvelope(invalid, key.KeyID, key.CryptoVersion()); !errors.Is(err, ErrInvalidEnvelope) {
+				t.Fatalf("crypto v%d: invalid %s error = %v, want ErrInvalidEnvelope", key.CryptoVersion(), name, err)
+			}
+		}
+	}
+}
+
+func TestParseSenderCreatedAt(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	createdAt, err := ParseSenderCreatedAt("2026-10-19T00:01:00.250Z", now)
+	if err != nil {
+		t.Fatalf("ParseSenderCreatedAt returned error: %v", err)
+	}
+	if !createdAt.Equal(now.Add(time.Minute + 250*time.Millisecond)) {
+		t.Fatalf("createdAt = %v", createdAt)
+	}
+
+	for _, value := range []string{"2026-10-19T00:01:00Z", "2026-10-19T00:01:00.250+08:00", "2026-10-18T23:50:00.000Z"} {
+		if _, err := ParseSenderCreatedAt(value, now); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("ParseSenderCreatedAt(%q) error = %v, want ErrInvalidEnvelope", value, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dengdeng-harmonyos/server/internal/database"
+	"github.com/google/uuid"
+)
+
+// openTestDB connects to TEST_DATABASE_URL and builds the schema the way a
+// deployment does: initial schema, migrations in order, then InitTables.
+// The database must be disposable, its public schema is dropped first.
+// Tests that need PostgreSQL are skipped when the variable is unset.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+		t.Fatalf("reset test database: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "database", "migrations", "*.sql"))
+	if err != nil {
+		t.Fatalf("list migrations: %v", err)
+	}
+	sort.Strings(files)
+	files = append([]string{filepath.Join("..", "..", "database", "001_initial_schema.sql")}, files...)
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		// 初始化脚本按部署时的数据库名设置时区，测试库名称不同
+		var statements []string
+		for _, line := range strings.Split(string(content), "\n") {
+			if !strings.HasPrefix(line, "ALTER DATABASE") {
+				statements = append(statements, line)
+			}
+		}
+		if _, err := db.Exec(strings.Join(statements, "\n")); err != nil {
+			t.Fatalf("apply %s: %v", filepath.Base(file), err)
+		}
+	}
+
+	if err := (&database.Database{DB: db}).InitTables(); err != nil {
+		t.Fatalf("init tables: %v", err)
+	}
+	return db
+}
+
+// insertTestDevice registers an active device and returns its device_id.
+func insertTestDevice(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	deviceID := uuid.NewString()
+	if _, err := db.ExecContext(context.Background(), `
+		INSERT INTO devices (device_id, push_token) VALUES ($1, $2)
+	`, deviceID, "token-"+deviceID); err != nil {
+		t.Fatalf("insert device: %v", err)
+	}
+	return deviceID
+}
+
+// insertTestMessage stores an undelivered message for deviceID encrypted to
+// keyID with the device's next seq and returns its id.
+func insertTestMessage(t *testing.T, db *sql.DB, deviceID string, keyID string) string {
+	t.Helper()
+	var id string
+	if err := db.QueryRowContext(context.Background(), `
+		WITH next AS (
+			UPDATE devices SET message_seq = message_seq + 1
+			WHERE device_id = $1
+			RETURNING message_seq
+		)
+		INSERT INTO pending_messages (device_id, server_name, encrypted_aes_key, encrypted_content, iv, key_id, expires_at, seq)
+		SELECT $1, 'test', 'key', 'content', 'iv', NULLIF($2, ''), NOW() + INTERVAL '1 day', next.message_seq
+		FROM next
+		RETURNING id::TEXT
+	`, deviceID, keyID).Scan(&id); err != nil {
+		t.Fatalf("insert pending message: %v", err)
+	}
+	return id
+}
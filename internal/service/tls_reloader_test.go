@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, commonName string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+}
+
+func leafCommonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse leaf: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertificateReloaderPicksUpRenewedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "fullchain.pem")
+	keyFile := filepath.Join(dir, "privkey.pem")
+	start := time.Now().Add(-time.Hour)
+	writeTestCertificate(t, certFile, keyFile, "old.example.com", start)
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader: %v", err)
+	}
+	if reloaded, err := reloader.Reload(); err != nil || reloaded {
+		t.Fatalf("Reload without changes = %v, %v", reloaded, err)
+	}
+
+	writeTestCertificate(t, certFile, keyFile, "new.example.com", start.Add(time.Minute))
+	if reloaded, err := reloader.Reload(); err != nil || !reloaded {
+		t.Fatalf("Reload after renewal = %v, %v", reloaded, err)
+	}
+
+	cert, _ := reloader.GetCertificate(nil)
+	if got := leafCommonName(t, cert); got != "new.example.com" {
+		t.Fatalf("served certificate = %s, want new.example.com", got)
+	}
+}
+
+func TestCertificateReloaderKeepsCertificateOnBadRenewal(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "fullchain.pem")
+	keyFile := filepath.Join(dir, "privkey.pem")
+	start := time.Now().Add(-time.Hour)
+	writeTestCertificate(t, certFile, keyFile, "old.example.com", start)
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("half-written"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("expected mismatched key pair to fail")
+	}
+
+	cert, _ := reloader.GetCertificate(nil)
+	if got := leafCommonName(t, cert); got != "old.example.com" {
+		t.Fatalf("served certificate = %s, want old.example.com", got)
+	}
+}
+
+func TestParseTLSMinVersion(t *testing.T) {
+	if v, err := ParseTLSMinVersion(""); err != nil || v != tls.VersionTLS12 {
+		t.Fatalf("default = %x, %v", v, err)
+	}
+	if v, err := ParseTLSMinVersion("1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Fatalf("1.3 = %x, %v", v, err)
+	}
+	if _, err := ParseTLSMinVersion("1.0"); err == nil {
+		t.Fatal("expected TLS 1.0 to be rejected")
+	}
+}